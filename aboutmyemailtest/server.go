@@ -0,0 +1,598 @@
+// Package aboutmyemailtest provides an in-memory AboutMy.email API server,
+// for testing code that uses the aboutmyemail client without a live server.
+package aboutmyemailtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wttw/aboutmyemail"
+)
+
+// apiPath is where the API lives on the server, as on the real one
+const apiPath = "/api/v1"
+
+// maxPayload is the largest submission the server accepts, larger ones get a 413
+const maxPayload = 32 << 20
+
+// Submission records a message submitted to the server
+type Submission struct {
+	ID             string
+	From           string
+	To             string
+	Ip             string
+	Helo           string
+	Smtputf8       bool
+	Options        string
+	Token          string
+	ProgressUrl    string
+	FinishedUrl    string
+	CallbackSecret string
+	// ContentType is the content type the submission was made with,
+	// application/json or multipart/form-data
+	ContentType string
+	// Payload is the message exactly as received
+	Payload []byte
+	// Received is when the server received the submission
+	Received time.Time
+
+	polls    int
+	finished bool
+}
+
+type injected struct {
+	method  string
+	path    string
+	status  int
+	message string
+}
+
+// Server is a fake AboutMy.email API server listening on a local port.
+type Server struct {
+	*httptest.Server
+	// Endpoint is the base URL of the API, for aboutmyemail.WithServer
+	Endpoint string
+
+	apiKey     string
+	messages   []string
+	retryAfter time.Duration
+	report     func(Submission) aboutmyemail.Report
+
+	mtx          sync.Mutex
+	nextID       int
+	submissions  []*Submission
+	byID         map[string]*Submission
+	byIdempotent map[string]*Submission
+	failures     []injected
+	throttle     int
+	staged       map[string][]byte
+	published    map[string][]byte
+	callbacks    sync.WaitGroup
+	httpClient   *http.Client
+}
+
+// Option configures a Server
+type Option func(*Server)
+
+// WithAPIKey makes the server reject requests without this bearer key
+func WithAPIKey(key string) Option {
+	return func(s *Server) {
+		s.apiKey = key
+	}
+}
+
+// WithMessages sets the progress messages each submission reports, in order.
+// Each status poll returns the next one; callbacks are sent for all of them.
+func WithMessages(messages ...string) Option {
+	return func(s *Server) {
+		s.messages = messages
+	}
+}
+
+// WithRetryAfter sets the Retry-After the server sends with throttled responses
+func WithRetryAfter(d time.Duration) Option {
+	return func(s *Server) {
+		s.retryAfter = d
+	}
+}
+
+// WithReport sets the function used to build the analysis report for a
+// submission. The default reports a pass, with the session section filled
+// in from the submission's envelope.
+func WithReport(fn func(Submission) aboutmyemail.Report) Option {
+	return func(s *Server) {
+		s.report = fn
+	}
+}
+
+// NewServer starts and returns a new Server. The caller should call Close
+// when finished, to shut it down.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		messages:     []string{"Received message", "Checking authentication", "Rendering"},
+		byID:         map[string]*Submission{},
+		byIdempotent: map[string]*Submission{},
+		staged:       map[string][]byte{},
+		published:    map[string][]byte{},
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		report:       DefaultReport,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	s.Server = httptest.NewServer(s)
+	s.Endpoint = s.URL + apiPath
+	return s
+}
+
+// Close shuts down the server, after waiting for any callbacks in flight
+func (s *Server) Close() {
+	s.callbacks.Wait()
+	s.Server.Close()
+}
+
+// Client returns an API client configured to talk to this server, with the
+// server's API key if it has one
+func (s *Server) Client(opts ...aboutmyemail.ClientOption) (*aboutmyemail.ClientWithResponses, error) {
+	opts = append([]aboutmyemail.ClientOption{aboutmyemail.WithApiKey(s.apiKey)}, opts...)
+	return aboutmyemail.NewClientWithResponses(s.Endpoint, opts...)
+}
+
+// InjectError makes the next request for method and path (relative to the
+// endpoint, e.g. "/emails") fail with status and message. Empty method or
+// path match any request. Injected errors are used once each, in order.
+func (s *Server) InjectError(method, path string, status int, message string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.failures = append(s.failures, injected{method: method, path: path, status: status, message: message})
+}
+
+// Throttle makes the next n requests fail with 429 Too Many Requests
+func (s *Server) Throttle(n int) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.throttle = n
+}
+
+// Submissions returns everything submitted so far, in order
+func (s *Server) Submissions() []Submission {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	ret := make([]Submission, len(s.submissions))
+	for i, sub := range s.submissions {
+		ret[i] = *sub
+	}
+	return ret
+}
+
+// Content returns the whitelabel content files uploaded, either those staged
+// or those published
+func (s *Server) Content(staged bool) map[string][]byte {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	src := s.published
+	if staged {
+		src = s.staged
+	}
+	ret := make(map[string][]byte, len(src))
+	for k, v := range src {
+		ret[k] = bytes.Clone(v)
+	}
+	return ret
+}
+
+// ResultURL is the result url the server reports for a submission
+func (s *Server) ResultURL(id string) string {
+	return s.URL + "/" + id
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, ok := strings.CutPrefix(r.URL.Path, apiPath)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if s.apiKey != "" && r.Header.Get("Authorization") != "Bearer "+s.apiKey {
+		writeError(w, http.StatusUnauthorized, "missing or invalid API key")
+		return
+	}
+	if s.injectedFailure(w, r.Method, path) {
+		return
+	}
+	switch {
+	case path == "/emails" && r.Method == http.MethodGet:
+		s.list(w, r)
+	case path == "/emails" && r.Method == http.MethodPost:
+		s.submit(w, r)
+	case strings.HasPrefix(path, "/emails/") && strings.HasSuffix(path, "/report") && r.Method == http.MethodGet:
+		s.analysis(w, strings.TrimSuffix(strings.TrimPrefix(path, "/emails/"), "/report"))
+	case strings.HasPrefix(path, "/emails/") && r.Method == http.MethodGet:
+		s.status(w, strings.TrimPrefix(path, "/emails/"))
+	case path == "/style/content" && r.Method == http.MethodPost:
+		s.content(w, r)
+	case path == "/style/publish" && r.Method == http.MethodPost:
+		s.publish(w)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// injectedFailure writes a throttle or injected error response if one is due
+func (s *Server) injectedFailure(w http.ResponseWriter, method, path string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.throttle > 0 {
+		s.throttle--
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		writeError(w, http.StatusTooManyRequests, "too many requests")
+		return true
+	}
+	for i, f := range s.failures {
+		if (f.method == "" || f.method == method) && (f.path == "" || f.path == path) {
+			s.failures = append(s.failures[:i], s.failures[i+1:]...)
+			writeError(w, f.status, f.message)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxPayload)
+	sub, err := parseSubmission(r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "payload too large")
+			return
+		}
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mtx.Lock()
+	key := r.Header.Get("Idempotency-Key")
+	if previous, ok := s.byIdempotent[key]; ok && key != "" {
+		s.mtx.Unlock()
+		writeJSON(w, http.StatusOK, aboutmyemail.SubmitSuccess{Id: previous.ID})
+		return
+	}
+	s.nextID++
+	sub.ID = fmt.Sprintf("test%06d", s.nextID)
+	sub.Received = time.Now()
+	s.submissions = append(s.submissions, sub)
+	s.byID[sub.ID] = sub
+	if key != "" {
+		s.byIdempotent[key] = sub
+	}
+	if sub.ProgressUrl != "" || sub.FinishedUrl != "" {
+		s.callbacks.Add(1)
+		go s.sendCallbacks(*sub)
+	}
+	s.mtx.Unlock()
+
+	writeJSON(w, http.StatusOK, aboutmyemail.SubmitSuccess{Id: sub.ID})
+}
+
+func parseSubmission(r *http.Request) (*Submission, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("bad content type: %w", err)
+	}
+	sub := &Submission{ContentType: mediaType}
+	switch mediaType {
+	case "application/json":
+		var body aboutmyemail.Submit
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			return nil, fmt.Errorf("bad json: %w", err)
+		}
+		sub.From, sub.To, sub.Ip = body.From, body.To, body.Ip
+		sub.Payload = []byte(body.Payload)
+		sub.Helo = deref(body.Helo)
+		sub.Smtputf8 = body.Smtputf8 != nil && *body.Smtputf8
+		sub.Options = deref(body.Options)
+		sub.Token = deref(body.Token)
+		sub.ProgressUrl = deref(body.ProgressUrl)
+		sub.FinishedUrl = deref(body.FinishedUrl)
+		sub.CallbackSecret = deref(body.CallbackSecret)
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxPayload); err != nil {
+			return nil, fmt.Errorf("bad form: %w", err)
+		}
+		form := r.MultipartForm
+		value := func(name string) string {
+			if v := form.Value[name]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+		sub.From, sub.To, sub.Ip = value("from"), value("to"), value("ip")
+		sub.Helo = value("helo")
+		sub.Smtputf8 = value("smtputf8") == "true"
+		sub.Options = value("options")
+		sub.Token = value("token")
+		sub.ProgressUrl = value("progressUrl")
+		sub.FinishedUrl = value("finishedUrl")
+		sub.CallbackSecret = value("callbackSecret")
+		files := form.File["payload"]
+		if len(files) == 0 {
+			return nil, errors.New("missing payload")
+		}
+		f, err := files[0].Open()
+		if err != nil {
+			return nil, err
+		}
+		sub.Payload, err = io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported content type %s", mediaType)
+	}
+	if sub.From == "" || sub.To == "" || sub.Ip == "" || len(sub.Payload) == 0 {
+		return nil, errors.New("payload, from, to and ip are required")
+	}
+	return sub, nil
+}
+
+// list returns the submissions matching the query, newest first. The cursor
+// is just the offset into the matching list.
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, offset := 50, 0
+	var err error
+	if v := query.Get("limit"); v != "" {
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 500 {
+			writeError(w, http.StatusBadRequest, "bad limit")
+			return
+		}
+	}
+	if v := query.Get("cursor"); v != "" {
+		offset, err = strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			writeError(w, http.StatusBadRequest, "bad cursor")
+			return
+		}
+	}
+	var after, before time.Time
+	for name, t := range map[string]*time.Time{"after": &after, "before": &before} {
+		if v := query.Get(name); v != "" {
+			*t, err = time.Parse(time.RFC3339, v)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad "+name)
+				return
+			}
+		}
+	}
+	staged := query.Get("staged")
+	inDomain := func(address, domain string) bool {
+		at := strings.LastIndex(address, "@")
+		return domain == "" || (at >= 0 && strings.EqualFold(address[at+1:], domain))
+	}
+
+	s.mtx.Lock()
+	var matches []aboutmyemail.EmailSummary
+	for i := len(s.submissions) - 1; i >= 0; i-- {
+		sub := s.submissions[i]
+		isStaged := sub.Options == "stage"
+		switch {
+		case !after.IsZero() && sub.Received.Before(after),
+			!before.IsZero() && !sub.Received.Before(before),
+			query.Has("token") && sub.Token != query.Get("token"),
+			!inDomain(sub.From, query.Get("fromDomain")),
+			!inDomain(sub.To, query.Get("toDomain")),
+			staged != "" && strconv.FormatBool(isStaged) != staged:
+			continue
+		}
+		summary := aboutmyemail.EmailSummary{
+			Id:        sub.ID,
+			Submitted: sub.Received,
+			From:      sub.From,
+			To:        sub.To,
+			Ip:        sub.Ip,
+			Staged:    &isStaged,
+		}
+		if sub.Helo != "" {
+			helo := sub.Helo
+			summary.Helo = &helo
+		}
+		if sub.Token != "" {
+			token := sub.Token
+			summary.Token = &token
+		}
+		if sub.finished {
+			url := s.ResultURL(sub.ID)
+			summary.Url = &url
+		}
+		matches = append(matches, summary)
+	}
+	s.mtx.Unlock()
+
+	result := aboutmyemail.EmailList{Emails: []aboutmyemail.EmailSummary{}}
+	if offset < len(matches) {
+		end := min(offset+limit, len(matches))
+		result.Emails = matches[offset:end]
+		if end < len(matches) {
+			next := strconv.Itoa(end)
+			result.NextCursor = &next
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// status returns the next scripted message for a submission, and its
+// result url once they've all been returned
+func (s *Server) status(w http.ResponseWriter, id string) {
+	s.mtx.Lock()
+	sub, ok := s.byID[id]
+	if !ok {
+		s.mtx.Unlock()
+		writeError(w, http.StatusNotFound, "no such result")
+		return
+	}
+	result := aboutmyemail.StatusResult{Id: id}
+	if sub.Token != "" {
+		token := sub.Token
+		result.Token = &token
+	}
+	if sub.polls < len(s.messages) {
+		result.Messages = &[]string{s.messages[sub.polls]}
+	} else {
+		url := s.ResultURL(id)
+		result.Url = &url
+		sub.finished = true
+	}
+	sub.polls++
+	s.mtx.Unlock()
+	writeJSON(w, http.StatusOK, result)
+}
+
+// analysis returns the report for a submission, once it has finished processing
+func (s *Server) analysis(w http.ResponseWriter, id string) {
+	s.mtx.Lock()
+	sub, ok := s.byID[id]
+	var snapshot Submission
+	if ok {
+		snapshot = *sub
+	}
+	s.mtx.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such result")
+		return
+	}
+	if !snapshot.finished {
+		writeError(w, http.StatusConflict, "still processing")
+		return
+	}
+	report := s.report(snapshot)
+	report.Id = id
+	url := s.ResultURL(id)
+	report.Url = &url
+	writeJSON(w, http.StatusOK, report)
+}
+
+// DefaultReport is the analysis report the server returns unless
+// configured otherwise with WithReport.
+func DefaultReport(sub Submission) aboutmyemail.Report {
+	return aboutmyemail.Report{
+		Verdict: aboutmyemail.VerdictPass,
+		Session: &aboutmyemail.SessionSection{
+			Verdict: aboutmyemail.VerdictPass,
+			Ip:      &sub.Ip,
+			Helo:    &sub.Helo,
+			From:    &sub.From,
+			To:      &sub.To,
+		},
+	}
+}
+
+// sendCallbacks posts each progress message, then the final result, to a
+// submission's callback urls
+func (s *Server) sendCallbacks(sub Submission) {
+	defer s.callbacks.Done()
+	var token *string
+	if sub.Token != "" {
+		token = &sub.Token
+	}
+	if sub.ProgressUrl != "" {
+		for _, msg := range s.messages {
+			s.callback(sub.ProgressUrl, sub.CallbackSecret, aboutmyemail.StatusResult{Id: sub.ID, Token: token, Messages: &[]string{msg}})
+		}
+	}
+	s.mtx.Lock()
+	s.byID[sub.ID].finished = true
+	s.mtx.Unlock()
+	if sub.FinishedUrl != "" {
+		url := s.ResultURL(sub.ID)
+		s.callback(sub.FinishedUrl, sub.CallbackSecret, aboutmyemail.StatusResult{Id: sub.ID, Token: token, Url: &url})
+	}
+}
+
+func (s *Server) callback(url string, secret string, result aboutmyemail.StatusResult) {
+	body, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(aboutmyemail.CallbackSignatureHeader, aboutmyemail.SignCallback(secret, body, time.Now()))
+	}
+	rsp, err := s.httpClient.Do(req)
+	if err != nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, rsp.Body)
+	_ = rsp.Body.Close()
+}
+
+func (s *Server) content(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(maxPayload); err != nil {
+		writeError(w, http.StatusBadRequest, "bad form: "+err.Error())
+		return
+	}
+	var messages []string
+	for _, fh := range r.MultipartForm.File["filename"] {
+		f, err := fh.Open()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		content, err := io.ReadAll(f)
+		_ = f.Close()
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.mtx.Lock()
+		s.staged[fh.Filename] = content
+		s.mtx.Unlock()
+		messages = append(messages, fmt.Sprintf("%s: staged %d bytes", fh.Filename, len(content)))
+	}
+	if len(messages) == 0 {
+		writeError(w, http.StatusBadRequest, "no files uploaded")
+		return
+	}
+	writeJSON(w, http.StatusOK, aboutmyemail.UploadResult{Messages: messages})
+}
+
+func (s *Server) publish(w http.ResponseWriter) {
+	s.mtx.Lock()
+	for k, v := range s.staged {
+		s.published[k] = bytes.Clone(v)
+	}
+	s.mtx.Unlock()
+	w.WriteHeader(http.StatusOK)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, aboutmyemail.N400Error{Message: message})
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -14,15 +14,28 @@ const envMyemailApikey = "MYEMAIL_APIKEY"
 //go:generate go run github.com/deepmap/oapi-codegen/v2/cmd/oapi-codegen@latest --config=api-model.cfg.yaml ameapi.yaml
 //go:generate go run github.com/deepmap/oapi-codegen/v2/cmd/oapi-codegen@latest --config=api-client.cfg.yaml ameapi.yaml
 
-// New creates a new ClientWithResponses for AboutMy.email, with reasonable defaults
+// New creates a new ClientWithResponses for AboutMy.email, with reasonable
+// defaults. Settings are taken from opts, then the MYEMAIL_SERVER and
+// MYEMAIL_APIKEY environment variables, then the profile from the config
+// file selected by MYEMAIL_PROFILE, in that order of precedence. A config
+// file that can't be read is only an error if MYEMAIL_PROFILE selects a
+// profile from it; otherwise the default profile is skipped.
 func New(opts ...ClientOption) (*ClientWithResponses, error) {
+	profile, err := LoadProfile("", "")
+	if err != nil {
+		if os.Getenv(envMyemailProfile) != "" {
+			return nil, err
+		}
+		profile = Profile{}
+	}
+	defaults := []ClientOption{WithServer(profile.Server), WithApiKey(profile.ApiKey)}
 	if server := os.Getenv(envMyemailServer); server != "" {
-		opts = append([]ClientOption{WithServer(server)}, opts...)
+		defaults = append(defaults, WithServer(server))
 	}
 	if apikey := os.Getenv(envMyemailApikey); apikey != "" {
-		opts = append([]ClientOption{WithApiKey(apikey)}, opts...)
+		defaults = append(defaults, WithApiKey(apikey))
 	}
-	return NewClientWithResponses(apiEndpoint, opts...)
+	return NewClientWithResponses(apiEndpoint, append(defaults, opts...)...)
 }
 
 func doNothing(*Client) error {
@@ -89,6 +89,9 @@ func WithRequestEditorFn(fn RequestEditorFn) ClientOption {
 
 // The interface specification for the client above.
 type ClientInterface interface {
+	// EmailList request
+	EmailList(ctx context.Context, params *EmailListParams, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// EmailWithBody request with any body
 	EmailWithBody(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
 
@@ -97,6 +100,9 @@ type ClientInterface interface {
 	// EmailStatus request
 	EmailStatus(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*http.Response, error)
 
+	// EmailReport request
+	EmailReport(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*http.Response, error)
+
 	// ContentPostWithBody request with any body
 	ContentPostWithBody(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error)
 
@@ -104,6 +110,18 @@ type ClientInterface interface {
 	StylePublish(ctx context.Context, reqEditors ...RequestEditorFn) (*http.Response, error)
 }
 
+func (c *Client) EmailList(ctx context.Context, params *EmailListParams, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewEmailListRequest(c.Server, params)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) EmailWithBody(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewEmailRequestWithBody(c.Server, contentType, body)
 	if err != nil {
@@ -140,6 +158,18 @@ func (c *Client) EmailStatus(ctx context.Context, resultID string, reqEditors ..
 	return c.Client.Do(req)
 }
 
+func (c *Client) EmailReport(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*http.Response, error) {
+	req, err := NewEmailReportRequest(c.Server, resultID)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if err := c.applyEditors(ctx, req, reqEditors); err != nil {
+		return nil, err
+	}
+	return c.Client.Do(req)
+}
+
 func (c *Client) ContentPostWithBody(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*http.Response, error) {
 	req, err := NewContentPostRequestWithBody(c.Server, contentType, body)
 	if err != nil {
@@ -164,6 +194,167 @@ func (c *Client) StylePublish(ctx context.Context, reqEditors ...RequestEditorFn
 	return c.Client.Do(req)
 }
 
+// NewEmailListRequest generates requests for EmailList
+func NewEmailListRequest(server string, params *EmailListParams) (*http.Request, error) {
+	var err error
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/emails")
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if params != nil {
+		queryValues := queryURL.Query()
+
+		if params.After != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "after", runtime.ParamLocationQuery, *params.After); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Before != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "before", runtime.ParamLocationQuery, *params.Before); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Token != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "token", runtime.ParamLocationQuery, *params.Token); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.FromDomain != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "fromDomain", runtime.ParamLocationQuery, *params.FromDomain); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.ToDomain != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "toDomain", runtime.ParamLocationQuery, *params.ToDomain); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Staged != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "staged", runtime.ParamLocationQuery, *params.Staged); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Limit != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "limit", runtime.ParamLocationQuery, *params.Limit); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		if params.Cursor != nil {
+
+			if queryFrag, err := runtime.StyleParamWithLocation("form", true, "cursor", runtime.ParamLocationQuery, *params.Cursor); err != nil {
+				return nil, err
+			} else if parsed, err := url.ParseQuery(queryFrag); err != nil {
+				return nil, err
+			} else {
+				for k, v := range parsed {
+					for _, v2 := range v {
+						queryValues.Add(k, v2)
+					}
+				}
+			}
+
+		}
+
+		queryURL.RawQuery = queryValues.Encode()
+	}
+
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
 // NewEmailRequest calls the generic Email builder with application/json body
 func NewEmailRequest(server string, body EmailJSONRequestBody) (*http.Request, error) {
 	var bodyReader io.Reader
@@ -238,6 +429,40 @@ func NewEmailStatusRequest(server string, resultID string) (*http.Request, error
 	return req, nil
 }
 
+// NewEmailReportRequest generates requests for EmailReport
+func NewEmailReportRequest(server string, resultID string) (*http.Request, error) {
+	var err error
+
+	var pathParam0 string
+
+	pathParam0, err = runtime.StyleParamWithLocation("simple", false, "resultID", runtime.ParamLocationPath, resultID)
+	if err != nil {
+		return nil, err
+	}
+
+	serverURL, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+
+	operationPath := fmt.Sprintf("/emails/%s/report", pathParam0)
+	if operationPath[0] == '/' {
+		operationPath = "." + operationPath
+	}
+
+	queryURL, err := serverURL.Parse(operationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("GET", queryURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
 // NewContentPostRequestWithBody generates requests for ContentPost with any type of body
 func NewContentPostRequestWithBody(server string, contentType string, body io.Reader) (*http.Request, error) {
 	var err error
@@ -337,6 +562,9 @@ func WithBaseURL(baseURL string) ClientOption {
 
 // ClientWithResponsesInterface is the interface specification for the client with responses above.
 type ClientWithResponsesInterface interface {
+	// EmailListWithResponse request
+	EmailListWithResponse(ctx context.Context, params *EmailListParams, reqEditors ...RequestEditorFn) (*EmailListResponse, error)
+
 	// EmailWithBodyWithResponse request with any body
 	EmailWithBodyWithResponse(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*EmailResponse, error)
 
@@ -345,6 +573,9 @@ type ClientWithResponsesInterface interface {
 	// EmailStatusWithResponse request
 	EmailStatusWithResponse(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*EmailStatusResponse, error)
 
+	// EmailReportWithResponse request
+	EmailReportWithResponse(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*EmailReportResponse, error)
+
 	// ContentPostWithBodyWithResponse request with any body
 	ContentPostWithBodyWithResponse(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*ContentPostResponse, error)
 
@@ -352,11 +583,42 @@ type ClientWithResponsesInterface interface {
 	StylePublishWithResponse(ctx context.Context, reqEditors ...RequestEditorFn) (*StylePublishResponse, error)
 }
 
+type EmailListResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *EmailList
+	JSON400      *N400Error
+	JSON401      *N400Error
+	JSON403      *N400Error
+	JSON429      *N400Error
+	JSON500      *N500Error
+}
+
+// Status returns HTTPResponse.Status
+func (r EmailListResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r EmailListResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
 type EmailResponse struct {
 	Body         []byte
 	HTTPResponse *http.Response
 	JSON200      *SubmitSuccess
 	JSON400      *N400Error
+	JSON401      *N400Error
+	JSON403      *N400Error
+	JSON413      *N400Error
+	JSON429      *N400Error
 	JSON500      *N500Error
 }
 
@@ -380,7 +642,10 @@ type EmailStatusResponse struct {
 	Body         []byte
 	HTTPResponse *http.Response
 	JSON200      *StatusResult
+	JSON401      *N400Error
+	JSON403      *N400Error
 	JSON404      *N400Error
+	JSON429      *N400Error
 	JSON500      *N500Error
 }
 
@@ -400,11 +665,43 @@ func (r EmailStatusResponse) StatusCode() int {
 	return 0
 }
 
+type EmailReportResponse struct {
+	Body         []byte
+	HTTPResponse *http.Response
+	JSON200      *Report
+	JSON401      *N400Error
+	JSON403      *N400Error
+	JSON404      *N400Error
+	JSON409      *N400Error
+	JSON429      *N400Error
+	JSON500      *N500Error
+}
+
+// Status returns HTTPResponse.Status
+func (r EmailReportResponse) Status() string {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.Status
+	}
+	return http.StatusText(0)
+}
+
+// StatusCode returns HTTPResponse.StatusCode
+func (r EmailReportResponse) StatusCode() int {
+	if r.HTTPResponse != nil {
+		return r.HTTPResponse.StatusCode
+	}
+	return 0
+}
+
 type ContentPostResponse struct {
 	Body         []byte
 	HTTPResponse *http.Response
 	JSON200      *UploadResult
 	JSON400      *N500Error
+	JSON401      *N400Error
+	JSON403      *N400Error
+	JSON413      *N400Error
+	JSON429      *N400Error
 	JSON500      *N500Error
 }
 
@@ -428,6 +725,9 @@ type StylePublishResponse struct {
 	Body         []byte
 	HTTPResponse *http.Response
 	JSON400      *N500Error
+	JSON401      *N400Error
+	JSON403      *N400Error
+	JSON429      *N400Error
 	JSON500      *N500Error
 }
 
@@ -447,6 +747,15 @@ func (r StylePublishResponse) StatusCode() int {
 	return 0
 }
 
+// EmailListWithResponse request returning *EmailListResponse
+func (c *ClientWithResponses) EmailListWithResponse(ctx context.Context, params *EmailListParams, reqEditors ...RequestEditorFn) (*EmailListResponse, error) {
+	rsp, err := c.EmailList(ctx, params, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEmailListResponse(rsp)
+}
+
 // EmailWithBodyWithResponse request with arbitrary body returning *EmailResponse
 func (c *ClientWithResponses) EmailWithBodyWithResponse(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*EmailResponse, error) {
 	rsp, err := c.EmailWithBody(ctx, contentType, body, reqEditors...)
@@ -473,6 +782,15 @@ func (c *ClientWithResponses) EmailStatusWithResponse(ctx context.Context, resul
 	return ParseEmailStatusResponse(rsp)
 }
 
+// EmailReportWithResponse request returning *EmailReportResponse
+func (c *ClientWithResponses) EmailReportWithResponse(ctx context.Context, resultID string, reqEditors ...RequestEditorFn) (*EmailReportResponse, error) {
+	rsp, err := c.EmailReport(ctx, resultID, reqEditors...)
+	if err != nil {
+		return nil, err
+	}
+	return ParseEmailReportResponse(rsp)
+}
+
 // ContentPostWithBodyWithResponse request with arbitrary body returning *ContentPostResponse
 func (c *ClientWithResponses) ContentPostWithBodyWithResponse(ctx context.Context, contentType string, body io.Reader, reqEditors ...RequestEditorFn) (*ContentPostResponse, error) {
 	rsp, err := c.ContentPostWithBody(ctx, contentType, body, reqEditors...)
@@ -491,6 +809,67 @@ func (c *ClientWithResponses) StylePublishWithResponse(ctx context.Context, reqE
 	return ParseStylePublishResponse(rsp)
 }
 
+// ParseEmailListResponse parses an HTTP response from a EmailListWithResponse call
+func ParseEmailListResponse(rsp *http.Response) (*EmailListResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &EmailListResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest EmailList
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 400:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON400 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest N500Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	}
+
+	return response, nil
+}
+
 // ParseEmailResponse parses an HTTP response from a EmailWithResponse call
 func ParseEmailResponse(rsp *http.Response) (*EmailResponse, error) {
 	bodyBytes, err := io.ReadAll(rsp.Body)
@@ -519,6 +898,34 @@ func ParseEmailResponse(rsp *http.Response) (*EmailResponse, error) {
 		}
 		response.JSON400 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 413:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON413 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest N500Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -552,6 +959,20 @@ func ParseEmailStatusResponse(rsp *http.Response) (*EmailStatusResponse, error)
 		}
 		response.JSON200 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
 		var dest N400Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -559,6 +980,81 @@ func ParseEmailStatusResponse(rsp *http.Response) (*EmailStatusResponse, error)
 		}
 		response.JSON404 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
+		var dest N500Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON500 = &dest
+
+	}
+
+	return response, nil
+}
+
+// ParseEmailReportResponse parses an HTTP response from a EmailReportWithResponse call
+func ParseEmailReportResponse(rsp *http.Response) (*EmailReportResponse, error) {
+	bodyBytes, err := io.ReadAll(rsp.Body)
+	defer func() { _ = rsp.Body.Close() }()
+	if err != nil {
+		return nil, err
+	}
+
+	response := &EmailReportResponse{
+		Body:         bodyBytes,
+		HTTPResponse: rsp,
+	}
+
+	switch {
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 200:
+		var dest Report
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON200 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 404:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON404 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 409:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON409 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest N500Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -599,6 +1095,34 @@ func ParseContentPostResponse(rsp *http.Response) (*ContentPostResponse, error)
 		}
 		response.JSON400 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 413:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON413 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest N500Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -632,6 +1156,27 @@ func ParseStylePublishResponse(rsp *http.Response) (*StylePublishResponse, error
 		}
 		response.JSON400 = &dest
 
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 401:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON401 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 403:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON403 = &dest
+
+	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 429:
+		var dest N400Error
+		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
+			return nil, err
+		}
+		response.JSON429 = &dest
+
 	case strings.Contains(rsp.Header.Get("Content-Type"), "json") && rsp.StatusCode == 500:
 		var dest N500Error
 		if err := json.Unmarshal(bodyBytes, &dest); err != nil {
@@ -4,6 +4,8 @@
 package aboutmyemail
 
 import (
+	"time"
+
 	openapi_types "github.com/oapi-codegen/runtime/types"
 )
 
@@ -11,6 +13,46 @@ const (
 	BearerAuthScopes = "BearerAuth.Scopes"
 )
 
+// Defines values for DkimSignatureResult.
+const (
+	DkimSignatureResultFail      DkimSignatureResult = "fail"
+	DkimSignatureResultNeutral   DkimSignatureResult = "neutral"
+	DkimSignatureResultNone      DkimSignatureResult = "none"
+	DkimSignatureResultPass      DkimSignatureResult = "pass"
+	DkimSignatureResultPermerror DkimSignatureResult = "permerror"
+	DkimSignatureResultPolicy    DkimSignatureResult = "policy"
+	DkimSignatureResultTemperror DkimSignatureResult = "temperror"
+)
+
+// Defines values for DmarcSectionResult.
+const (
+	DmarcSectionResultFail      DmarcSectionResult = "fail"
+	DmarcSectionResultNone      DmarcSectionResult = "none"
+	DmarcSectionResultPass      DmarcSectionResult = "pass"
+	DmarcSectionResultPermerror DmarcSectionResult = "permerror"
+	DmarcSectionResultTemperror DmarcSectionResult = "temperror"
+)
+
+// Defines values for SpfSectionResult.
+const (
+	SpfSectionResultFail      SpfSectionResult = "fail"
+	SpfSectionResultNeutral   SpfSectionResult = "neutral"
+	SpfSectionResultNone      SpfSectionResult = "none"
+	SpfSectionResultPass      SpfSectionResult = "pass"
+	SpfSectionResultPermerror SpfSectionResult = "permerror"
+	SpfSectionResultSoftfail  SpfSectionResult = "softfail"
+	SpfSectionResultTemperror SpfSectionResult = "temperror"
+)
+
+// Defines values for Verdict.
+const (
+	VerdictFail    Verdict = "fail"
+	VerdictInfo    Verdict = "info"
+	VerdictNone    Verdict = "none"
+	VerdictPass    Verdict = "pass"
+	VerdictWarning Verdict = "warning"
+)
+
 // N400Error defines model for 400Error.
 type N400Error struct {
 	Message string `json:"message"`
@@ -21,6 +63,334 @@ type N500Error struct {
 	Message string `json:"message"`
 }
 
+// BimiSection defines model for BimiSection.
+type BimiSection struct {
+	// AuthorityUrl Where the mark certificate is published, if any
+	AuthorityUrl *string `json:"authorityUrl,omitempty"`
+
+	// Checks Individual checks that contributed to the verdict
+	Checks  *[]Check `json:"checks,omitempty"`
+	LogoUrl *string  `json:"logoUrl,omitempty"`
+
+	// Record The BIMI record published
+	Record *string `json:"record,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// Check defines model for Check.
+type Check struct {
+	// Message Human readable explanation
+	Message *string `json:"message,omitempty"`
+
+	// Name Short machine-readable name of the check
+	Name string `json:"name"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// DkimSection defines model for DkimSection.
+type DkimSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks     *[]Check         `json:"checks,omitempty"`
+	Signatures *[]DkimSignature `json:"signatures,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// DkimSignature defines model for DkimSignature.
+type DkimSignature struct {
+	// Algorithm Signing algorithm, a=
+	Algorithm *string `json:"algorithm,omitempty"`
+
+	// Aligned Whether the signing domain aligns with the From header
+	Aligned *bool `json:"aligned,omitempty"`
+
+	// Domain Signing domain, d=
+	Domain string `json:"domain"`
+
+	// KeyBits Size of the public key
+	KeyBits *int `json:"keyBits,omitempty"`
+
+	// Result Verification result, as in RFC 8601
+	Result DkimSignatureResult `json:"result"`
+
+	// Selector Selector, s=
+	Selector string `json:"selector"`
+}
+
+// DkimSignatureResult Verification result, as in RFC 8601
+type DkimSignatureResult string
+
+// DmarcSection defines model for DmarcSection.
+type DmarcSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks      *[]Check `json:"checks,omitempty"`
+	DkimAligned *bool    `json:"dkimAligned,omitempty"`
+
+	// Domain The From domain
+	Domain *string `json:"domain,omitempty"`
+
+	// Policy The policy that applies, p= or sp=
+	Policy *string `json:"policy,omitempty"`
+
+	// Record The DMARC record published
+	Record *string `json:"record,omitempty"`
+
+	// Result DMARC result
+	Result     *DmarcSectionResult `json:"result,omitempty"`
+	SpfAligned *bool               `json:"spfAligned,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// DmarcSectionResult DMARC result
+type DmarcSectionResult string
+
+// DnsLookup defines model for DnsLookup.
+type DnsLookup struct {
+	Answers *[]string `json:"answers,omitempty"`
+	Error   *string   `json:"error,omitempty"`
+	Name    string    `json:"name"`
+	Type    string    `json:"type"`
+}
+
+// DnsSection defines model for DnsSection.
+type DnsSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks  *[]Check     `json:"checks,omitempty"`
+	Lookups *[]DnsLookup `json:"lookups,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// EmailList defines model for EmailList.
+type EmailList struct {
+	// Emails Matching submissions, newest first
+	Emails []EmailSummary `json:"emails"`
+
+	// NextCursor Pass as cursor to fetch the next page, absent on the last page
+	NextCursor *string `json:"nextCursor,omitempty"`
+}
+
+// EmailSummary defines model for EmailSummary.
+type EmailSummary struct {
+	// From The email address for MAIL FROM
+	From string `json:"from"`
+
+	// Helo The hostname given in the HELO
+	Helo *string `json:"helo,omitempty"`
+
+	// Id Identifier for the result
+	Id string `json:"id"`
+
+	// Ip The IP address the mail is sent from
+	Ip string `json:"ip"`
+
+	// Staged Whether the result is rendered with the staged configuration
+	Staged *bool `json:"staged,omitempty"`
+
+	// Submitted When the message was submitted
+	Submitted time.Time `json:"submitted"`
+
+	// To The email address for RCPT TO
+	To string `json:"to"`
+
+	// Token Opaque token copied from request
+	Token *string `json:"token,omitempty"`
+
+	// Url Where human readable result is available, once processing is complete
+	Url *string `json:"url,omitempty"`
+}
+
+// HostsSection defines model for HostsSection.
+type HostsSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+
+	// Hosts Hostnames the message loads remote content from
+	Hosts *[]string `json:"hosts,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// Image defines model for Image.
+type Image struct {
+	Alt    *string `json:"alt,omitempty"`
+	Bytes  *int    `json:"bytes,omitempty"`
+	Height *int    `json:"height,omitempty"`
+	Url    string  `json:"url"`
+	Width  *int    `json:"width,omitempty"`
+}
+
+// ImagesSection defines model for ImagesSection.
+type ImagesSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+	Images *[]Image `json:"images,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// Link defines model for Link.
+type Link struct {
+	// Redirects Any redirects followed from url
+	Redirects *[]string `json:"redirects,omitempty"`
+
+	// Text The link text, or alt text of a linked image
+	Text *string `json:"text,omitempty"`
+	Url  string  `json:"url"`
+}
+
+// LinksSection defines model for LinksSection.
+type LinksSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+	Links  *[]Link  `json:"links,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// MimePart defines model for MimePart.
+type MimePart struct {
+	Charset     *string `json:"charset,omitempty"`
+	ContentType string  `json:"contentType"`
+	Disposition *string `json:"disposition,omitempty"`
+
+	// Path Position in the MIME tree, e.g. "1.2"
+	Path             string  `json:"path"`
+	Size             *int    `json:"size,omitempty"`
+	TransferEncoding *string `json:"transferEncoding,omitempty"`
+}
+
+// MimeSection defines model for MimeSection.
+type MimeSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check    `json:"checks,omitempty"`
+	Parts  *[]MimePart `json:"parts,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// Report defines model for Report.
+type Report struct {
+	Bimi    *BimiSection  `json:"bimi,omitempty"`
+	Dkim    *DkimSection  `json:"dkim,omitempty"`
+	Dmarc   *DmarcSection `json:"dmarc,omitempty"`
+	Dns     *DnsSection   `json:"dns,omitempty"`
+	Headers *Section      `json:"headers,omitempty"`
+	Hosts   *HostsSection `json:"hosts,omitempty"`
+
+	// Id Identifier for the result
+	Id          string              `json:"id"`
+	Images      *ImagesSection      `json:"images,omitempty"`
+	Links       *LinksSection       `json:"links,omitempty"`
+	Mime        *MimeSection        `json:"mime,omitempty"`
+	Rendering   *Section            `json:"rendering,omitempty"`
+	Session     *SessionSection     `json:"session,omitempty"`
+	Spf         *SpfSection         `json:"spf,omitempty"`
+	Tls         *TlsSection         `json:"tls,omitempty"`
+	Unsubscribe *UnsubscribeSection `json:"unsubscribe,omitempty"`
+
+	// Url Where human readable result is available
+	Url *string `json:"url,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict  Verdict  `json:"verdict"`
+	Yahoogle *Section `json:"yahoogle,omitempty"`
+}
+
+// Section defines model for Section.
+type Section struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// SessionSection defines model for SessionSection.
+type SessionSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+
+	// Fcrdns Whether the reverse DNS resolves back to the sending IP
+	Fcrdns *bool   `json:"fcrdns,omitempty"`
+	From   *string `json:"from,omitempty"`
+	Helo   *string `json:"helo,omitempty"`
+	Ip     *string `json:"ip,omitempty"`
+
+	// Ptr Reverse DNS of the sending IP
+	Ptr *string `json:"ptr,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+	To      *string `json:"to,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// SpfSection defines model for SpfSection.
+type SpfSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+
+	// Domain The domain whose SPF record was checked
+	Domain *string `json:"domain,omitempty"`
+
+	// Record The SPF record published
+	Record *string `json:"record,omitempty"`
+
+	// Result SPF result, as in RFC 7208
+	Result *SpfSectionResult `json:"result,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
+// SpfSectionResult SPF result, as in RFC 7208
+type SpfSectionResult string
+
 // StatusResult defines model for StatusResult.
 type StatusResult struct {
 	// Id Identifier for the result
@@ -38,6 +408,9 @@ type StatusResult struct {
 
 // Submit defines model for Submit.
 type Submit struct {
+	// CallbackSecret Secret used to sign callbacks to progressUrl and finishedUrl
+	CallbackSecret *string `json:"callbackSecret,omitempty"`
+
 	// FinishedUrl Callback when processing is complete
 	FinishedUrl *string `json:"finishedUrl,omitempty"`
 
@@ -71,6 +444,9 @@ type Submit struct {
 
 // SubmitForm defines model for SubmitForm.
 type SubmitForm struct {
+	// CallbackSecret Secret used to sign callbacks to progressUrl and finishedUrl
+	CallbackSecret *string `json:"callbackSecret,omitempty"`
+
 	// FinishedUrl Callback when processing is complete
 	FinishedUrl *string `json:"finishedUrl,omitempty"`
 
@@ -108,12 +484,74 @@ type SubmitSuccess struct {
 	Id string `json:"id"`
 }
 
+// TlsSection defines model for TlsSection.
+type TlsSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+	Cipher *string  `json:"cipher,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+	Version *string `json:"version,omitempty"`
+}
+
+// UnsubscribeSection defines model for UnsubscribeSection.
+type UnsubscribeSection struct {
+	// Checks Individual checks that contributed to the verdict
+	Checks *[]Check `json:"checks,omitempty"`
+
+	// ListUnsubscribe URIs from the List-Unsubscribe header
+	ListUnsubscribe *[]string `json:"listUnsubscribe,omitempty"`
+
+	// OneClick Whether RFC 8058 one-click unsubscribe is supported
+	OneClick *bool `json:"oneClick,omitempty"`
+
+	// Summary One line human readable summary
+	Summary *string `json:"summary,omitempty"`
+
+	// Verdict Overall assessment of a check or section
+	Verdict Verdict `json:"verdict"`
+}
+
 // UploadResult defines model for UploadResult.
 type UploadResult struct {
 	// Messages Diagnostics about uploaded files
 	Messages []string `json:"messages"`
 }
 
+// Verdict Overall assessment of a check or section
+type Verdict string
+
+// EmailListParams defines parameters for EmailList.
+type EmailListParams struct {
+	// After Only list messages submitted at or after this time
+	After *time.Time `form:"after,omitempty" json:"after,omitempty"`
+
+	// Before Only list messages submitted before this time
+	Before *time.Time `form:"before,omitempty" json:"before,omitempty"`
+
+	// Token Only list messages submitted with this token
+	Token *string `form:"token,omitempty" json:"token,omitempty"`
+
+	// FromDomain Only list messages with a MAIL FROM in this domain
+	FromDomain *string `form:"fromDomain,omitempty" json:"fromDomain,omitempty"`
+
+	// ToDomain Only list messages with a RCPT TO in this domain
+	ToDomain *string `form:"toDomain,omitempty" json:"toDomain,omitempty"`
+
+	// Staged If true only list messages rendered with the staged configuration, if false only production
+	Staged *bool `form:"staged,omitempty" json:"staged,omitempty"`
+
+	// Limit Maximum number of messages to return
+	Limit *int `form:"limit,omitempty" json:"limit,omitempty"`
+
+	// Cursor The nextCursor from a previous response, to fetch the following page
+	Cursor *string `form:"cursor,omitempty" json:"cursor,omitempty"`
+}
+
 // ContentPostMultipartBody defines parameters for ContentPost.
 type ContentPostMultipartBody struct {
 	Filename *[]openapi_types.File `json:"filename,omitempty"`
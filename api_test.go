@@ -1,21 +1,22 @@
-package aboutmyemail
+package aboutmyemail_test
 
 import (
+	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
-	"strings"
 	"testing"
 	"time"
+
+	"github.com/wttw/aboutmyemail"
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
 )
 
-const testServer = `http://127.0.0.1:3000/api/v1`
 const testKey = `myemail_0LekAwu5Wob2kSru`
 
-const sampleEmail = `From: <steve@blighty.com>
+const testEmail = `From: <steve@blighty.com>
 To: <steve@blighty.com>
 Subject: test sausage
 
@@ -23,19 +24,20 @@ body
 `
 
 func TestApi(t *testing.T) {
-	client, err := New(WithServer(testServer), WithApiKey(testKey))
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithAPIKey(testKey))
+	defer server.Close()
+	client, err := aboutmyemail.New(aboutmyemail.WithServer(server.Endpoint), aboutmyemail.WithApiKey(testKey))
 	if err != nil {
 		t.Fatalf("failed to create client: %v", err)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	//ctx = context.Background()
 	token := "potato"
 
-	result, err := client.EmailWithResponse(ctx, EmailJSONRequestBody{
+	result, err := client.EmailWithResponse(ctx, aboutmyemail.EmailJSONRequestBody{
 		From:    "steve@blighty.com",
 		Ip:      "10.11.12.13",
-		Payload: sampleEmail,
+		Payload: testEmail,
 		To:      "steve@blighty.com",
 		Token:   &token,
 	})
@@ -71,7 +73,7 @@ func TestApi(t *testing.T) {
 			return
 		}
 		if result.JSON200.Url != nil {
-			want := fmt.Sprintf(strings.TrimSuffix(testServer, "api/v1") + id)
+			want := server.ResultURL(id)
 			got := *result.JSON200.Url
 			if want != got {
 				t.Errorf("url want '%s', got '%s'", want, got)
@@ -90,86 +92,202 @@ func TestApi(t *testing.T) {
 			}
 			break
 		}
-		time.Sleep(500 * time.Millisecond)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	submissions := server.Submissions()
+	if len(submissions) != 1 || string(submissions[0].Payload) != testEmail {
+		t.Errorf("server didn't record the submission as sent: %+v", submissions)
 	}
 }
 
 func TestApi_Callbacks(t *testing.T) {
-	client, err := New(WithServer(testServer), WithApiKey(testKey))
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithAPIKey(testKey))
+	defer server.Close()
+	client, err := server.Client()
 	if err != nil {
 		t.Fatalf("failed to create client: %v", err)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
-	//ctx = context.Background()
-	token := "banana"
 
-	finishedChan := make(chan struct{})
-	var callbackBody []byte
-	messageCount := 0
-	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.HasSuffix(r.URL.Path, "message") {
-			messageCount++
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		if strings.HasSuffix(r.URL.Path, "finished") {
-			callbackBody, err = io.ReadAll(r.Body)
-			if err != nil {
-				panic(fmt.Errorf("failed to read callback body: %w", err))
-			}
-			w.WriteHeader(http.StatusNoContent)
-			close(finishedChan)
-			return
-		}
-		panic(fmt.Errorf("unrecognised query to callback server: %s", r.URL.Path))
-	}))
+	receiver := aboutmyemail.NewCallbackReceiver()
+	defer receiver.Close()
+	ts := httptest.NewServer(receiver)
 	defer ts.Close()
+	sub, err := receiver.Subscribe("banana")
+	if err != nil {
+		t.Fatalf("failed to subscribe: %v", err)
+	}
 
-	finishedUrl := ts.URL + "/finished"
-	messageUrl := ts.URL + "/message"
-	result, err := client.EmailWithResponse(ctx, EmailJSONRequestBody{
+	callbackUrl := ts.URL + "/callback"
+	request := aboutmyemail.EmailJSONRequestBody{
 		From:        "steve@blighty.com",
 		Ip:          "10.11.12.13",
-		Payload:     sampleEmail,
+		Payload:     testEmail,
 		To:          "steve@blighty.com",
-		Token:       &token,
-		FinishedUrl: &finishedUrl,
-		ProgressUrl: &messageUrl,
-	})
+		FinishedUrl: &callbackUrl,
+		ProgressUrl: &callbackUrl,
+	}
+	sub.Prepare(&request)
+	result, err := client.EmailWithResponse(ctx, request)
 	if err != nil {
 		t.Errorf("client.Email() failed: %v", err)
 		return
 	}
-
-	select {
-	case <-ctx.Done():
-		t.Errorf("context timeout")
-		return
-	case <-finishedChan:
+	if result.JSON200 == nil {
+		t.Fatalf("expected non-nil success response, got %s", result.Status())
 	}
 
-	var finResult StatusResult
-	err = json.Unmarshal(callbackBody, &finResult)
+	messageCount := 0
+	finResult, err := sub.Wait(ctx, func(string) { messageCount++ })
 	if err != nil {
-		t.Logf("callback body:\n%s\n", string(callbackBody))
-		t.Errorf("failed to unmarshal callback result: %s", err)
+		t.Fatalf("waiting for callbacks failed: %s", err)
 	}
-	if finResult.Url == nil {
-		t.Errorf("Wanted non-nil result url, got nil")
-	} else {
-		wantUrl := fmt.Sprintf(strings.TrimSuffix(testServer, "api/v1") + result.JSON200.Id)
-		gotUrl := *finResult.Url
-		if wantUrl != gotUrl {
-			t.Errorf("Finished URL want '%s', got '%s'", wantUrl, gotUrl)
-		}
+	if messageCount != 3 {
+		t.Errorf("want 3 progress messages, got %d", messageCount)
+	}
+	wantUrl := server.ResultURL(result.JSON200.Id)
+	gotUrl := *finResult.Url
+	if wantUrl != gotUrl {
+		t.Errorf("Finished URL want '%s', got '%s'", wantUrl, gotUrl)
 	}
 	if finResult.Token == nil {
 		t.Errorf("Wanted non-nil result token, got nil")
 	} else {
 		got := *finResult.Token
-		if got != token {
-			t.Errorf("Token want '%s', got '%s", token, got)
+		if got != "banana" {
+			t.Errorf("Token want '%s', got '%s", "banana", got)
 		}
 	}
 }
+
+func TestApi_Errors(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithAPIKey(testKey))
+	defer server.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	request := aboutmyemail.Submit{From: "steve@blighty.com", To: "steve@blighty.com", Ip: "10.11.12.13", Payload: testEmail}
+
+	client, err := aboutmyemail.NewClientWithResponses(server.Endpoint, aboutmyemail.WithApiKey("wrong"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	_, err = client.SubmitAndWait(ctx, request)
+	if !errors.Is(err, aboutmyemail.ErrUnauthorized) {
+		t.Errorf("bad key want ErrUnauthorized, got %v", err)
+	}
+
+	client, err = server.Client(aboutmyemail.WithRetry(aboutmyemail.RetryPolicy{BaseDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	server.Throttle(2)
+	result, err := client.SubmitAndWait(ctx, request, aboutmyemail.WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Errorf("want throttled requests retried, got %v", err)
+	} else if len(server.Submissions()) != 1 || *result.Url != server.ResultURL(server.Submissions()[0].ID) {
+		t.Errorf("unexpected result %v", result)
+	}
+
+	server.InjectError(http.MethodGet, "", http.StatusInternalServerError, "database on fire")
+	_, err = client.SubmitAndWait(ctx, request, aboutmyemail.WithPollInterval(time.Millisecond))
+	var apiErr *aboutmyemail.APIError
+	if !errors.Is(err, aboutmyemail.ErrServerFailure) || !errors.As(err, &apiErr) || apiErr.Message != "database on fire" {
+		t.Errorf("injected error want ErrServerFailure 'database on fire', got %v", err)
+	}
+
+	_, err = client.Wait(ctx, "nonesuch")
+	if !errors.Is(err, aboutmyemail.ErrNotFound) {
+		t.Errorf("unknown id want ErrNotFound, got %v", err)
+	}
+}
+
+func TestApi_Style(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithAPIKey(testKey))
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var buff bytes.Buffer
+	mpw := multipart.NewWriter(&buff)
+	writer, err := mpw.CreateFormFile("filename", "faq.md")
+	if err != nil {
+		t.Fatalf("failed to create upload: %v", err)
+	}
+	_, _ = writer.Write([]byte("# FAQ\n"))
+	_ = mpw.Close()
+
+	upload, err := client.ContentPostWithBodyWithResponse(ctx, mpw.FormDataContentType(), &buff)
+	if err != nil {
+		t.Fatalf("upload failed: %v", err)
+	}
+	if err := aboutmyemail.CheckResponse(upload.HTTPResponse, upload.Body); err != nil || upload.JSON200 == nil {
+		t.Fatalf("upload want success, got %v", err)
+	}
+	if got := string(server.Content(true)["faq.md"]); got != "# FAQ\n" {
+		t.Errorf("staged faq.md want '# FAQ\\n', got '%s'", got)
+	}
+	if len(server.Content(false)) != 0 {
+		t.Errorf("want nothing published before publish")
+	}
+
+	publish, err := client.StylePublishWithResponse(ctx)
+	if err != nil {
+		t.Fatalf("publish failed: %v", err)
+	}
+	if err := aboutmyemail.CheckResponse(publish.HTTPResponse, publish.Body); err != nil {
+		t.Fatalf("publish want success, got %v", err)
+	}
+	if got := string(server.Content(false)["faq.md"]); got != "# FAQ\n" {
+		t.Errorf("published faq.md want '# FAQ\\n', got '%s'", got)
+	}
+}
+
+func TestApi_Report(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithReport(func(sub aboutmyemailtest.Submission) aboutmyemail.Report {
+		report := aboutmyemailtest.DefaultReport(sub)
+		report.Verdict = aboutmyemail.VerdictFail
+		result := aboutmyemail.SpfSectionResultSoftfail
+		report.Spf = &aboutmyemail.SpfSection{Verdict: aboutmyemail.VerdictFail, Result: &result}
+		return report
+	}))
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	submitted, err := client.EmailWithResponse(ctx, aboutmyemail.Submit{From: "steve@blighty.com", To: "steve@blighty.com", Ip: "10.11.12.13", Payload: testEmail})
+	if err != nil || submitted.JSON200 == nil {
+		t.Fatalf("client.Email() failed: %v", err)
+	}
+	id := submitted.JSON200.Id
+	_, err = client.Report(ctx, id)
+	if !errors.Is(err, aboutmyemail.ErrNotReady) {
+		t.Errorf("report before completion want ErrNotReady, got %v", err)
+	}
+
+	if _, err := client.Wait(ctx, id, aboutmyemail.WithPollInterval(time.Millisecond)); err != nil {
+		t.Fatalf("Wait failed: %v", err)
+	}
+	report, err := client.Report(ctx, id)
+	if err != nil {
+		t.Fatalf("Report failed: %v", err)
+	}
+	if report.Id != id || report.Verdict != aboutmyemail.VerdictFail {
+		t.Errorf("want failed report for %s, got %s %s", id, report.Id, report.Verdict)
+	}
+	if report.Spf == nil || report.Spf.Result == nil || *report.Spf.Result != aboutmyemail.SpfSectionResultSoftfail {
+		t.Errorf("want spf softfail, got %+v", report.Spf)
+	}
+	if report.Session == nil || *report.Session.Ip != "10.11.12.13" {
+		t.Errorf("want session ip from envelope, got %+v", report.Session)
+	}
+}
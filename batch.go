@@ -0,0 +1,153 @@
+package aboutmyemail
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// BatchItem is one message for SubmitBatch
+type BatchItem struct {
+	// Name identifies the item in its result, e.g. the file it came from
+	Name   string
+	Submit Submit
+	// Payload, if set, is streamed as a multipart form, as by
+	// EmailFormWithResponse, in place of Submit.Payload
+	Payload io.Reader
+}
+
+// BatchResult is the outcome of one BatchItem. Id is set if the message was
+// accepted for processing, even if waiting for its result then failed.
+type BatchResult struct {
+	Name  string `json:"name"`
+	Id    string `json:"id,omitempty"`
+	Url   string `json:"url,omitempty"`
+	Token string `json:"token,omitempty"`
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
+
+type batchConfig struct {
+	workers  int
+	interval time.Duration
+	wait     []WaitOption
+	onResult func(BatchResult)
+}
+
+// BatchOption configures SubmitBatch
+type BatchOption func(*batchConfig)
+
+// WithWorkers sets how many messages are processed at once, default 4
+func WithWorkers(n int) BatchOption {
+	return func(c *batchConfig) {
+		if n > 0 {
+			c.workers = n
+		}
+	}
+}
+
+// WithRateLimit limits how many submissions are started per second. Zero,
+// the default, doesn't limit them.
+func WithRateLimit(perSecond float64) BatchOption {
+	return func(c *batchConfig) {
+		c.interval = 0
+		if perSecond > 0 {
+			c.interval = time.Duration(float64(time.Second) / perSecond)
+		}
+	}
+}
+
+// WithWaitOptions sets the options used when waiting for each result
+func WithWaitOptions(opts ...WaitOption) BatchOption {
+	return func(c *batchConfig) {
+		c.wait = opts
+	}
+}
+
+// WithBatchResult calls fn as each item completes, successfully or not.
+// Calls are not concurrent, but are in completion order rather than input
+// order.
+func WithBatchResult(fn func(BatchResult)) BatchOption {
+	return func(c *batchConfig) {
+		c.onResult = fn
+	}
+}
+
+// SubmitBatch submits many messages concurrently, following each until its
+// result is available. A failure of one item doesn't affect the others. The
+// results are in the same order as items.
+func (c *ClientWithResponses) SubmitBatch(ctx context.Context, items []BatchItem, opts ...BatchOption) []BatchResult {
+	cfg := batchConfig{workers: 4}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	results := make([]BatchResult, len(items))
+	jobs := make(chan int)
+	var resultMtx sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < min(cfg.workers, len(items)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				result := c.runBatchItem(ctx, items[i], cfg.wait)
+				results[i] = result
+				if cfg.onResult != nil {
+					resultMtx.Lock()
+					cfg.onResult(result)
+					resultMtx.Unlock()
+				}
+			}
+		}()
+	}
+
+	next := time.Now()
+	dispatched := 0
+dispatch:
+	for i := range items {
+		if err := sleepContext(ctx, time.Until(next)); err != nil {
+			break
+		}
+		next = time.Now().Add(cfg.interval)
+		select {
+		case jobs <- i:
+			dispatched++
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for i := dispatched; i < len(items); i++ {
+		results[i] = BatchResult{Name: items[i].Name, Err: ctx.Err(), Error: ctx.Err().Error()}
+	}
+	return results
+}
+
+func (c *ClientWithResponses) runBatchItem(ctx context.Context, item BatchItem, wait []WaitOption) BatchResult {
+	result := BatchResult{Name: item.Name}
+	if item.Submit.Token != nil {
+		result.Token = *item.Submit.Token
+	}
+	opts := append(append([]WaitOption{}, wait...), WithSubmitted(func(id string) { result.Id = id }))
+	var status *StatusResult
+	var err error
+	if item.Payload != nil {
+		status, err = c.SubmitFormAndWait(ctx, item.Submit, item.Payload, opts...)
+	} else {
+		status, err = c.SubmitAndWait(ctx, item.Submit, opts...)
+	}
+	if err != nil {
+		result.Err = err
+		result.Error = err.Error()
+		return result
+	}
+	result.Id = status.Id
+	result.Url = *status.Url
+	if status.Token != nil {
+		result.Token = *status.Token
+	}
+	return result
+}
@@ -0,0 +1,89 @@
+package aboutmyemail_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail"
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+func TestSubmitBatch(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages("one", "two"))
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	var items []aboutmyemail.BatchItem
+	for i := 0; i < 12; i++ {
+		token := fmt.Sprintf("variant-%d", i)
+		submit := aboutmyemail.Submit{From: "steve@blighty.com", To: "steve@blighty.com", Ip: "10.11.12.13", Payload: testEmail, Token: &token}
+		if i == 5 {
+			// The server rejects a submission without a MAIL FROM
+			submit.From = ""
+		}
+		items = append(items, aboutmyemail.BatchItem{Name: token, Submit: submit})
+	}
+
+	completed := 0
+	results := client.SubmitBatch(ctx, items,
+		aboutmyemail.WithWorkers(3),
+		aboutmyemail.WithRateLimit(1000),
+		aboutmyemail.WithWaitOptions(aboutmyemail.WithPollInterval(time.Millisecond)),
+		aboutmyemail.WithBatchResult(func(aboutmyemail.BatchResult) { completed++ }))
+
+	if len(results) != len(items) || completed != len(items) {
+		t.Fatalf("want %d results, got %d, %d reported", len(items), len(results), completed)
+	}
+	for i, result := range results {
+		if result.Name != items[i].Name {
+			t.Errorf("result %d is for %s, want %s", i, result.Name, items[i].Name)
+		}
+		if i == 5 {
+			if !errors.Is(result.Err, aboutmyemail.ErrBadRequest) || result.Error == "" || result.Id != "" {
+				t.Errorf("result %d want bad request, got %+v", i, result)
+			}
+			continue
+		}
+		if result.Err != nil {
+			t.Errorf("result %d failed: %v", i, result.Err)
+			continue
+		}
+		if result.Url != server.ResultURL(result.Id) || result.Token != items[i].Name {
+			t.Errorf("result %d unexpected %+v", i, result)
+		}
+	}
+	if got := len(server.Submissions()); got != len(items)-1 {
+		t.Errorf("want %d submissions, got %d", len(items)-1, got)
+	}
+}
+
+func TestSubmitBatch_Cancelled(t *testing.T) {
+	server := aboutmyemailtest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	items := make([]aboutmyemail.BatchItem, 5)
+	for i := range items {
+		items[i] = aboutmyemail.BatchItem{Name: fmt.Sprint(i), Submit: aboutmyemail.Submit{From: "a@example.com", To: "b@example.com", Ip: "10.0.0.1", Payload: testEmail}}
+	}
+	// One submission a second means most are never started
+	results := client.SubmitBatch(ctx, items, aboutmyemail.WithWorkers(5), aboutmyemail.WithRateLimit(1))
+	for i, result := range results {
+		if !errors.Is(result.Err, context.DeadlineExceeded) {
+			t.Errorf("result %d want DeadlineExceeded, got %v", i, result.Err)
+		}
+	}
+}
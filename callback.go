@@ -0,0 +1,298 @@
+package aboutmyemail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxCallbackBody is the largest callback body we'll accept
+const maxCallbackBody = 1 << 20
+
+// callbackBuffer is how many callbacks can be queued for a subscription
+// before the receiver makes the server wait
+const callbackBuffer = 16
+
+// ErrReceiverClosed is returned when subscribing to a closed CallbackReceiver
+var ErrReceiverClosed = errors.New("callback receiver closed")
+
+// CallbackReceiver is an http.Handler that accepts the StatusResult callbacks
+// sent to a submission's progressUrl and finishedUrl, and routes each to the
+// subscription for its Token or result id. One receiver can be shared by
+// many concurrent submissions, and mounted wherever is convenient in an
+// existing ServeMux.
+//
+// Callbacks for a subscription with a Secret must carry a valid
+// CallbackSignatureHeader and echo the subscription's Token, or they're
+// rejected without being delivered.
+type CallbackReceiver struct {
+	// Tolerance is the allowed clock skew for callback signatures,
+	// DefaultCallbackTolerance if zero
+	Tolerance time.Duration
+
+	mtx     sync.Mutex
+	byToken map[string]*CallbackSubscription
+	byID    map[string]*CallbackSubscription
+	closed  bool
+}
+
+// CallbackSubscription receives the callbacks for one submission. C is
+// closed after the final callback, the one with a result Url, has been
+// delivered, or when the subscription or receiver is closed.
+type CallbackSubscription struct {
+	// C delivers each callback for this submission in the order received
+	C <-chan StatusResult
+	// Token is the value to send as the Token of the submission
+	Token string
+	// Secret is the value to send as the CallbackSecret of the submission
+	Secret string
+
+	c        chan StatusResult
+	id       string
+	receiver *CallbackReceiver
+	once     sync.Once
+	quit     chan struct{}
+	mtx      sync.Mutex // held while delivering, so c isn't closed under us
+	closed   bool
+}
+
+// NewCallbackReceiver creates a CallbackReceiver with no subscriptions
+func NewCallbackReceiver() *CallbackReceiver {
+	return &CallbackReceiver{
+		byToken: map[string]*CallbackSubscription{},
+		byID:    map[string]*CallbackSubscription{},
+	}
+}
+
+// Subscribe registers interest in callbacks carrying token. If token is
+// empty a random one is generated. A random Secret is always generated.
+// Subscribe before submitting, and use Prepare to set the submission's
+// Token and CallbackSecret, so no callbacks are missed.
+func (r *CallbackReceiver) Subscribe(token string) (*CallbackSubscription, error) {
+	if token == "" {
+		var err error
+		token, err = newIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	secret, err := newIdempotencyKey()
+	if err != nil {
+		return nil, err
+	}
+	return r.subscribe(token, "", secret)
+}
+
+// SubscribeID registers interest in callbacks for result id, for submissions
+// made without a Token. If the submission had a CallbackSecret pass it as
+// secret, otherwise callbacks are accepted unsigned. Callbacks that arrive
+// before SubscribeID is called are rejected, so prefer Subscribe where
+// possible.
+func (r *CallbackReceiver) SubscribeID(id string, secret string) (*CallbackSubscription, error) {
+	if id == "" {
+		return nil, errors.New("empty result id")
+	}
+	return r.subscribe("", id, secret)
+}
+
+func (r *CallbackReceiver) subscribe(token, id, secret string) (*CallbackSubscription, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if r.closed {
+		return nil, ErrReceiverClosed
+	}
+	c := make(chan StatusResult, callbackBuffer)
+	sub := &CallbackSubscription{C: c, Token: token, Secret: secret, c: c, id: id, receiver: r, quit: make(chan struct{})}
+	if token != "" {
+		if _, ok := r.byToken[token]; ok {
+			return nil, fmt.Errorf("already subscribed to token %s", token)
+		}
+		r.byToken[token] = sub
+	}
+	if id != "" {
+		if _, ok := r.byID[id]; ok {
+			return nil, fmt.Errorf("already subscribed to result %s", id)
+		}
+		r.byID[id] = sub
+	}
+	return sub, nil
+}
+
+// Close unregisters every subscription, closing their channels, and makes
+// the receiver reject any further callbacks.
+func (r *CallbackReceiver) Close() {
+	r.mtx.Lock()
+	if r.closed {
+		r.mtx.Unlock()
+		return
+	}
+	r.closed = true
+	subs := make([]*CallbackSubscription, 0, len(r.byToken)+len(r.byID))
+	for _, sub := range r.byToken {
+		subs = append(subs, sub)
+	}
+	for _, sub := range r.byID {
+		subs = append(subs, sub)
+	}
+	r.mtx.Unlock()
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// lookup finds the subscription for a callback, preferring its token
+func (r *CallbackReceiver) lookup(result StatusResult) *CallbackSubscription {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if result.Token != nil {
+		if sub, ok := r.byToken[*result.Token]; ok {
+			return sub
+		}
+	}
+	return r.byID[result.Id]
+}
+
+func (r *CallbackReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Expected POST", http.StatusMethodNotAllowed)
+		return
+	}
+	mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		http.Error(w, "Expected application/json", http.StatusUnsupportedMediaType)
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxCallbackBody))
+	if err != nil {
+		http.Error(w, "Failed to read callback: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	var result StatusResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		http.Error(w, "Failed to parse callback: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	sub := r.lookup(result)
+	if sub == nil {
+		http.Error(w, "No such submission", http.StatusNotFound)
+		return
+	}
+	if err := sub.verify(req.Header.Get(CallbackSignatureHeader), body, result, r.tolerance()); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := sub.deliver(req.Context(), result); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (r *CallbackReceiver) tolerance() time.Duration {
+	if r.Tolerance > 0 {
+		return r.Tolerance
+	}
+	return DefaultCallbackTolerance
+}
+
+// Prepare sets the Token and CallbackSecret of a submission so its callbacks
+// are routed to, and accepted by, this subscription
+func (s *CallbackSubscription) Prepare(body *Submit) {
+	if s.Token != "" {
+		token := s.Token
+		body.Token = &token
+	}
+	if s.Secret != "" {
+		secret := s.Secret
+		body.CallbackSecret = &secret
+	}
+}
+
+// verify checks a callback is signed with our secret and echoes our token
+func (s *CallbackSubscription) verify(signature string, body []byte, result StatusResult, tolerance time.Duration) error {
+	if s.Secret == "" {
+		return nil
+	}
+	if err := VerifyCallback(s.Secret, signature, body, time.Now(), tolerance); err != nil {
+		return err
+	}
+	if s.Token != "" && (result.Token == nil || *result.Token != s.Token) {
+		return errors.New("callback token mismatch")
+	}
+	return nil
+}
+
+// deliver queues result for the subscriber, closing the subscription once
+// the final result has been queued
+func (s *CallbackSubscription) deliver(ctx context.Context, result StatusResult) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		return ErrReceiverClosed
+	}
+	select {
+	case s.c <- result:
+	case <-ctx.Done():
+		s.mtx.Unlock()
+		return ctx.Err()
+	case <-s.quit:
+		s.mtx.Unlock()
+		return ErrReceiverClosed
+	}
+	s.mtx.Unlock()
+	if result.Url != nil && *result.Url != "" {
+		s.Close()
+	}
+	return nil
+}
+
+// Close unregisters the subscription and closes C. Callbacks already queued
+// can still be read from C.
+func (s *CallbackSubscription) Close() {
+	s.once.Do(func() {
+		r := s.receiver
+		r.mtx.Lock()
+		if s.Token != "" && r.byToken[s.Token] == s {
+			delete(r.byToken, s.Token)
+		}
+		if s.id != "" && r.byID[s.id] == s {
+			delete(r.byID, s.id)
+		}
+		r.mtx.Unlock()
+		close(s.quit)
+		s.mtx.Lock()
+		s.closed = true
+		close(s.c)
+		s.mtx.Unlock()
+	})
+}
+
+// Wait reads callbacks until the final result arrives, calling onMessage (if
+// not nil) for each progress message on the way.
+func (s *CallbackSubscription) Wait(ctx context.Context, onMessage func(msg string)) (*StatusResult, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case result, ok := <-s.C:
+			if !ok {
+				return nil, ErrReceiverClosed
+			}
+			if onMessage != nil && result.Messages != nil {
+				for _, msg := range *result.Messages {
+					onMessage(msg)
+				}
+			}
+			if result.Url != nil && *result.Url != "" {
+				return &result, nil
+			}
+		}
+	}
+}
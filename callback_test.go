@@ -0,0 +1,190 @@
+package aboutmyemail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// postCallback sends result to url, signed with secret if it's not empty
+func postCallback(t *testing.T, url string, secret string, result StatusResult) int {
+	body, err := json.Marshal(result)
+	if err != nil {
+		t.Fatalf("failed to marshal callback: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("failed to create callback: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(CallbackSignatureHeader, SignCallback(secret, body, time.Now()))
+	}
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("failed to post callback: %v", err)
+	}
+	_ = rsp.Body.Close()
+	return rsp.StatusCode
+}
+
+func TestCallbackReceiver_Concurrent(t *testing.T) {
+	receiver := NewCallbackReceiver()
+	defer receiver.Close()
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	const submissions = 10
+	var wg sync.WaitGroup
+	for i := 0; i < submissions; i++ {
+		sub, err := receiver.Subscribe("")
+		if err != nil {
+			t.Fatalf("Subscribe failed: %v", err)
+		}
+		id := fmt.Sprintf("result%d", i)
+		token := sub.Token
+		secret := sub.Secret
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for step := 0; step < 3; step++ {
+				msgs := []string{fmt.Sprintf("%s step %d", id, step)}
+				if code := postCallback(t, ts.URL, secret, StatusResult{Id: id, Token: &token, Messages: &msgs}); code != http.StatusOK {
+					t.Errorf("progress callback got %d", code)
+				}
+			}
+			url := "https://aboutmy.email/" + id
+			if code := postCallback(t, ts.URL, secret, StatusResult{Id: id, Token: &token, Url: &url}); code != http.StatusOK {
+				t.Errorf("finished callback got %d", code)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			var got []string
+			result, err := sub.Wait(ctx, func(msg string) { got = append(got, msg) })
+			if err != nil {
+				t.Errorf("%s: Wait failed: %v", id, err)
+				return
+			}
+			if *result.Url != "https://aboutmy.email/"+id {
+				t.Errorf("%s: got result for %s", id, *result.Url)
+			}
+			if len(got) != 3 || got[0] != id+" step 0" || got[2] != id+" step 2" {
+				t.Errorf("%s: unexpected messages %q", id, got)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestCallbackReceiver_ByID(t *testing.T) {
+	receiver := NewCallbackReceiver()
+	defer receiver.Close()
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	if code := postCallback(t, ts.URL, "", StatusResult{Id: "early"}); code != http.StatusNotFound {
+		t.Errorf("unsubscribed callback want 404, got %d", code)
+	}
+	sub, err := receiver.SubscribeID("early", "")
+	if err != nil {
+		t.Fatalf("SubscribeID failed: %v", err)
+	}
+	url := "https://aboutmy.email/early"
+	if code := postCallback(t, ts.URL, "", StatusResult{Id: "early", Url: &url}); code != http.StatusOK {
+		t.Errorf("subscribed callback want 200, got %d", code)
+	}
+	result, ok := <-sub.C
+	if !ok || result.Id != "early" {
+		t.Errorf("want callback for 'early', got %v %v", result, ok)
+	}
+	if _, ok := <-sub.C; ok {
+		t.Errorf("want channel closed after final result")
+	}
+}
+
+func TestCallbackReceiver_Rejects(t *testing.T) {
+	receiver := NewCallbackReceiver()
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	rsp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	_ = rsp.Body.Close()
+	if rsp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET want 405, got %d", rsp.StatusCode)
+	}
+
+	rsp, err = http.Post(ts.URL, "text/plain", bytes.NewReader([]byte(`{"id":"x"}`)))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	_ = rsp.Body.Close()
+	if rsp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("text/plain want 415, got %d", rsp.StatusCode)
+	}
+
+	sub, err := receiver.Subscribe("tok")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	receiver.Close()
+	if _, ok := <-sub.C; ok {
+		t.Errorf("want channel closed by receiver Close")
+	}
+	if _, err := receiver.Subscribe("other"); err != ErrReceiverClosed {
+		t.Errorf("Subscribe after Close want ErrReceiverClosed, got %v", err)
+	}
+}
+
+func TestCallbackReceiver_Authenticates(t *testing.T) {
+	receiver := NewCallbackReceiver()
+	defer receiver.Close()
+	ts := httptest.NewServer(receiver)
+	defer ts.Close()
+
+	sub, err := receiver.Subscribe("")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	var body Submit
+	sub.Prepare(&body)
+	if body.Token == nil || *body.Token != sub.Token || body.CallbackSecret == nil || *body.CallbackSecret != sub.Secret {
+		t.Fatalf("Prepare didn't set token and secret")
+	}
+	token := sub.Token
+	otherToken := "not-" + token
+	url := "https://evil.example.com/"
+	cases := []struct {
+		name   string
+		secret string
+		token  *string
+		want   int
+	}{
+		{"unsigned", "", &token, http.StatusUnauthorized},
+		{"wrong secret", "guessed", &token, http.StatusUnauthorized},
+		{"right secret", sub.Secret, &token, http.StatusOK},
+	}
+	for _, c := range cases {
+		if code := postCallback(t, ts.URL, c.secret, StatusResult{Id: "abc", Token: c.token, Url: &url}); code != c.want {
+			t.Errorf("%s: want %d, got %d", c.name, c.want, code)
+		}
+	}
+	if code := postCallback(t, ts.URL, sub.Secret, StatusResult{Id: "abc", Token: &otherToken}); code != http.StatusNotFound {
+		t.Errorf("other token: want 404, got %d", code)
+	}
+	result, ok := <-sub.C
+	if !ok || result.Url == nil || *result.Url != url {
+		t.Errorf("want only the signed callback delivered, got %v", result)
+	}
+}
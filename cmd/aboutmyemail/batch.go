@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"io"
+	"os"
+	"time"
+)
+
+type BatchCmd struct {
+	Envelope
+	Selection
+	Dkim
+	Files   []string      `arg:"" help:"Files containing raw emails, mbox files, Maildirs or directories of .eml files" type:"path"`
+	Workers int           `help:"How many messages to process at once" default:"4"`
+	Rate    float64       `help:"Maximum submissions started per second, 0 for no limit" default:"2"`
+	Timeout time.Duration `help:"How long to wait for the whole batch" default:"10m"`
+	Output  string        `help:"Write the JSON summary to this file rather than stdout" type:"path" short:"o"`
+	Token   string        `help:"Token to submit every message with, to find them later with list --token"`
+}
+
+// batchSummary is the combined result of a batch run, written as JSON
+type batchSummary struct {
+	Submitted int                        `json:"submitted"`
+	Succeeded int                        `json:"succeeded"`
+	Failed    int                        `json:"failed"`
+	Results   []aboutmyemail.BatchResult `json:"results"`
+}
+
+func (b *BatchCmd) Run(globals *Globals) error {
+	cyan := color.New(color.FgCyan).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	red := color.New(color.FgHiRed).SprintFunc()
+
+	// Inputs we can't read or build an envelope for are failures, but don't
+	// stop the others being submitted
+	var items []aboutmyemail.BatchItem
+	messages := b.messages()
+	// failed holds the error for each message that wasn't submitted, by its
+	// position in messages, as names can repeat
+	failed := map[int]error{}
+	for i, msg := range messages {
+		email, err := msg.Data, msg.err
+		if err == nil && !b.Raw {
+			email, err = importEmail(msg.Name, email)
+		}
+		if err == nil {
+			email, _, err = b.Dkim.sign(email)
+		}
+		if err == nil {
+			var envelope Envelope
+			envelope, err = b.Envelope.resolve(email, globals.profile)
+			if err == nil {
+				request := envelope.request(email)
+				if b.Token != "" {
+					request.Token = &b.Token
+				}
+				item := aboutmyemail.BatchItem{Name: msg.Name, Submit: request}
+				if useForm(email) {
+					item.Payload = bytes.NewReader(email)
+				}
+				items = append(items, item)
+				continue
+			}
+		}
+		failed[i] = err
+		if !globals.Quiet {
+			_, _ = fmt.Fprintf(color.Output, "%s %s: %s\n", red("FAIL"), msg.Name, err)
+		}
+	}
+
+	client := newClient(globals)
+	ctx, cancel := context.WithTimeout(context.Background(), b.Timeout)
+	defer cancel()
+
+	opts := []aboutmyemail.BatchOption{
+		aboutmyemail.WithWorkers(b.Workers),
+		aboutmyemail.WithRateLimit(b.Rate),
+	}
+	if !globals.Quiet {
+		_, _ = fmt.Fprintf(color.Output, "Submitting %s messages ...\n", cyan(len(items)))
+		opts = append(opts, aboutmyemail.WithBatchResult(func(result aboutmyemail.BatchResult) {
+			if result.Err != nil {
+				_, _ = fmt.Fprintf(color.Output, "%s %s: %s\n", red("FAIL"), result.Name, result.Error)
+				return
+			}
+			_, _ = fmt.Fprintf(color.Output, "%s   %s: %s\n", green("OK"), result.Name, result.Url)
+		}))
+	}
+	results := client.SubmitBatch(ctx, items, opts...)
+
+	// Put the results back in the order the messages were given
+	summary := batchSummary{Submitted: len(items)}
+	next := 0
+	for i, msg := range messages {
+		if err, ok := failed[i]; ok {
+			summary.Results = append(summary.Results, aboutmyemail.BatchResult{Name: msg.Name, Err: err, Error: err.Error()})
+			continue
+		}
+		summary.Results = append(summary.Results, results[next])
+		next++
+	}
+	for _, result := range summary.Results {
+		if result.Err != nil {
+			summary.Failed++
+		} else {
+			summary.Succeeded++
+		}
+	}
+
+	if err := b.writeSummary(summary); err != nil {
+		fatal("Failed to write summary: %s", err)
+	}
+	if summary.Failed > 0 {
+		fatal("%d of %d messages failed", summary.Failed, len(summary.Results))
+	}
+	return nil
+}
+
+// batchMessage is a message to submit, or a mailbox that couldn't be read
+type batchMessage struct {
+	mailboxMessage
+	err error
+}
+
+// messages reads the messages selected from each file. Files that can't be
+// read are failures, but don't stop the others being submitted.
+func (b *BatchCmd) messages() []batchMessage {
+	var messages []batchMessage
+	for _, file := range b.Files {
+		mailbox, err := readMailbox(file)
+		if err == nil {
+			mailbox, err = b.Selection.selected(mailbox)
+		}
+		if err != nil {
+			messages = append(messages, batchMessage{mailboxMessage: mailboxMessage{Name: file}, err: err})
+			continue
+		}
+		for _, msg := range mailbox {
+			messages = append(messages, batchMessage{mailboxMessage: msg})
+		}
+	}
+	return messages
+}
+
+// writeSummary writes the summary as JSON to the output file, or stdout
+func (b *BatchCmd) writeSummary(summary batchSummary) error {
+	var out io.Writer = os.Stdout
+	if b.Output != "" {
+		f, err := os.Create(b.Output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		out = f
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(summary)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"os"
+	"strings"
+)
+
+// Dkim is the flags that sign a message with DKIM before it's submitted
+type Dkim struct {
+	DkimKey              []string `help:"Sign the message with the PEM private key in this file, RSA or Ed25519; repeat for more signatures" type:"existingfile" placeholder:"key.pem"`
+	DkimSelector         []string `help:"Selector for each --dkim-key, in the same order" placeholder:"selector"`
+	DkimDomain           []string `help:"Signing domain (d=) for each --dkim-key, in the same order" placeholder:"domain"`
+	DkimHeaders          string   `help:"Header fields to sign, separated by colons, as in h=; list a name more times than it appears to oversign it (default the usual fields that are present)" placeholder:"from:to:..."`
+	DkimCanonicalization string   `help:"Header and body canonicalization, as in c=" default:"relaxed/relaxed" placeholder:"header/body"`
+}
+
+// signers returns a signer for each key given
+func (d Dkim) signers() ([]*dkimSigner, error) {
+	if len(d.DkimSelector) != len(d.DkimKey) || len(d.DkimDomain) != len(d.DkimKey) {
+		return nil, fmt.Errorf("each --dkim-key needs a --dkim-selector and --dkim-domain, got %d keys, %d selectors and %d domains",
+			len(d.DkimKey), len(d.DkimSelector), len(d.DkimDomain))
+	}
+	headers := splitColonList(d.DkimHeaders)
+	var signers []*dkimSigner
+	for i, file := range d.DkimKey {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseDkimKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		signer := &dkimSigner{Domain: d.DkimDomain[i], Selector: d.DkimSelector[i], Key: key, Headers: headers}
+		if err := signer.parseCanonicalization(d.DkimCanonicalization); err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+	return signers, nil
+}
+
+// sign adds a DKIM-Signature for each key to email, returning the signed
+// message and the fields added. Each signature is made over the message as
+// given, so they don't depend on each other.
+func (d Dkim) sign(email []byte) ([]byte, []string, error) {
+	if len(d.DkimKey) == 0 {
+		return email, nil, nil
+	}
+	signers, err := d.signers()
+	if err != nil {
+		return nil, nil, err
+	}
+	var fields []string
+	for _, signer := range signers {
+		field, err := signer.Sign(email)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to sign for %s: %w", signer.Domain, err)
+		}
+		fields = append(fields, field)
+	}
+	return prependHeaders(email, fields), fields, nil
+}
+
+// printSignatures displays the DKIM-Signature fields added to a message
+func printSignatures(fields []string) {
+	blue := color.New(color.FgHiBlue).SprintFunc()
+	for _, f := range fields {
+		_, _ = fmt.Fprintf(color.Output, "%s\n", blue(strings.ReplaceAll(strings.TrimRight(f, "\r\n"), "\r\n", "\n")))
+	}
+}
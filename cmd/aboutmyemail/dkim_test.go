@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"github.com/wttw/aboutmyemail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc8463Message is the example message from RFC 8463 appendix A
+const rfc8463Message = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+// rfc8463Signature is the Ed25519 signature of rfc8463Message
+const rfc8463Signature = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n"
+
+var dkimTagValue = regexp.MustCompile(`(^|;)(\s*b\s*=)[^;]*`)
+
+// verifyDkim checks the first DKIM-Signature in email with key, as a
+// receiver would
+func verifyDkim(t *testing.T, email []byte, key crypto.PublicKey) {
+	t.Helper()
+	email, _ = aboutmyemail.NormalizeLineEndings(email)
+	headers, body := splitDkimMessage(email)
+	var signature dkimHeader
+	for _, h := range headers {
+		if strings.EqualFold(h.Name, "DKIM-Signature") {
+			signature = h
+			break
+		}
+	}
+	if signature.Raw == nil {
+		t.Fatal("no DKIM-Signature")
+	}
+	_, value, _ := bytes.Cut(signature.Raw, []byte(":"))
+	tags := map[string]string{}
+	for _, tag := range strings.Split(string(value), ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+	}
+	var s dkimSigner
+	if err := s.parseCanonicalization(tags["c"]); err != nil {
+		t.Fatal(err)
+	}
+	bodyHash := sha256.Sum256(canonicalBody(body, s.BodyRelaxed))
+	if got := base64.StdEncoding.EncodeToString(bodyHash[:]); got != tags["bh"] {
+		t.Errorf("body hash %s, signature says %s", got, tags["bh"])
+	}
+	var names []string
+	for _, name := range strings.Split(tags["h"], ":") {
+		names = append(names, strings.TrimSpace(name))
+	}
+	hash := sha256.New()
+	for _, h := range selectDkimHeaders(headers, names) {
+		hash.Write(canonicalHeader(h, s.HeaderRelaxed))
+	}
+	unsigned := dkimHeader{Name: signature.Name, Raw: dkimTagValue.ReplaceAll(signature.Raw, []byte("$1$2"))}
+	hash.Write(bytes.TrimSuffix(canonicalHeader(unsigned, s.HeaderRelaxed), []byte("\r\n")))
+	sig, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatalf("bad b=: %v", err)
+	}
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(k, hash.Sum(nil), sig) {
+			t.Error("Ed25519 signature doesn't verify")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, hash.Sum(nil), sig); err != nil {
+			t.Errorf("RSA signature doesn't verify: %v", err)
+		}
+	}
+}
+
+// Our canonicalization reproduces the published example signature
+func TestDkimCanonicalization_RFC8463(t *testing.T) {
+	public, _ := base64.StdEncoding.DecodeString("11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+	verifyDkim(t, []byte(rfc8463Signature+rfc8463Message), ed25519.PublicKey(public))
+}
+
+func TestDkimSigner_Ed25519(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString("nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A=")
+	key := ed25519.NewKeyFromSeed(seed)
+	signer := &dkimSigner{Domain: "football.example.com", Selector: "brisbane", Key: key,
+		Headers:       []string{"from", "to", "subject", "date", "message-id", "from", "subject", "date"},
+		HeaderRelaxed: true, BodyRelaxed: true, Time: time.Unix(1528637909, 0)}
+	field, err := signer.Sign([]byte(rfc8463Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+		"\td=football.example.com; s=brisbane; t=1528637909;\r\n" +
+		"\th=from:to:subject:date:message-id:from:subject:date;\r\n" +
+		"\tbh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=; b="
+	if !strings.HasPrefix(field, want) {
+		t.Errorf("want signature starting %q, got %q", want, field)
+	}
+	for _, line := range strings.Split(field, "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line too long: %q", line)
+		}
+	}
+	verifyDkim(t, []byte(field+rfc8463Message), key.Public())
+
+	// LF line endings are kept, and signed as they'll be sent
+	lf := strings.ReplaceAll(rfc8463Message, "\r\n", "\n")
+	signed := prependHeaders([]byte(lf), []string{field})
+	if bytes.Contains(signed, []byte("\r")) {
+		t.Error("want LF line endings kept")
+	}
+	verifyDkim(t, signed, key.Public())
+}
+
+func TestDkimSigner_RSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := "From: a@example.com\r\nSubject:  folded\r\n  subject \r\nTo: b@example.org\r\n\r\nbody  with \t space  \r\n\r\n\r\n"
+	for _, c := range []string{"simple/simple", "relaxed/simple", "simple/relaxed", "relaxed"} {
+		signer := &dkimSigner{Domain: "example.com", Selector: "sel", Key: key}
+		if err := signer.parseCanonicalization(c); err != nil {
+			t.Fatal(err)
+		}
+		field, err := signer.Sign([]byte(message))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(field, "h=From:Subject:To;") {
+			t.Errorf("want default headers present signed, got %q", field)
+		}
+		verifyDkim(t, []byte(field+message), &key.PublicKey)
+	}
+	signer := &dkimSigner{Domain: "example.com", Selector: "sel", Key: key, Headers: []string{"subject"}}
+	if _, err := signer.Sign([]byte(message)); err == nil {
+		t.Error("want error without From signed")
+	}
+}
+
+func TestCanonicalBody(t *testing.T) {
+	tests := []struct {
+		body, simple, relaxed string
+	}{
+		{"", "\r\n", ""},
+		{"\r\n\r\n", "\r\n", ""},
+		{"a  b \t\r\n\r\n", "a  b \t\r\n", "a b\r\n"},
+		{"no end", "no end\r\n", "no end\r\n"},
+		{" \r\n", " \r\n", ""},
+	}
+	for _, tt := range tests {
+		if got := string(canonicalBody([]byte(tt.body), false)); got != tt.simple {
+			t.Errorf("simple %q: want %q, got %q", tt.body, tt.simple, got)
+		}
+		if got := string(canonicalBody([]byte(tt.body), true)); got != tt.relaxed {
+			t.Errorf("relaxed %q: want %q, got %q", tt.body, tt.relaxed, got)
+		}
+	}
+}
+
+// --dkim-key can be repeated for several signatures
+func TestDkimSign(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, block *pem.Block) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pkcs8, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	d := Dkim{
+		DkimKey: []string{
+			write("esp.pem", &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}),
+			write("ours.pem", &pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}),
+		},
+		DkimSelector:         []string{"esp1", "s2024"},
+		DkimDomain:           []string{"esp.example", "example.com"},
+		DkimCanonicalization: "relaxed/relaxed",
+	}
+	message := []byte("From: a@example.com\nTo: b@example.org\n\nbody\n")
+	signed, fields, err := d.sign(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fields) != 2 || !bytes.HasSuffix(signed, message) {
+		t.Fatalf("want two signatures added, got %q", signed)
+	}
+	for i, want := range []string{"a=rsa-sha256", "a=ed25519-sha256"} {
+		if !strings.Contains(fields[i], want) {
+			t.Errorf("signature %d: want %s, got %q", i, want, fields[i])
+		}
+	}
+	verifyDkim(t, signed, &rsaKey.PublicKey)
+	verifyDkim(t, []byte(strings.ReplaceAll(fields[1], "\r\n", "\n")+string(message)), edKey.Public())
+
+	d.DkimDomain = d.DkimDomain[:1]
+	if _, _, err := d.sign(message); err == nil {
+		t.Error("want error with a domain missing")
+	}
+}
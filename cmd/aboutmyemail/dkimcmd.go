@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"github.com/fatih/color"
+	"os"
+	"time"
+)
+
+type DkimCmd struct {
+	Keygen DkimKeygenCmd `cmd:"" help:"Generate a DKIM key pair and print its DNS record"`
+	Record DkimRecordCmd `cmd:"" help:"Print the DNS record for an existing DKIM private key"`
+	Verify DkimVerifyCmd `cmd:"" help:"Check the key published for a selector, and that it matches a private key"`
+}
+
+type DkimKeygenCmd struct {
+	Key      string `arg:"" help:"File to write the PEM private key to; it mustn't already exist" type:"path"`
+	Type     string `help:"Key type" enum:"rsa,ed25519" default:"rsa"`
+	Bits     int    `help:"RSA key size" default:"2048"`
+	Selector string `help:"Selector, to print the full DNS record" placeholder:"selector"`
+	Domain   string `help:"Signing domain, to print the full DNS record" placeholder:"domain"`
+}
+
+func (k *DkimKeygenCmd) Run(globals *Globals) error {
+	var key crypto.Signer
+	switch k.Type {
+	case "rsa":
+		if k.Bits < dkimMinBits {
+			fatal("Receivers ignore signatures from RSA keys under %d bits", dkimMinBits)
+		}
+		rsaKey, err := rsa.GenerateKey(rand.Reader, k.Bits)
+		if err != nil {
+			fatal("Failed to generate key: %s", err)
+		}
+		key = rsaKey
+	case "ed25519":
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			fatal("Failed to generate key: %s", err)
+		}
+		key = edKey
+		if !globals.Quiet {
+			printWarning("Not every receiver verifies Ed25519 signatures yet, so sign with an RSA key too")
+		}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		fatal("Failed to encode key: %s", err)
+	}
+	f, err := os.OpenFile(k.Key, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fatal("Failed to create key file: %s", err)
+	}
+	err = pem.Encode(f, &pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fatal("Failed to write key file: %s", err)
+	}
+	printDkimRecord(globals, key.Public(), k.Selector, k.Domain)
+	return nil
+}
+
+type DkimRecordCmd struct {
+	Key      string `arg:"" help:"PEM private key file" type:"existingfile"`
+	Selector string `help:"Selector, to print the full DNS record" placeholder:"selector"`
+	Domain   string `help:"Signing domain, to print the full DNS record" placeholder:"domain"`
+}
+
+func (r *DkimRecordCmd) Run(globals *Globals) error {
+	key, err := readDkimKey(r.Key)
+	if err != nil {
+		fatal("%s", err)
+	}
+	printDkimRecord(globals, key.Public(), r.Selector, r.Domain)
+	return nil
+}
+
+type DkimVerifyCmd struct {
+	Selector string `required:"" help:"Selector to check" placeholder:"selector"`
+	Domain   string `required:"" help:"Signing domain to check" placeholder:"domain"`
+	Key      string `help:"PEM private key file the published key should match" type:"existingfile" placeholder:"key.pem"`
+	Resolver string `help:"DNS server to query, rather than the system resolver" placeholder:"host:port"`
+}
+
+func (v *DkimVerifyCmd) Run(globals *Globals) error {
+	var private crypto.Signer
+	if v.Key != "" {
+		var err error
+		private, err = readDkimKey(v.Key)
+		if err != nil {
+			fatal("%s", err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	result, err := v.verify(ctx, private)
+	if err != nil {
+		fatal("%s", err)
+	}
+	for _, w := range result.warnings {
+		printWarning("%s", w)
+	}
+	if !globals.Quiet {
+		blue := color.New(color.FgHiBlue).SprintFunc()
+		green := color.New(color.FgHiGreen).SprintFunc()
+		_, _ = fmt.Fprintf(color.Output, "Record:  %s\n", blue(dkimRecordName(v.Selector, v.Domain)))
+		_, _ = fmt.Fprintf(color.Output, "Key:     %s\n", blue(describeDkimKey(result.record.Key)))
+		if private != nil {
+			_, _ = fmt.Fprintf(color.Output, "%s\n", green("The published key matches "+v.Key))
+		}
+	}
+	return nil
+}
+
+// dkimVerifyResult is what verify found for a selector
+type dkimVerifyResult struct {
+	record   *dkimRecord
+	warnings []string
+}
+
+// verify looks up the selector's key record and checks it, and that it
+// matches private if that's not nil. Problems that stop signatures
+// verifying are errors, others are warnings.
+func (v *DkimVerifyCmd) verify(ctx context.Context, private crypto.Signer) (*dkimVerifyResult, error) {
+	record, err := lookupDkimRecord(ctx, dnsResolver(v.Resolver), v.Selector, v.Domain)
+	if err != nil {
+		return nil, err
+	}
+	if record.Key == nil {
+		return nil, fmt.Errorf("the key for %s has been revoked (empty p=)", dkimRecordName(v.Selector, v.Domain))
+	}
+	result := &dkimVerifyResult{record: record}
+	warnings, weak := dkimKeyWarnings(record.Key)
+	if weak {
+		return nil, fmt.Errorf("published key: %s", warnings[0])
+	}
+	result.warnings = append(result.warnings, warnings...)
+	if flags := record.Tags["t"]; flags != "" {
+		for _, flag := range splitColonList(flags) {
+			if flag == "y" {
+				result.warnings = append(result.warnings, "the record has t=y, so receivers may treat the domain as testing DKIM")
+			}
+		}
+	}
+	if hashes := record.Tags["h"]; hashes != "" && !containsFold(splitColonList(hashes), "sha256") {
+		return nil, fmt.Errorf("the record only allows h=%s, but signatures use sha256", hashes)
+	}
+	if private != nil && !sameDkimKey(record.Key, private) {
+		return nil, fmt.Errorf("the published key doesn't match %s", v.Key)
+	}
+	return result, nil
+}
+
+// readDkimKey reads a PEM private key file
+func readDkimKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parseDkimKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return key, nil
+}
+
+// printDkimRecord prints the DNS record for a public key, with warnings
+// about its strength
+func printDkimRecord(globals *Globals, key crypto.PublicKey, selector, domain string) {
+	value, err := dkimRecordValue(key)
+	if err != nil {
+		fatal("%s", err)
+	}
+	warnings, _ := dkimKeyWarnings(key)
+	for _, w := range warnings {
+		printWarning("%s", w)
+	}
+	fmt.Println(dkimZoneRecord(selector, domain, value))
+}
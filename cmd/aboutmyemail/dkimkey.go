@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// dkimMinBits is the smallest RSA key receivers accept (RFC 8301), and
+// dkimGoodBits the smallest that's reasonable to publish today
+const (
+	dkimMinBits  = 1024
+	dkimGoodBits = 2048
+)
+
+// dkimRecord is a parsed DKIM key record (RFC 6376 section 3.6.1)
+type dkimRecord struct {
+	// Tags is every tag in the record
+	Tags map[string]string
+	// Key is the public key, nil if the key has been revoked
+	Key crypto.PublicKey
+}
+
+// dkimPublicKeyData returns the p= value for a public key
+func dkimPublicKeyData(key crypto.PublicKey) (string, string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return "", "", err
+		}
+		return "rsa", base64.StdEncoding.EncodeToString(der), nil
+	case ed25519.PublicKey:
+		// RFC 8463 publishes the bare key rather than a
+		// SubjectPublicKeyInfo
+		return "ed25519", base64.StdEncoding.EncodeToString(k), nil
+	}
+	return "", "", fmt.Errorf("unsupported DKIM key type %T", key)
+}
+
+// dkimRecordValue returns the TXT record that publishes key
+func dkimRecordValue(key crypto.PublicKey) (string, error) {
+	keyType, data, err := dkimPublicKeyData(key)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("v=DKIM1; k=%s; p=%s", keyType, data), nil
+}
+
+// splitTXT splits a TXT record value into character-strings of at most 255
+// bytes, as a single one can't be longer
+func splitTXT(value string) []string {
+	var parts []string
+	for len(value) > 255 {
+		parts = append(parts, value[:255])
+		value = value[255:]
+	}
+	return append(parts, value)
+}
+
+// dkimZoneRecord formats the TXT record for a selector as a zone file line,
+// or just the quoted strings if the selector or domain isn't known
+func dkimZoneRecord(selector, domain, value string) string {
+	var quoted []string
+	for _, s := range splitTXT(value) {
+		quoted = append(quoted, `"`+strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)+`"`)
+	}
+	if selector == "" || domain == "" {
+		return strings.Join(quoted, "\n")
+	}
+	return fmt.Sprintf("%s IN TXT ( %s )", dkimRecordName(selector, domain), strings.Join(quoted, "\n\t"))
+}
+
+// dkimRecordName is the DNS name a selector's key is published at
+func dkimRecordName(selector, domain string) string {
+	return selector + "._domainkey." + strings.TrimSuffix(domain, ".") + "."
+}
+
+// parseDkimRecord parses a DKIM key record
+func parseDkimRecord(value string) (*dkimRecord, error) {
+	record := &dkimRecord{Tags: map[string]string{}}
+	for _, tag := range strings.Split(value, ";") {
+		if strings.TrimSpace(tag) == "" {
+			continue
+		}
+		name, v, found := strings.Cut(tag, "=")
+		if !found {
+			return nil, fmt.Errorf("bad tag %q", strings.TrimSpace(tag))
+		}
+		// Values can contain folding whitespace, which isn't part of them
+		record.Tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(v), "")
+	}
+	if v, ok := record.Tags["v"]; ok && v != "DKIM1" {
+		return nil, fmt.Errorf("unknown record version v=%s", v)
+	}
+	data, ok := record.Tags["p"]
+	if !ok {
+		return nil, errors.New("no public key (p=) in record")
+	}
+	if data == "" {
+		return record, nil
+	}
+	der, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, fmt.Errorf("bad public key: %w", err)
+	}
+	switch keyType := record.Tags["k"]; keyType {
+	case "", "rsa":
+		key, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			// Some publish the bare RSAPublicKey, as RFC 6376 describes
+			key, err = x509.ParsePKCS1PublicKey(der)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad RSA public key: %w", err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("k=rsa but the key is %T", key)
+		}
+		record.Key = rsaKey
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("Ed25519 key is %d bytes, want %d", len(der), ed25519.PublicKeySize)
+		}
+		record.Key = ed25519.PublicKey(der)
+	default:
+		return nil, fmt.Errorf("unknown key type k=%s", keyType)
+	}
+	return record, nil
+}
+
+// describeDkimKey returns the type and size of a public key, e.g. rsa 2048 bits
+func describeDkimKey(key crypto.PublicKey) string {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("rsa %d bits", k.N.BitLen())
+	case ed25519.PublicKey:
+		return "ed25519"
+	}
+	return fmt.Sprintf("%T", key)
+}
+
+// dkimKeyWarnings returns any problems with a key's strength. weak is set
+// if receivers will ignore signatures made with it.
+func dkimKeyWarnings(key crypto.PublicKey) (warnings []string, weak bool) {
+	k, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, false
+	}
+	switch bits := k.N.BitLen(); {
+	case bits < dkimMinBits:
+		return []string{fmt.Sprintf("%d bit RSA key is too weak, receivers ignore signatures from keys under %d bits", bits, dkimMinBits)}, true
+	case bits < dkimGoodBits:
+		return []string{fmt.Sprintf("%d bit RSA key is weak, use %d bits or more", bits, dkimGoodBits)}, false
+	}
+	return nil, false
+}
+
+// dnsResolver returns a resolver that sends queries to server, host or
+// host:port, or the system resolver if server is empty
+func dnsResolver(server string) *net.Resolver {
+	if server == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, server)
+		},
+	}
+}
+
+// lookupDkimRecord fetches and parses the key record for a selector
+func lookupDkimRecord(ctx context.Context, resolver *net.Resolver, selector, domain string) (*dkimRecord, error) {
+	name := dkimRecordName(selector, domain)
+	values, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up %s: %w", name, err)
+	}
+	var records []string
+	for _, v := range values {
+		if strings.Contains(v, "p=") {
+			records = append(records, v)
+		}
+	}
+	switch len(records) {
+	case 0:
+		return nil, fmt.Errorf("no DKIM key record at %s", name)
+	case 1:
+	default:
+		return nil, fmt.Errorf("%d DKIM key records at %s, receivers can't tell which to use", len(records), name)
+	}
+	record, err := parseDkimRecord(records[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return record, nil
+}
+
+// sameDkimKey reports whether a published key is the public half of a
+// private key
+func sameDkimKey(public crypto.PublicKey, private crypto.Signer) bool {
+	switch k := public.(type) {
+	case *rsa.PublicKey:
+		return k.Equal(private.Public())
+	case ed25519.PublicKey:
+		return k.Equal(private.Public())
+	}
+	return false
+}
+
+// splitColonList splits a colon separated tag value, such as t=y:s
+func splitColonList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ":") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+)
+
+// startDNS runs a DNS server on a local UDP port until the test ends. It
+// answers TXT queries from records, keyed by lowercase name without the
+// final dot, splitting each value into character-strings as it would be
+// published.
+func startDNS(t *testing.T, records map[string][]string) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if response := dnsAnswer(buf[:n], records); response != nil {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		_ = conn.Close()
+		<-done
+	})
+	return conn.LocalAddr().String()
+}
+
+// dnsAnswer builds the response to a single question query
+func dnsAnswer(query []byte, records map[string][]string) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+	var labels []string
+	off := 12
+	for off < len(query) && query[off] != 0 {
+		end := off + 1 + int(query[off])
+		if end > len(query) {
+			return nil
+		}
+		labels = append(labels, string(query[off+1:end]))
+		off = end
+	}
+	off += 5
+	if off > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[off-4:])
+	values, found := records[strings.ToLower(strings.Join(labels, "."))]
+
+	// QR, AA, RD and RA, with NXDOMAIN for unknown names
+	flags := uint16(0x8580)
+	if !found {
+		flags |= 3
+	}
+	var answers [][]byte
+	if qtype == 16 {
+		for _, v := range values {
+			var rdata []byte
+			for _, s := range splitTXT(v) {
+				rdata = append(append(rdata, byte(len(s))), s...)
+			}
+			// Name as a pointer to the question, TXT, IN, TTL 300
+			rr := []byte{0xc0, 12, 0, 16, 0, 1, 0, 0, 1, 44}
+			rr = binary.BigEndian.AppendUint16(rr, uint16(len(rdata)))
+			answers = append(answers, append(rr, rdata...))
+		}
+	}
+	response := append([]byte{}, query[:2]...)
+	response = binary.BigEndian.AppendUint16(response, flags)
+	response = append(response, 0, 1)
+	response = binary.BigEndian.AppendUint16(response, uint16(len(answers)))
+	response = append(response, 0, 0, 0, 0)
+	response = append(response, query[12:off]...)
+	for _, a := range answers {
+		response = append(response, a...)
+	}
+	return response
+}
+
+func TestSplitTXT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := dkimRecordValue(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := splitTXT(value)
+	if len(parts) != (len(value)+254)/255 || strings.Join(parts, "") != value {
+		t.Fatalf("bad split of %d bytes into %d", len(value), len(parts))
+	}
+	for _, p := range parts {
+		if len(p) > 255 {
+			t.Errorf("string of %d bytes", len(p))
+		}
+	}
+	zone := dkimZoneRecord("s1", "example.com", value)
+	if !strings.HasPrefix(zone, `s1._domainkey.example.com. IN TXT ( "v=DKIM1; k=rsa; p=`) || strings.Count(zone, `"`) != 2*len(parts) {
+		t.Errorf("bad zone record %q", zone)
+	}
+}
+
+func TestDkimVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	smallKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	// Too small to generate, but it only needs publishing
+	tiny := &rsa.PublicKey{N: new(big.Int).Add(new(big.Int).Lsh(big.NewInt(1), 511), big.NewInt(1)), E: 65537}
+	record := func(key any) string {
+		value, err := dkimRecordValue(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return value
+	}
+	server := startDNS(t, map[string][]string{
+		"s2048._domainkey.example.com": {"v=spf1 -all", record(&rsaKey.PublicKey)},
+		"s1024._domainkey.example.com": {record(&smallKey.PublicKey)},
+		"s512._domainkey.example.com":  {record(tiny)},
+		"ed._domainkey.example.com":    {record(edKey.Public())},
+		"test._domainkey.example.com":  {strings.Replace(record(&rsaKey.PublicKey), "k=rsa;", "k=rsa; t=y;", 1)},
+		"gone._domainkey.example.com":  {"v=DKIM1; k=rsa; p="},
+		"two._domainkey.example.com":   {record(&rsaKey.PublicKey), record(&smallKey.PublicKey)},
+	})
+
+	tests := []struct {
+		selector string
+		key      crypto.Signer
+		warning  string
+		err      string
+	}{
+		{"s2048", rsaKey, "", ""},
+		{"s2048", nil, "", ""},
+		{"s2048", smallKey, "", "doesn't match"},
+		{"s1024", smallKey, "1024 bit RSA key is weak", ""},
+		{"s512", nil, "", "too weak"},
+		{"ed", edKey, "", ""},
+		{"ed", rsaKey, "", "doesn't match"},
+		{"test", nil, "t=y", ""},
+		{"gone", nil, "", "revoked"},
+		{"two", nil, "", "2 DKIM key records"},
+		{"missing", nil, "", "failed to look up"},
+	}
+	for _, tt := range tests {
+		cmd := &DkimVerifyCmd{Selector: tt.selector, Domain: "example.com", Resolver: server, Key: "key.pem"}
+		result, err := cmd.verify(context.Background(), tt.key)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: want error %q, got %v", tt.selector, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.selector, err)
+			continue
+		}
+		warnings := strings.Join(result.warnings, "\n")
+		if (tt.warning == "") != (warnings == "") || !strings.Contains(warnings, tt.warning) {
+			t.Errorf("%s: want warning %q, got %q", tt.selector, tt.warning, warnings)
+		}
+	}
+}
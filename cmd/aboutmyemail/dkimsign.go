@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/wttw/aboutmyemail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dkimDefaultHeaders are the header fields signed if they're present and
+// no list is given, much as mail servers sign by default
+var dkimDefaultHeaders = []string{
+	"From", "Reply-To", "Subject", "Date", "To", "Cc", "Message-ID",
+	"In-Reply-To", "References", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding", "List-Id", "List-Unsubscribe",
+	"List-Unsubscribe-Post", "Feedback-ID", "Sender",
+}
+
+// dkimSigner signs messages with one DKIM key (RFC 6376, and RFC 8463 for
+// Ed25519)
+type dkimSigner struct {
+	Domain   string
+	Selector string
+	Key      crypto.Signer
+	// Headers is the header fields to sign, in h= order. A name can be
+	// given more times than the field appears to stop more being added.
+	// If it's empty dkimDefaultHeaders that are present are signed.
+	Headers []string
+	// HeaderRelaxed and BodyRelaxed choose relaxed rather than simple
+	// canonicalization
+	HeaderRelaxed bool
+	BodyRelaxed   bool
+	// Time is the signature timestamp, now if it's zero
+	Time time.Time
+}
+
+// dkimHeader is one header field of a message: its name, and the whole
+// field as it appears including any folding and the final line ending
+type dkimHeader struct {
+	Name string
+	Raw  []byte
+}
+
+// parseCanonicalization sets the header and body canonicalization from a
+// c= style value, such as relaxed/simple
+func (s *dkimSigner) parseCanonicalization(value string) error {
+	header, body, found := strings.Cut(strings.ToLower(value), "/")
+	if !found {
+		body = "simple"
+	}
+	for _, c := range []struct {
+		name    string
+		relaxed *bool
+	}{{header, &s.HeaderRelaxed}, {body, &s.BodyRelaxed}} {
+		switch c.name {
+		case "simple":
+			*c.relaxed = false
+		case "relaxed":
+			*c.relaxed = true
+		default:
+			return fmt.Errorf("unknown DKIM canonicalization %q", value)
+		}
+	}
+	return nil
+}
+
+// canonicalization returns the c= value for the signer
+func (s *dkimSigner) canonicalization() string {
+	name := func(relaxed bool) string {
+		if relaxed {
+			return "relaxed"
+		}
+		return "simple"
+	}
+	return name(s.HeaderRelaxed) + "/" + name(s.BodyRelaxed)
+}
+
+// algorithm returns the a= value for the signer's key
+func (s *dkimSigner) algorithm() (string, error) {
+	switch s.Key.(type) {
+	case *rsa.PrivateKey:
+		return "rsa-sha256", nil
+	case ed25519.PrivateKey:
+		return "ed25519-sha256", nil
+	}
+	return "", fmt.Errorf("unsupported DKIM key type %T", s.Key)
+}
+
+// Sign returns the DKIM-Signature header field for email, ending in CRLF.
+// Line endings in email needn't be CRLF, the signature is made over the
+// message as it'll be sent with CRLF line endings.
+func (s *dkimSigner) Sign(email []byte) (string, error) {
+	algorithm, err := s.algorithm()
+	if err != nil {
+		return "", err
+	}
+	email, _ = aboutmyemail.NormalizeLineEndings(email)
+	headers, body := splitDkimMessage(email)
+
+	bodyHash := sha256.Sum256(canonicalBody(body, s.BodyRelaxed))
+	names := s.Headers
+	if len(names) == 0 {
+		for _, name := range dkimDefaultHeaders {
+			for _, h := range headers {
+				if strings.EqualFold(h.Name, name) {
+					names = append(names, name)
+				}
+			}
+		}
+	}
+	if !containsFold(names, "From") {
+		return "", errors.New("DKIM signatures must sign the From header")
+	}
+
+	timestamp := s.Time
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	tags := []string{
+		"v=1",
+		"a=" + algorithm,
+		"c=" + s.canonicalization(),
+		"d=" + s.Domain,
+		"s=" + s.Selector,
+		"t=" + strconv.FormatInt(timestamp.Unix(), 10),
+		"h=" + strings.Join(names, ":"),
+		"bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]),
+		"b=",
+	}
+	field, column := foldDkimTags("DKIM-Signature: ", tags)
+
+	hash := sha256.New()
+	for _, h := range selectDkimHeaders(headers, names) {
+		hash.Write(canonicalHeader(h, s.HeaderRelaxed))
+	}
+	hash.Write(bytes.TrimSuffix(canonicalHeader(dkimHeader{Name: "DKIM-Signature", Raw: []byte(field + "\r\n")}, s.HeaderRelaxed), []byte("\r\n")))
+	digest := hash.Sum(nil)
+
+	var signature []byte
+	switch key := s.Key.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest)
+	case ed25519.PrivateKey:
+		// RFC 8463 signs the SHA-256 hash with PureEdDSA
+		signature = ed25519.Sign(key, digest)
+	}
+	if err != nil {
+		return "", err
+	}
+	return field + foldBase64(base64.StdEncoding.EncodeToString(signature), column) + "\r\n", nil
+}
+
+// foldDkimTags joins tags into a header field, folding lines before 78
+// characters where it can. It also returns the column the field ends at.
+func foldDkimTags(prefix string, tags []string) (string, int) {
+	var b strings.Builder
+	b.WriteString(prefix)
+	column := len(prefix)
+	for i, tag := range tags {
+		if i < len(tags)-1 {
+			tag += ";"
+		}
+		if i > 0 {
+			if column+1+len(tag) > 78 {
+				b.WriteString("\r\n\t")
+				column = 8
+			} else {
+				b.WriteByte(' ')
+				column++
+			}
+		}
+		b.WriteString(tag)
+		column += len(tag)
+	}
+	return b.String(), column
+}
+
+// foldBase64 folds a long base64 value, which starts at column, into lines
+// of at most 78 characters
+func foldBase64(value string, column int) string {
+	var b strings.Builder
+	for value != "" {
+		n := min(len(value), max(78-column, 1))
+		b.WriteString(value[:n])
+		value = value[n:]
+		if value != "" {
+			b.WriteString("\r\n\t")
+			column = 8
+		}
+	}
+	return b.String()
+}
+
+// splitDkimMessage splits a CRLF message into its header fields and body
+func splitDkimMessage(email []byte) ([]dkimHeader, []byte) {
+	var headers []dkimHeader
+	for len(email) > 0 {
+		if bytes.HasPrefix(email, []byte("\r\n")) {
+			return headers, email[2:]
+		}
+		end := 0
+		for {
+			i := bytes.Index(email[end:], []byte("\r\n"))
+			if i < 0 {
+				end = len(email)
+				break
+			}
+			end += i + 2
+			if end >= len(email) || (email[end] != ' ' && email[end] != '\t') {
+				break
+			}
+		}
+		raw := email[:end]
+		name, _, _ := bytes.Cut(raw, []byte(":"))
+		headers = append(headers, dkimHeader{Name: string(bytes.TrimRight(name, " \t")), Raw: raw})
+		email = email[end:]
+	}
+	return headers, nil
+}
+
+// selectDkimHeaders picks the fields for each name in h=, taking each name's
+// fields from the bottom up. Names with no fields left are skipped.
+func selectDkimHeaders(headers []dkimHeader, names []string) []dkimHeader {
+	used := make([]bool, len(headers))
+	var selected []dkimHeader
+	for _, name := range names {
+		for i := len(headers) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(headers[i].Name, name) {
+				used[i] = true
+				selected = append(selected, headers[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// canonicalHeader canonicalizes a header field, simple or relaxed (RFC 6376
+// section 3.4)
+func canonicalHeader(h dkimHeader, relaxed bool) []byte {
+	if !relaxed {
+		return h.Raw
+	}
+	_, value, _ := bytes.Cut(h.Raw, []byte(":"))
+	value = bytes.ReplaceAll(value, []byte("\r\n"), nil)
+	value = collapseWSP(value)
+	value = bytes.Trim(value, " ")
+	out := append([]byte(strings.ToLower(h.Name)), ':')
+	out = append(out, value...)
+	return append(out, '\r', '\n')
+}
+
+// canonicalBody canonicalizes a CRLF message body, simple or relaxed
+func canonicalBody(body []byte, relaxed bool) []byte {
+	if relaxed {
+		lines := bytes.SplitAfter(body, []byte("\r\n"))
+		var out []byte
+		for _, line := range lines {
+			content, hasEnd := bytes.CutSuffix(line, []byte("\r\n"))
+			out = append(out, bytes.TrimRight(collapseWSP(content), " ")...)
+			if hasEnd {
+				out = append(out, '\r', '\n')
+			}
+		}
+		body = out
+	}
+	for bytes.HasSuffix(body, []byte("\r\n\r\n")) {
+		body = body[:len(body)-2]
+	}
+	if len(body) == 0 {
+		if relaxed {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	if !bytes.HasSuffix(body, []byte("\r\n")) {
+		body = append(body[:len(body):len(body)], '\r', '\n')
+	}
+	if relaxed && bytes.Equal(body, []byte("\r\n")) {
+		return nil
+	}
+	return body
+}
+
+// collapseWSP replaces each run of spaces and tabs with a single space
+func collapseWSP(s []byte) []byte {
+	out := make([]byte, 0, len(s))
+	inWSP := false
+	for _, c := range s {
+		if c == ' ' || c == '\t' {
+			if !inWSP {
+				out = append(out, ' ')
+			}
+			inWSP = true
+			continue
+		}
+		inWSP = false
+		out = append(out, c)
+	}
+	return out
+}
+
+// prependHeaders adds header fields, which end in CRLF, to the top of
+// email, using the line endings email already has
+func prependHeaders(email []byte, fields []string) []byte {
+	firstLine, _, _ := bytes.Cut(email, []byte("\n"))
+	lf := !bytes.HasSuffix(firstLine, []byte("\r"))
+	var out []byte
+	for _, f := range fields {
+		if lf {
+			f = strings.ReplaceAll(f, "\r\n", "\n")
+		}
+		out = append(out, f...)
+	}
+	return append(out, email...)
+}
+
+// parseDkimKey parses a PEM private key, PKCS#1 or PKCS#8 RSA or PKCS#8
+// Ed25519
+func parseDkimKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM private key found")
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *rsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"net"
+	"net/mail"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Envelope is the SMTP envelope flags shared by the commands that submit messages
+type Envelope struct {
+	From   string `help:"Email address for return path" placeholder:"email@address"`
+	To     string `help:"Email address for recipient" placeholder:"email@address"`
+	Ip     string `help:"IP address of mailserver" placeholder:"dotted-quad"`
+	Helo   string `help:"Value for mailserver HELO" placeholder:"host.name"`
+	Ascii  bool   `help:"Disable internationalization"`
+	Staged bool   `help:"Display result using staged whitelabel configuration"`
+	Raw    bool   `help:"Submit the message bytes exactly as read, without repairing them or normalizing line endings"`
+
+	Received bool     `help:"Take the IP and HELO from the Received header added by the first trusted receiver, for messages that were already delivered"`
+	Trusted  []string `help:"Trusted receivers for --received: MX hostnames (.domain for any in it), IP addresses or CIDRs" placeholder:"host-or-cidr"`
+
+	// hop explains where the IP and HELO came from, when taken from a Received header
+	hop string `kong:"-"`
+}
+
+// resolve fills in any envelope fields not given on the command line, from
+// the message itself, the config file profile or the local host
+func (e Envelope) resolve(email []byte, profile aboutmyemail.Profile) (Envelope, error) {
+	e.From, e.To = defaultAddresses(email, e.From, e.To)
+	if e.Ascii && localpartNeedsUTF8(e.From, e.To) {
+		return e, errors.New("--ascii given, but an address localpart is non-ASCII and can't be sent without SMTPUTF8")
+	}
+	if e.Received && (e.Ip == "" || e.Helo == "") {
+		if err := e.fromReceived(email, profile); err != nil {
+			return e, err
+		}
+	}
+	if e.Ip == "" {
+		e.Ip = profile.Ip
+	}
+	if e.Ip == "" {
+		conn, err := net.Dial("udp", "8.8.8.8:80")
+		if err == nil {
+			e.Ip = conn.LocalAddr().(*net.UDPAddr).IP.String()
+			_ = conn.Close()
+		}
+	}
+	if e.Helo == "" {
+		e.Helo = profile.Helo
+	}
+	if e.Helo == "" {
+		e.Helo, _ = os.Hostname()
+	}
+	return e, nil
+}
+
+// fromReceived fills in the IP and HELO from the message's Received header
+// fields, using the trusted receivers from the command line or profile
+func (e *Envelope) fromReceived(email []byte, profile aboutmyemail.Profile) error {
+	trusted := e.Trusted
+	if len(trusted) == 0 {
+		trusted = profile.TrustedReceivers
+	}
+	trust, err := parseTrustList(trusted)
+	if err != nil {
+		return err
+	}
+	hops, err := receivedHops(email)
+	if err != nil {
+		return err
+	}
+	hop, why, err := chooseReceivedHop(hops, trust)
+	if err != nil {
+		return fmt.Errorf("--received: %w", err)
+	}
+	if e.Ip == "" {
+		e.Ip = hop.IP
+	}
+	if e.Helo == "" {
+		e.Helo = hop.Helo
+	}
+	e.hop = fmt.Sprintf("%s\n         %s", hop, why)
+	return nil
+}
+
+// formThreshold is the message size above which it's streamed as a
+// multipart form rather than copied into a JSON body
+const formThreshold = 1 << 20
+
+// useForm reports whether email must or should be submitted as a multipart
+// form: JSON strings can't carry bytes that aren't valid UTF-8, and hold a
+// large message in memory several times over.
+func useForm(email []byte) bool {
+	return len(email) > formThreshold || !utf8.Valid(email)
+}
+
+// submitEmail submits a request built by Envelope.request for email, as a
+// multipart form or JSON as useForm decides
+func submitEmail(ctx context.Context, client *aboutmyemail.ClientWithResponses, request aboutmyemail.Submit, email []byte) (*aboutmyemail.EmailResponse, error) {
+	if useForm(email) {
+		return client.EmailFormWithResponse(ctx, request, bytes.NewReader(email))
+	}
+	return client.EmailWithResponse(ctx, request)
+}
+
+// request builds the API submission for email sent with this envelope. The
+// payload is left out if the message will be sent as a form.
+func (e Envelope) request(email []byte) aboutmyemail.Submit {
+	smtputf8 := !e.Ascii
+	helo := e.Helo
+	var options string
+	if e.Staged {
+		options = "stage"
+	}
+	request := aboutmyemail.Submit{
+		From:     e.From,
+		Ip:       e.Ip,
+		Helo:     &helo,
+		Smtputf8: &smtputf8,
+		To:       e.To,
+		Options:  &options,
+	}
+	if !useForm(email) {
+		request.Payload = string(email)
+	}
+	return request
+}
+
+// print displays the envelope that will be used
+func (e Envelope) print(payloadSize int) {
+	blue := color.New(color.FgHiBlue).SprintFunc()
+	_, _ = fmt.Fprintf(color.Output, "From:    %s\n", blue(e.From))
+	_, _ = fmt.Fprintf(color.Output, "To:      %s\n", blue(e.To))
+	_, _ = fmt.Fprintf(color.Output, "IP:      %s\n", blue(e.Ip))
+	_, _ = fmt.Fprintf(color.Output, "Helo:    %s\n", blue(e.Helo))
+	if e.hop != "" {
+		_, _ = fmt.Fprintf(color.Output, "Hop:     %s\n", e.hop)
+	}
+	_, _ = fmt.Fprintf(color.Output, "Payload: %s\n", blue(fmt.Sprintf("%d bytes", payloadSize)))
+}
+
+// defaultAddresses fills in an empty from or to from the message itself: from
+// the Return-Path (falling back to From) and the To header respectively.
+func defaultAddresses(email []byte, from, to string) (string, string) {
+	if from != "" && to != "" {
+		return from, to
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(email))
+	if err != nil {
+		return from, to
+	}
+	if from == "" {
+		returnPath, err := msg.Header.AddressList("Return-Path")
+		if err == nil && len(returnPath) > 0 {
+			from = returnPath[0].Address
+		} else {
+			fromList, err := msg.Header.AddressList("From")
+			if err == nil && len(fromList) > 0 {
+				from = fromList[0].Address
+			}
+		}
+	}
+	if to == "" {
+		toList, err := msg.Header.AddressList("To")
+		if err == nil && len(toList) > 0 {
+			to = toList[0].Address
+		}
+	}
+	return from, to
+}
+
+// localpartNeedsUTF8 reports whether any address has a non-ASCII localpart.
+// (A non-ASCII domain can be A-label encoded, so domains are ignored here.)
+func localpartNeedsUTF8(addrs ...string) bool {
+	for _, a := range addrs {
+		local := a
+		if at := strings.LastIndex(a, "@"); at >= 0 {
+			local = a[:at]
+		}
+		for _, r := range local {
+			if r > unicode.MaxASCII {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// headerMatch is a parsed "Name: regexp" flag, matching header fields
+type headerMatch struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// parseHeaderMatches parses the values given for a "Name: regexp" flag
+func parseHeaderMatches(flag string, values []string) ([]headerMatch, error) {
+	var matches []headerMatch
+	for _, h := range values {
+		name, pattern, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%s %q should be Name: regexp", flag, h)
+		}
+		re, err := regexp.Compile(strings.TrimSpace(pattern))
+		if err != nil {
+			return nil, fmt.Errorf("%s %q: %w", flag, h, err)
+		}
+		matches = append(matches, headerMatch{name: strings.TrimSpace(name), re: re})
+	}
+	return matches, nil
+}
+
+// match reports whether a header field matches
+func (h headerMatch) match(name, value string) bool {
+	return strings.EqualFold(name, h.name) && h.re.MatchString(strings.TrimSpace(value))
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/fatih/color"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+type ImapCmd struct {
+	Envelope
+	Address      string `arg:"" help:"IMAP server, as host or host:port (port 993, or 143 with --starttls, if not given)" placeholder:"host:port"`
+	User         string `env:"MYEMAIL_IMAP_USER" required:"" help:"Username to log in with"`
+	Password     string `env:"MYEMAIL_IMAP_PASSWORD" help:"Password to log in with, prompted for if not given"`
+	PasswordFile string `help:"Read the password from this file, or stdin if it's -" placeholder:"file"`
+	Folder       string `help:"Folder to look for the message in" default:"INBOX"`
+	Uid          uint32 `help:"Fetch the message with this UID, rather than searching"`
+	Subject      string `help:"Only consider messages with this in the Subject" placeholder:"text"`
+	Sender       string `help:"Only consider messages with this in the From header" placeholder:"text"`
+	Since        string `help:"Only consider messages that arrived on or after this date, as YYYY-MM-DD, RFC3339 or a duration ago" placeholder:"when"`
+	Before       string `help:"Only consider messages that arrived before this date" placeholder:"when"`
+	Starttls     bool   `help:"Connect without TLS then use STARTTLS, rather than connecting with TLS"`
+	Insecure     bool   `help:"Don't verify the server's TLS certificate"`
+	Open         bool   `help:"Open result in browser"`
+	Report       bool   `help:"Print the machine-readable analysis report as JSON once processing completes"`
+}
+
+func (m *ImapCmd) Run(globals *Globals) error {
+	criteria, err := m.criteria()
+	if err != nil {
+		fatal("%s", err)
+	}
+	password := m.password()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	uid, data, err := m.fetch(ctx, password, criteria)
+	if err != nil {
+		fatal("%s", err)
+	}
+	if !globals.Quiet {
+		_, _ = fmt.Fprintf(color.Output, "Fetched UID %d from %s\n", uid, m.Folder)
+	}
+
+	email := data
+	if !m.Raw {
+		email, err = importEmail("", email)
+		if err != nil {
+			fatal("%s", err)
+		}
+	}
+	envelope, err := m.Envelope.resolve(email, globals.profile)
+	if err != nil {
+		fatal("%s", err)
+	}
+	if !globals.Quiet {
+		envelope.print(len(email))
+	}
+
+	client := newClient(globals)
+	submit := SubmitCmd{Open: m.Open, Report: m.Report}
+	submit.pollForResults(ctx, envelope.request(email), email, globals, client)
+	return nil
+}
+
+// criteria builds the SEARCH criteria from the flags
+func (m *ImapCmd) criteria() ([]any, error) {
+	var criteria []any
+	if m.Subject != "" {
+		criteria = append(criteria, "SUBJECT", imapString(m.Subject))
+	}
+	if m.Sender != "" {
+		criteria = append(criteria, "FROM", imapString(m.Sender))
+	}
+	for _, date := range []struct{ flag, value, key string }{
+		{"--since", m.Since, "SINCE"},
+		{"--before", m.Before, "BEFORE"},
+	} {
+		if date.value == "" {
+			continue
+		}
+		t, err := parseTimeFlag(date.value)
+		if err != nil {
+			return nil, fmt.Errorf("bad %s: %w", date.flag, err)
+		}
+		criteria = append(criteria, date.key, imapDate(*t))
+	}
+	return criteria, nil
+}
+
+// password returns the password to log in with, from the flags or
+// prompted for
+func (m *ImapCmd) password() string {
+	if m.Password != "" {
+		return m.Password
+	}
+	in := os.Stdin
+	if m.PasswordFile != "" && m.PasswordFile != "-" {
+		f, err := os.Open(m.PasswordFile)
+		if err != nil {
+			fatal("Failed to read password: %s", err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		in = f
+	} else if m.PasswordFile == "" {
+		_, _ = fmt.Fprintf(os.Stderr, "IMAP password for %s: ", m.User)
+	}
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && line == "" {
+		fatal("Failed to read password: %s", err)
+	}
+	return strings.TrimRight(line, "\r\n")
+}
+
+// fetch logs in, finds the newest message matching the criteria, or the
+// one with --uid, and returns its UID and raw content
+func (m *ImapCmd) fetch(ctx context.Context, password string, criteria []any) (uint32, []byte, error) {
+	address := m.Address
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		port := "993"
+		if m.Starttls {
+			port = "143"
+		}
+		address = net.JoinHostPort(strings.Trim(address, "[]"), port)
+	}
+	host, _, _ := net.SplitHostPort(address)
+	tlsConfig := &tls.Config{ServerName: host, InsecureSkipVerify: m.Insecure, MinVersion: tls.VersionTLS12}
+
+	c, err := dialIMAP(ctx, address, m.Starttls, tlsConfig)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Login(m.User, password); err != nil {
+		return 0, nil, err
+	}
+	if err := c.Examine(m.Folder); err != nil {
+		return 0, nil, err
+	}
+	uid := m.Uid
+	if uid == 0 {
+		uids, err := c.Search(criteria...)
+		if err != nil {
+			return 0, nil, err
+		}
+		if len(uids) == 0 {
+			return 0, nil, fmt.Errorf("no messages in %s match the search", m.Folder)
+		}
+		// UIDs increase as messages are added, so the largest is the
+		// most recent arrival
+		for _, u := range uids {
+			uid = max(uid, u)
+		}
+	}
+	data, err := c.Fetch(uid)
+	if err != nil {
+		return 0, nil, err
+	}
+	return uid, data, nil
+}
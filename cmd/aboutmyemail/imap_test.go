@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+// imapStandIn is a fake IMAP server holding one folder of messages
+type imapStandIn struct {
+	Folder   string
+	Messages map[uint32]string
+	StartTLS bool
+
+	tlsConfig *tls.Config
+	mtx       sync.Mutex
+	commands  []string
+}
+
+// startIMAP runs an imapStandIn on a local port until the test ends
+func startIMAP(t *testing.T, s *imapStandIn) string {
+	t.Helper()
+	var err error
+	s.tlsConfig, err = selfSignedTLS("imap.test")
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	var wg sync.WaitGroup
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if !s.StartTLS {
+				conn = tls.Server(conn, s.tlsConfig)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				s.handle(conn)
+			}()
+		}
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		wg.Wait()
+	})
+	return listener.Addr().String()
+}
+
+// Commands returns the commands received, with literals inlined
+func (s *imapStandIn) Commands() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *imapStandIn) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	r := bufio.NewReader(conn)
+	_, _ = fmt.Fprintf(conn, "* OK IMAP4rev1 stand-in ready\r\n")
+	for {
+		tokens, err := readIMAPCommand(r, conn)
+		if err != nil || len(tokens) < 2 {
+			return
+		}
+		s.mtx.Lock()
+		s.commands = append(s.commands, strings.Join(tokens[1:], " "))
+		s.mtx.Unlock()
+		tag, verb, args := tokens[0], strings.ToUpper(tokens[1]), tokens[2:]
+		if verb == "UID" && len(args) > 0 {
+			verb, args = "UID "+strings.ToUpper(args[0]), args[1:]
+		}
+		switch verb {
+		case "STARTTLS":
+			_, _ = fmt.Fprintf(conn, "%s OK begin TLS\r\n", tag)
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn, r = tlsConn, bufio.NewReader(tlsConn)
+		case "LOGIN":
+			if len(args) != 2 || args[1] != "sécret \"pw\"" {
+				_, _ = fmt.Fprintf(conn, "%s NO [AUTHENTICATIONFAILED] bad password\r\n", tag)
+				continue
+			}
+			_, _ = fmt.Fprintf(conn, "%s OK logged in\r\n", tag)
+		case "EXAMINE":
+			if len(args) != 1 || args[0] != s.Folder {
+				_, _ = fmt.Fprintf(conn, "%s NO no such folder\r\n", tag)
+				continue
+			}
+			_, _ = fmt.Fprintf(conn, "* %d EXISTS\r\n%s OK [READ-ONLY] examined\r\n", len(s.Messages), tag)
+		case "UID SEARCH":
+			var uids []string
+			for uid, msg := range s.Messages {
+				if searchMatches(msg, args) {
+					uids = append(uids, strconv.Itoa(int(uid)))
+				}
+			}
+			sort.Strings(uids)
+			_, _ = fmt.Fprintf(conn, "* SEARCH %s\r\n%s OK done\r\n", strings.Join(uids, " "), tag)
+		case "UID FETCH":
+			uid, _ := strconv.Atoi(args[0])
+			if msg, ok := s.Messages[uint32(uid)]; ok {
+				_, _ = fmt.Fprintf(conn, "* 1 FETCH (UID %d BODY[] {%d}\r\n%s)\r\n", uid, len(msg), msg)
+			}
+			_, _ = fmt.Fprintf(conn, "%s OK done\r\n", tag)
+		case "LOGOUT":
+			_, _ = fmt.Fprintf(conn, "* BYE\r\n%s OK bye\r\n", tag)
+			return
+		default:
+			_, _ = fmt.Fprintf(conn, "%s BAD unknown command\r\n", tag)
+		}
+	}
+}
+
+// searchMatches applies the SUBJECT and FROM criteria, ignoring the rest
+func searchMatches(msg string, args []string) bool {
+	header, _, _ := strings.Cut(msg, "\r\n\r\n")
+	for i := 0; i+1 < len(args); i++ {
+		var field string
+		switch strings.ToUpper(args[i]) {
+		case "SUBJECT":
+			field = "\r\nSubject: "
+		case "FROM":
+			field = "\r\nFrom: "
+		default:
+			continue
+		}
+		_, value, _ := strings.Cut("\r\n"+header, field)
+		value, _, _ = strings.Cut(value, "\r\n")
+		if !strings.Contains(strings.ToLower(value), strings.ToLower(args[i+1])) {
+			return false
+		}
+		i++
+	}
+	return true
+}
+
+// readIMAPCommand reads a command line and splits it into atoms, quoted
+// strings and literals
+func readIMAPCommand(r *bufio.Reader, w io.Writer) ([]string, error) {
+	var tokens []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		// A line ending in a literal continues after it
+		continued := false
+		for line != "" {
+			switch {
+			case line[0] == ' ':
+				line = line[1:]
+			case line[0] == '"':
+				var value strings.Builder
+				i := 1
+				for ; i < len(line) && line[i] != '"'; i++ {
+					if line[i] == '\\' {
+						i++
+					}
+					value.WriteByte(line[i])
+				}
+				tokens = append(tokens, value.String())
+				line = line[min(i+1, len(line)):]
+			case line[0] == '{' && strings.HasSuffix(line, "}"):
+				size, err := strconv.Atoi(line[1 : len(line)-1])
+				if err != nil {
+					return nil, err
+				}
+				_, _ = fmt.Fprintf(w, "+ go ahead\r\n")
+				literal := make([]byte, size)
+				if _, err := io.ReadFull(r, literal); err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, string(literal))
+				line = ""
+				continued = true
+			default:
+				atom, rest, _ := strings.Cut(line, " ")
+				tokens = append(tokens, atom)
+				line = rest
+			}
+		}
+		if !continued {
+			return tokens, nil
+		}
+	}
+}
+
+func TestImapCmd_Fetch(t *testing.T) {
+	standIn := &imapStandIn{Folder: "Entw&APw-rfe", Messages: map[uint32]string{
+		3: "From: a@example.com\r\nSubject: Spring sale\r\n\r\nold\r\n",
+		7: "From: b@example.com\r\nSubject: Spring sale again\r\n\r\nnewest match\r\n",
+		9: "From: a@example.com\r\nSubject: Autumn\r\n\r\nnewest\r\n",
+	}}
+	addr := startIMAP(t, standIn)
+	cmd := &ImapCmd{Address: addr, User: "seed@example.org", Folder: "Entwürfe", Subject: "spring", Insecure: true}
+	criteria, err := cmd.criteria()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	uid, data, err := cmd.fetch(ctx, "sécret \"pw\"", criteria)
+	if err != nil {
+		t.Fatalf("fetch failed: %v", err)
+	}
+	if uid != 7 || string(data) != standIn.Messages[7] {
+		t.Errorf("want newest matching message 7, got %d %q", uid, data)
+	}
+	want := []string{
+		"LOGIN seed@example.org sécret \"pw\"",
+		"EXAMINE Entw&APw-rfe",
+		"UID SEARCH SUBJECT spring",
+		"UID FETCH 7 (BODY.PEEK[])",
+		"LOGOUT",
+	}
+	if got := standIn.Commands(); !reflect.DeepEqual(got, want) {
+		t.Errorf("want commands %q, got %q", want, got)
+	}
+
+	cmd.Subject = "nothing like this"
+	criteria, _ = cmd.criteria()
+	if _, _, err := cmd.fetch(ctx, "sécret \"pw\"", criteria); err == nil || !strings.Contains(err.Error(), "no messages") {
+		t.Errorf("want no match error, got %v", err)
+	}
+	if _, _, err := cmd.fetch(ctx, "wrong", nil); err == nil || !strings.Contains(err.Error(), "bad password") {
+		t.Errorf("want login error, got %v", err)
+	}
+	// Without --insecure the self-signed certificate is rejected
+	cmd.Insecure = false
+	if _, _, err := cmd.fetch(ctx, "sécret \"pw\"", nil); err == nil {
+		t.Error("want certificate error")
+	}
+}
+
+func TestImapCmd_StartTLS(t *testing.T) {
+	standIn := &imapStandIn{Folder: "INBOX", StartTLS: true, Messages: map[uint32]string{
+		1: "Subject: one\r\n\r\nbody\r\n",
+	}}
+	addr := startIMAP(t, standIn)
+	cmd := &ImapCmd{Address: addr, User: "u", Folder: "INBOX", Uid: 1, Starttls: true, Insecure: true}
+	uid, data, err := cmd.fetch(context.Background(), "sécret \"pw\"", nil)
+	if err != nil || uid != 1 || string(data) != standIn.Messages[1] {
+		t.Fatalf("want message 1, got %d %q, %v", uid, data, err)
+	}
+	if got := standIn.Commands(); got[0] != "STARTTLS" || got[3] != "UID FETCH 1 (BODY.PEEK[])" {
+		t.Errorf("want STARTTLS and no search with --uid, got %q", got)
+	}
+}
+
+// The fetched message is submitted with the IP from its Received header
+func TestImapCmd_Run(t *testing.T) {
+	message := "Received: from mail.sender.example (out.sender.example [203.0.113.5])\r\n" +
+		"\tby mx.example.org (Postfix) with ESMTPS id 1; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+		"From: sender@sender.example\r\nTo: seed@example.org\r\nSubject: test\r\n\r\nbody\r\n"
+	standIn := &imapStandIn{Folder: "INBOX", Messages: map[uint32]string{4: message}}
+	addr := startIMAP(t, standIn)
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+
+	cmd := &ImapCmd{Address: addr, User: "u", Password: "sécret \"pw\"", Folder: "INBOX", Insecure: true}
+	cmd.Received = true
+	if err := cmd.Run(&Globals{Server: server.Endpoint, Quiet: true}); err != nil {
+		t.Fatal(err)
+	}
+	subs := server.Submissions()
+	if len(subs) != 1 {
+		t.Fatalf("want one submission, got %+v", subs)
+	}
+	if sub := subs[0]; sub.Ip != "203.0.113.5" || sub.Helo != "mail.sender.example" || sub.From != "sender@sender.example" || sub.To != "seed@example.org" {
+		t.Errorf("want envelope from headers, got %+v", sub)
+	}
+}
+
+func TestEncodeMailboxName(t *testing.T) {
+	for name, want := range map[string]string{
+		"INBOX":              "INBOX",
+		"Entwürfe":           "Entw&APw-rfe",
+		"R&D":                "R&-D",
+		"~peter/mail/台北/日本語": "~peter/mail/&U,BTFw-/&ZeVnLIqe-",
+	} {
+		if got := encodeMailboxName(name); got != want {
+			t.Errorf("encodeMailboxName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// imapMaxLiteral is the largest literal we'll read from an IMAP server
+const imapMaxLiteral = 64 << 20
+
+// imapClient is a minimal IMAP4rev1 client, just enough to find a message
+// and fetch it
+type imapClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+	tag  int
+	// preauth is set if the server greeted us as already authenticated
+	preauth bool
+}
+
+// imapLine is one response line from the server. Literals are read into
+// Literals and left in Text as their {size} marker.
+type imapLine struct {
+	Text     string
+	Literals [][]byte
+}
+
+// imapLiteral is a command argument sent as a literal, for values that
+// can't be sent as a quoted string
+type imapLiteral string
+
+// dialIMAP connects to an IMAP server and reads its greeting. Without
+// startTLS the connection uses TLS from the start, as on port 993.
+func dialIMAP(ctx context.Context, address string, startTLS bool, tlsConfig *tls.Config) (*imapClient, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if !startTLS {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	c := &imapClient{}
+	c.setConn(conn)
+	greeting, err := c.readLine()
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to read greeting: %w", err)
+	}
+	status, text, _ := strings.Cut(strings.TrimPrefix(greeting.Text, "* "), " ")
+	switch strings.ToUpper(status) {
+	case "OK":
+	case "PREAUTH":
+		c.preauth = true
+	default:
+		_ = conn.Close()
+		return nil, fmt.Errorf("server refused connection: %s", text)
+	}
+	if startTLS {
+		if _, err := c.command("STARTTLS"); err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		c.setConn(tlsConn)
+	}
+	return c, nil
+}
+
+func (c *imapClient) setConn(conn net.Conn) {
+	c.conn = conn
+	c.r = bufio.NewReader(conn)
+	c.w = bufio.NewWriter(conn)
+}
+
+// Close logs out and closes the connection
+func (c *imapClient) Close() error {
+	_, _ = c.command("LOGOUT")
+	return c.conn.Close()
+}
+
+// Login authenticates with a username and password, unless the server
+// greeted us as already authenticated
+func (c *imapClient) Login(user, password string) error {
+	if c.preauth {
+		return nil
+	}
+	_, err := c.command("LOGIN", imapString(user), imapString(password))
+	if err != nil {
+		return fmt.Errorf("login failed: %w", err)
+	}
+	return nil
+}
+
+// Examine opens a folder read-only
+func (c *imapClient) Examine(folder string) error {
+	_, err := c.command("EXAMINE", imapString(encodeMailboxName(folder)))
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", folder, err)
+	}
+	return nil
+}
+
+// Search returns the UIDs of the messages matching criteria, which are
+// sent as they are and so must already be quoted
+func (c *imapClient) Search(criteria ...any) ([]uint32, error) {
+	args := []any{"UID", "SEARCH"}
+	for _, arg := range criteria {
+		if _, ok := arg.(imapLiteral); ok {
+			args = append(args, "CHARSET", "UTF-8")
+			break
+		}
+	}
+	if len(criteria) == 0 {
+		criteria = []any{"ALL"}
+	}
+	lines, err := c.command(append(args, criteria...)...)
+	if err != nil {
+		return nil, fmt.Errorf("search failed: %w", err)
+	}
+	var uids []uint32
+	for _, line := range lines {
+		fields := strings.Fields(line.Text)
+		if len(fields) < 2 || fields[0] != "*" || !strings.EqualFold(fields[1], "SEARCH") {
+			continue
+		}
+		for _, f := range fields[2:] {
+			uid, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("bad SEARCH response %q", line.Text)
+			}
+			uids = append(uids, uint32(uid))
+		}
+	}
+	return uids, nil
+}
+
+// Fetch returns the raw content of the message with a UID, without
+// marking it as read
+func (c *imapClient) Fetch(uid uint32) ([]byte, error) {
+	lines, err := c.command("UID", "FETCH", strconv.FormatUint(uint64(uid), 10), "(BODY.PEEK[])")
+	if err != nil {
+		return nil, fmt.Errorf("fetch failed: %w", err)
+	}
+	for _, line := range lines {
+		fields := strings.Fields(line.Text)
+		if len(fields) < 3 || fields[0] != "*" || !strings.EqualFold(fields[2], "FETCH") {
+			continue
+		}
+		if i := strings.Index(strings.ToUpper(line.Text), "BODY[] {"); i >= 0 && len(line.Literals) > 0 {
+			// The body is the literal whose marker follows BODY[]
+			return line.Literals[strings.Count(line.Text[:i], "}\r\n")], nil
+		}
+	}
+	return nil, fmt.Errorf("no message with UID %d", uid)
+}
+
+// command sends a tagged command and reads the responses up to its
+// completion, returning the untagged ones. Strings are sent as they are,
+// separated by spaces, imapLiterals as literals.
+func (c *imapClient) command(args ...any) ([]imapLine, error) {
+	c.tag++
+	tag := fmt.Sprintf("a%d", c.tag)
+	if _, err := c.w.WriteString(tag); err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		_ = c.w.WriteByte(' ')
+		switch v := arg.(type) {
+		case imapLiteral:
+			_, _ = fmt.Fprintf(c.w, "{%d}\r\n", len(v))
+			if err := c.w.Flush(); err != nil {
+				return nil, err
+			}
+			line, err := c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			if !strings.HasPrefix(line.Text, "+") {
+				return nil, fmt.Errorf("server refused literal: %s", line.Text)
+			}
+			_, _ = c.w.WriteString(string(v))
+		case string:
+			_, _ = c.w.WriteString(v)
+		default:
+			panic(fmt.Sprintf("unexpected IMAP argument %T", arg))
+		}
+	}
+	_, _ = c.w.WriteString("\r\n")
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	var untagged []imapLine
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		rest, ok := strings.CutPrefix(line.Text, tag+" ")
+		if !ok {
+			untagged = append(untagged, line)
+			continue
+		}
+		status, text, _ := strings.Cut(rest, " ")
+		if !strings.EqualFold(status, "OK") {
+			return untagged, errors.New(text)
+		}
+		return untagged, nil
+	}
+}
+
+// readLine reads a response line, including any literals in it
+func (c *imapClient) readLine() (imapLine, error) {
+	var line imapLine
+	var text strings.Builder
+	for {
+		part, err := c.r.ReadString('\n')
+		if err != nil {
+			return line, err
+		}
+		part = strings.TrimRight(part, "\r\n")
+		size, ok := literalSize(part)
+		if !ok {
+			text.WriteString(part)
+			line.Text = text.String()
+			return line, nil
+		}
+		if size > imapMaxLiteral {
+			return line, fmt.Errorf("literal of %d bytes is too large", size)
+		}
+		literal := make([]byte, size)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return line, err
+		}
+		text.WriteString(part)
+		text.WriteString("\r\n")
+		line.Literals = append(line.Literals, literal)
+	}
+}
+
+// literalSize returns the size of the literal announced at the end of a
+// line, as {123}
+func literalSize(line string) (int, bool) {
+	if !strings.HasSuffix(line, "}") {
+		return 0, false
+	}
+	open := strings.LastIndexByte(line, '{')
+	if open < 0 {
+		return 0, false
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(line[open+1:len(line)-1], "+"))
+	if err != nil || size < 0 {
+		return 0, false
+	}
+	return size, true
+}
+
+// imapString returns s as a quoted string, or as a literal if it has
+// characters a quoted string can't hold
+func imapString(s string) any {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] > 0x7e {
+			return imapLiteral(s)
+		}
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// imapDate formats a date for SEARCH
+func imapDate(t time.Time) string {
+	return t.Format("2-Jan-2006")
+}
+
+// mailboxEncoding is base64 as modified for IMAP mailbox names
+var mailboxEncoding = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+,").WithPadding(base64.NoPadding)
+
+// encodeMailboxName encodes a folder name in the modified UTF-7 that IMAP
+// uses for mailbox names (RFC 3501 section 5.1.3)
+func encodeMailboxName(name string) string {
+	var out bytes.Buffer
+	var pending []rune
+	flush := func() {
+		if len(pending) == 0 {
+			return
+		}
+		var raw []byte
+		for _, u := range utf16.Encode(pending) {
+			raw = append(raw, byte(u>>8), byte(u))
+		}
+		out.WriteByte('&')
+		out.WriteString(mailboxEncoding.EncodeToString(raw))
+		out.WriteByte('-')
+		pending = nil
+	}
+	for _, r := range name {
+		switch {
+		case r == '&':
+			flush()
+			out.WriteString("&-")
+		case r >= 0x20 && r <= 0x7e:
+			flush()
+			out.WriteRune(r)
+		default:
+			pending = append(pending, r)
+		}
+	}
+	flush()
+	return out.String()
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/wttw/aboutmyemail"
+	"html"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf16"
+)
+
+// importMessage extracts the RFC 5322 message from a file exported from a
+// webmail "show original" view, or copied from one. It undoes the damage
+// that's usually done on the way: UTF-16 text, HTML wrappers and summary
+// tables before the headers. Byte order marks and line endings are left for
+// aboutmyemail.Normalize. It returns the message and a description of each
+// repair made, which is empty if the message was fine as it was.
+func importMessage(data []byte) ([]byte, []string) {
+	var repairs []string
+	switch {
+	case bytes.HasPrefix(data, []byte{0xff, 0xfe}):
+		data = decodeUTF16(data[2:], false)
+		repairs = append(repairs, "converted from UTF-16LE")
+	case bytes.HasPrefix(data, []byte{0xfe, 0xff}):
+		data = decodeUTF16(data[2:], true)
+		repairs = append(repairs, "converted from UTF-16BE")
+	}
+
+	if isHTML(data) {
+		var how string
+		data, how = extractFromHTML(data)
+		repairs = append(repairs, how)
+	}
+
+	if start, skipped := findHeaders(data); start > 0 {
+		data = data[start:]
+		if skipped > 0 {
+			repairs = append(repairs, fmt.Sprintf("skipped %d lines before the message headers", skipped))
+		} else {
+			repairs = append(repairs, "removed blank lines before the message headers")
+		}
+	}
+	return data, repairs
+}
+
+// importEmail prepares a message read from a file for submission. Outlook
+// .msg files are converted to MIME, webmail exports are repaired with
+// importMessage, winmail.dat attachments are unpacked and the result is
+// normalized to CRLF as it would be sent over SMTP. It warns about
+// each change made, anything that couldn't be converted, and headers that
+// still can't be parsed.
+func importEmail(name string, data []byte) ([]byte, error) {
+	prefix := ""
+	if name != "" {
+		prefix = name + ": "
+	}
+	if isOLE(data) {
+		converted, warnings, err := convertMsg(data)
+		if err != nil {
+			return nil, fmt.Errorf("can't convert Outlook .msg file: %w", err)
+		}
+		printWarning("%sConverted Outlook .msg file to MIME", prefix)
+		for _, w := range warnings {
+			printWarning("%s%s", prefix, w)
+		}
+		data = converted
+	}
+	data, repairs := importMessage(data)
+	for _, repair := range repairs {
+		printWarning("%sRepaired message: %s", prefix, repair)
+	}
+	data, notes := unpackTNEF(data)
+	for _, note := range notes {
+		printWarning("%s%s", prefix, note)
+	}
+	data, changes := aboutmyemail.Normalize(data)
+	for _, change := range changes {
+		printWarning("%sNormalized message: %s", prefix, change)
+	}
+	if _, err := mail.ReadMessage(bytes.NewReader(data)); err != nil {
+		printWarning("%sCan't parse message headers, so the envelope can't be taken from them: %s", prefix, err)
+	}
+	return data, nil
+}
+
+func decodeUTF16(data []byte, bigEndian bool) []byte {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		if bigEndian {
+			units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+		} else {
+			units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+		}
+	}
+	var buf bytes.Buffer
+	for _, r := range utf16.Decode(units) {
+		buf.WriteRune(r)
+	}
+	return buf.Bytes()
+}
+
+// isHTML reports whether data is an HTML page rather than a message. A
+// message starts with a header field, which can't start with "<".
+func isHTML(data []byte) bool {
+	start := bytes.TrimLeft(data, " \t\r\n\ufeff")
+	if !bytes.HasPrefix(start, []byte("<")) {
+		return false
+	}
+	head := strings.ToLower(string(start[:min(len(start), 4096)]))
+	return strings.HasPrefix(head, "<!doctype") || strings.Contains(head, "<html") ||
+		strings.Contains(head, "<pre") || strings.Contains(head, "<body") || strings.Contains(head, "<div")
+}
+
+// maxPreamble is how far into the file to look for the message headers
+const maxPreamble = 64 * 1024
+
+var (
+	preRe       = regexp.MustCompile(`(?is)<(pre|textarea)\b[^>]*>(.*?)</(?:pre|textarea)\s*>`)
+	blockTagRe  = regexp.MustCompile(`(?i)<br\s*/?>|</(?:p|div|tr|li|h[1-6])\s*>`)
+	cellTagRe   = regexp.MustCompile(`(?i)</t[dh]\s*>`)
+	tagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	nbspRe      = regexp.MustCompile(`(?i)&(?:nbsp|#0*160|#x0*a0);`)
+	hiddenRe    = regexp.MustCompile(`(?is)<(script|style|head)\b.*?</(?:script|style|head)\s*>`)
+	headerRe    = regexp.MustCompile(`^[!-9;-~]+:`)
+	headerNames = map[string]bool{
+		"received": true, "return-path": true, "delivered-to": true, "date": true, "message-id": true,
+		"mime-version": true, "dkim-signature": true, "arc-seal": true, "authentication-results": true,
+		"x-received": true, "received-spf": true,
+	}
+)
+
+// extractFromHTML takes the message text out of an HTML page. Gmail,
+// Outlook and Yahoo all show the raw message in a <pre> element; failing
+// that the page is converted to plain text.
+func extractFromHTML(data []byte) ([]byte, string) {
+	var best []byte
+	var element string
+	for _, m := range preRe.FindAllSubmatch(data, -1) {
+		if len(m[2]) > len(best) {
+			best, element = m[2], strings.ToLower(string(m[1]))
+		}
+	}
+	how := fmt.Sprintf("extracted message from HTML <%s> element", element)
+	if best == nil {
+		best = hiddenRe.ReplaceAll(data, nil)
+		best = blockTagRe.ReplaceAll(best, []byte("\n"))
+		best = cellTagRe.ReplaceAll(best, []byte("\t"))
+		how = "converted HTML page to text"
+	}
+	best = tagRe.ReplaceAll(best, nil)
+	// Escaped non-breaking spaces come from rendering, not from the message,
+	// which may have real ones in an 8-bit body
+	best = nbspRe.ReplaceAll(best, []byte(" "))
+	return []byte(html.UnescapeString(string(best))), how
+}
+
+// findHeaders finds where the message headers start, skipping any text
+// before them, such as Gmail's summary of the authentication results. The
+// headers are the first block of header fields that includes one of the
+// fields every delivered message has, which a summary table doesn't. It
+// returns the offset of the headers and the number of non-blank lines
+// skipped.
+func findHeaders(data []byte) (int, int) {
+	first, _, _ := bytes.Cut(bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf}), []byte("\n"))
+	if headerRe.Match(first) {
+		// Already starts with a header
+		return 0, 0
+	}
+	offset, skipped := 0, 0
+	for offset < min(len(data), maxPreamble) {
+		line, _, _ := bytes.Cut(data[offset:], []byte("\n"))
+		trimmed := strings.TrimRight(string(line), "\r")
+		if headerRe.MatchString(trimmed) && isHeaderBlock(data[offset:]) {
+			return offset, skipped
+		}
+		if strings.TrimSpace(trimmed) != "" {
+			skipped++
+		}
+		offset += len(line) + 1
+	}
+	// No recognizable headers, leave it alone
+	return 0, 0
+}
+
+// isHeaderBlock reports whether data starts with a block of header fields
+// including at least one that every delivered message has
+func isHeaderBlock(data []byte) bool {
+	for len(data) > 0 {
+		var raw []byte
+		raw, data, _ = bytes.Cut(data, []byte("\n"))
+		line := strings.TrimRight(string(raw), "\r")
+		switch {
+		case line == "":
+			return false
+		case line[0] == ' ' || line[0] == '\t':
+			continue
+		case !headerRe.MatchString(line):
+			return false
+		}
+		name, _, _ := strings.Cut(line, ":")
+		if headerNames[strings.ToLower(name)] {
+			return true
+		}
+	}
+	return false
+}
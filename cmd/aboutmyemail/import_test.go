@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const importedMessage = "Received: from mail.example.com by mx.example.org; Mon, 1 Jan 2024 00:00:00 +0000\r\n" +
+	"From: Sender <sender@example.com>\r\n" +
+	"To: user@example.org\r\n" +
+	"Subject: a < b & c\r\n" +
+	"\r\n" +
+	"Hello\r\n"
+
+func TestImportMessage(t *testing.T) {
+	lf := strings.ReplaceAll(importedMessage, "\r\n", "\n")
+	escaped := strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;").Replace(importedMessage)
+	tests := []struct {
+		name    string
+		in      string
+		want    string
+		repairs int
+	}{
+		{"unchanged", importedMessage, importedMessage, 0},
+		{"unix line endings", lf, lf, 0},
+		{"bom", "\xef\xbb\xbf" + importedMessage, "\xef\xbb\xbf" + importedMessage, 0},
+		{"utf-16le", "\xff\xfe" + utf16le(importedMessage), importedMessage, 1},
+		{
+			"gmail html",
+			"<!DOCTYPE html><html><head><title>Original</title><style>pre{}</style></head><body>" +
+				"<table><tr><td>Message ID</td><td>&lt;x@example.com&gt;</td></tr><tr><td>SPF:</td><td>PASS</td></tr></table>" +
+				"<pre class=\"raw_message_text\" id=\"raw_message_text\">" + escaped + "</pre></body></html>",
+			importedMessage, 1,
+		},
+		{
+			"gmail copied text",
+			"Original message\n\nMessage ID\t<x@example.com>\nCreated at:\tMon, Jan 1, 2024\nFrom:\tSender <sender@example.com>\n" +
+				"To:\tuser@example.org\nSubject:\ta < b & c\nSPF:\tPASS with IP 192.0.2.1\nDKIM:\t'PASS'\n\n" +
+				"Download Original\tCopy to clipboard\n" + lf,
+			lf, 1,
+		},
+		{
+			"rendered nbsp",
+			"<html><body><pre>" + strings.Replace(escaped, "Hello", "&nbsp;Hello&#160;caf\u00e9\u00a0", 1) + "</pre></body></html>",
+			strings.Replace(importedMessage, "Hello", " Hello caf\u00e9\u00a0", 1), 1,
+		},
+		{"leading blank lines", "\r\n\r\n" + importedMessage, importedMessage, 1},
+	}
+	for _, tt := range tests {
+		got, repairs := importMessage([]byte(tt.in))
+		if string(got) != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+		if len(repairs) != tt.repairs {
+			t.Errorf("%s: want %d repairs, got %q", tt.name, tt.repairs, repairs)
+		}
+	}
+}
+
+func utf16le(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		b.WriteByte(byte(r))
+		b.WriteByte(byte(r >> 8))
+	}
+	return b.String()
+}
+
+// importEmail normalizes messages to CRLF, as they'll be sent
+func TestImportEmail_Normalizes(t *testing.T) {
+	for name, in := range map[string]string{
+		"mbox":               "From a@example.com Mon Jan  1 00:00:00 2024\n" + strings.ReplaceAll(importedMessage, "\r\n", "\n"),
+		"bom":                "\xef\xbb\xbf" + importedMessage,
+		"mixed line endings": strings.Replace(importedMessage, "\r\n", "\n", 2),
+		"outlook doubled cr": strings.ReplaceAll(importedMessage, "\r\n", "\r\r\n"),
+		"mac line endings":   strings.ReplaceAll(importedMessage, "\r\n", "\r"),
+		"bom and html":       "\xef\xbb\xbf<html><body><pre>" + strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;").Replace(importedMessage) + "</pre></body></html>",
+	} {
+		got, err := importEmail("", []byte(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != importedMessage {
+			t.Errorf("%s: got %q, want %q", name, got, importedMessage)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"net/mail"
+	"os"
+	"strings"
+	"time"
+)
+
+type LintCmd struct {
+	Email        string `arg:"" help:"Message to check" type:"existingfile"`
+	Json         bool   `help:"Print the findings as JSON"`
+	Fix          string `help:"Write a corrected copy of the message to this file" type:"path" placeholder:"file"`
+	Smtputf8     bool   `name:"smtputf8" help:"Check as if the message will be sent with SMTPUTF8, which allows UTF-8 header fields"`
+	EightBitMime bool   `name:"8bitmime" help:"Check as if the message will be sent with 8BITMIME, which allows 8-bit body content"`
+}
+
+// lintReport is what lint prints with --json
+type lintReport struct {
+	File     string        `json:"file"`
+	Findings []lintFinding `json:"findings"`
+	Errors   int           `json:"errors"`
+	Warnings int           `json:"warnings"`
+	// Fixed is where the corrected copy was written, and Remaining what's
+	// still wrong with it
+	Fixed     string        `json:"fixed,omitempty"`
+	Remaining []lintFinding `json:"remaining,omitempty"`
+}
+
+func (c *LintCmd) Run(globals *Globals) error {
+	data, err := os.ReadFile(c.Email)
+	if err != nil {
+		fatal("Failed to read message: %s", err)
+	}
+	opts := lintOptions{Smtputf8: c.Smtputf8, EightBitMIME: c.EightBitMime}
+	report := lintReport{File: c.Email, Findings: lintMessage(data, opts)}
+	if report.Findings == nil {
+		report.Findings = []lintFinding{}
+	}
+	for _, f := range report.Findings {
+		switch f.Severity {
+		case lintError:
+			report.Errors++
+		case lintWarning:
+			report.Warnings++
+		}
+	}
+	if c.Fix != "" {
+		fixed := fixMessage(data)
+		if err := os.WriteFile(c.Fix, fixed, 0o644); err != nil {
+			fatal("Failed to write corrected copy: %s", err)
+		}
+		report.Fixed = c.Fix
+		report.Remaining = lintMessage(fixed, opts)
+	}
+
+	if c.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(report)
+	} else {
+		c.print(globals, report)
+	}
+	if report.Errors > 0 {
+		fatal("%d errors in %s", report.Errors, c.Email)
+	}
+	return nil
+}
+
+// print writes the findings, one per line, as file:offset: severity: message
+func (c *LintCmd) print(globals *Globals, report lintReport) {
+	colors := map[string]*color.Color{
+		lintError:   color.New(color.FgHiRed),
+		lintWarning: color.New(color.FgHiYellow),
+		lintInfo:    color.New(color.FgCyan),
+	}
+	for _, f := range report.Findings {
+		if f.Severity == lintInfo && globals.Quiet {
+			continue
+		}
+		code := f.Code
+		if f.Fixable {
+			code += ", fixable"
+		}
+		_, _ = fmt.Fprintf(color.Output, "%s:%d: %s: %s (%s)\n", report.File, f.Offset, colors[f.Severity].Sprint(f.Severity), f.Message, code)
+	}
+	if globals.Quiet {
+		return
+	}
+	if len(report.Findings) == 0 {
+		_, _ = fmt.Fprintf(color.Output, "%s\n", color.New(color.FgHiGreen).Sprint("No problems found"))
+	}
+	if report.Fixed != "" {
+		remaining := 0
+		for _, f := range report.Remaining {
+			if f.Severity != lintInfo {
+				remaining++
+			}
+		}
+		_, _ = fmt.Fprintf(color.Output, "Wrote corrected copy to %s, %d problems remain\n", report.Fixed, remaining)
+	}
+}
+
+// fixMessage returns a copy of a message with the fixable problems
+// corrected: line endings made CRLF, over-long header lines folded and
+// missing Date, Message-ID and MIME-Version fields added
+func fixMessage(data []byte) []byte {
+	data, _ = aboutmyemail.Normalize(data)
+	l := &linter{data: data}
+	fields, body := l.headers(0, len(data))
+	if len(fields) == 0 {
+		return data
+	}
+	var out bytes.Buffer
+	for _, f := range fields {
+		raw := data[f.Offset:f.End]
+		long := false
+		for _, line := range lintLines(data, f.Offset, f.End) {
+			long = long || line.ContentEnd-line.Start > lintMaxLine
+		}
+		if long {
+			out.Write(foldField(f.Name, f.Value))
+		} else {
+			out.Write(raw)
+		}
+	}
+	headerEnd := fields[len(fields)-1].End
+	if len(fieldsNamed(fields, "Date")) == 0 {
+		out.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	}
+	if len(fieldsNamed(fields, "Message-ID")) == 0 {
+		out.WriteString("Message-ID: " + newMessageID(fields) + "\r\n")
+	}
+	if len(fieldsNamed(fields, "MIME-Version")) == 0 && needsMIMEVersion(fields, data[body:]) {
+		out.WriteString("MIME-Version: 1.0\r\n")
+	}
+	out.Write(data[headerEnd:])
+	return out.Bytes()
+}
+
+// foldField formats a header field, folding it at whitespace to keep lines
+// under 78 characters where it can
+func foldField(name string, value []byte) []byte {
+	// Each token after the first starts with the whitespace it can be
+	// folded at
+	var tokens []string
+	start := 0
+	for i := 1; i < len(value); i++ {
+		if (value[i] == ' ' || value[i] == '\t') && value[i-1] != ' ' && value[i-1] != '\t' {
+			tokens = append(tokens, string(value[start:i]))
+			start = i
+		}
+	}
+	tokens = append(tokens, string(value[start:]))
+	var out bytes.Buffer
+	line := name + ":"
+	for i, token := range tokens {
+		// A folded line mustn't be only whitespace
+		if i > 0 && len(line)+len(token) > 78 && strings.TrimSpace(token) != "" {
+			out.WriteString(line + "\r\n")
+			line = ""
+		}
+		line += token
+	}
+	out.WriteString(line + "\r\n")
+	return out.Bytes()
+}
+
+// newMessageID makes a Message-ID in the domain of the From address
+func newMessageID(fields []lintField) string {
+	domain := "localhost"
+	if hostname, err := os.Hostname(); err == nil && strings.Contains(hostname, ".") {
+		domain = hostname
+	}
+	if from := fieldsNamed(fields, "From"); len(from) > 0 {
+		if addr, err := mail.ParseAddress(string(from[0].Value)); err == nil {
+			if _, d, found := strings.Cut(addr.Address, "@"); found {
+				domain = d
+			}
+		}
+	}
+	id := make([]byte, 16)
+	_, _ = rand.Read(id)
+	return "<" + hex.EncodeToString(id) + "@" + domain + ">"
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const lintHeaders = "From: a@example.com\r\nTo: b@example.org\r\nDate: Mon, 2 Jan 2006 15:04:05 -0700\r\nMessage-ID: <1@example.com>\r\n"
+
+func TestLintMessage(t *testing.T) {
+	mime := lintHeaders + "MIME-Version: 1.0\r\n"
+	tests := []struct {
+		name    string
+		message string
+		opts    lintOptions
+		// codes are the codes of the error and warning findings, in order
+		codes []string
+	}{
+		{"clean", lintHeaders + "Subject: hi\r\n\r\nbody\r\n", lintOptions{}, nil},
+		{"missing", "To: b@example.org\r\n\r\nbody\r\n", lintOptions{}, []string{"missing-header", "missing-header", "missing-header"}},
+		{"duplicate", lintHeaders + "Date: Tue, 3 Jan 2006 15:04:05 -0700\r\n\r\nbody\r\n", lintOptions{}, []string{"duplicate-header"}},
+		{"long line", lintHeaders + "\r\n" + strings.Repeat("x", 999) + "\r\n", lintOptions{}, []string{"long-line"}},
+		{"bare cr", lintHeaders + "\r\nbare\rcr\r\n", lintOptions{}, []string{"bare-cr"}},
+		{"mixed endings", lintHeaders + "\nbody\r\n", lintOptions{}, []string{"bare-lf"}},
+		{"lf only", strings.ReplaceAll(lintHeaders+"\r\nbody\r\n", "\r\n", "\n"), lintOptions{}, nil},
+		{"utf-8 header", lintHeaders + "Subject: café\r\n\r\nbody\r\n", lintOptions{}, []string{"8bit-header"}},
+		{"utf-8 header smtputf8", lintHeaders + "Subject: café\r\n\r\nbody\r\n", lintOptions{Smtputf8: true}, nil},
+		{"latin-1 header", lintHeaders + "Subject: caf\xe9\r\n\r\nbody\r\n", lintOptions{Smtputf8: true}, []string{"8bit-header"}},
+		{"8bit without mime", lintHeaders + "\r\ncafé\r\n", lintOptions{}, []string{"missing-mime-version", "cte-mismatch"}},
+		{"8bit", mime + "Content-Transfer-Encoding: 8bit\r\n\r\ncafé\r\n", lintOptions{}, []string{"needs-8bitmime"}},
+		{"8bit with 8bitmime", mime + "Content-Transfer-Encoding: 8bit\r\n\r\ncafé\r\n", lintOptions{EightBitMIME: true}, nil},
+		{"encoded-words", lintHeaders + "Subject: =?utf-8?q?caf=C3=A9?= =?iso-8859-1?B?Y2Fm6Q==?=\r\n\r\nbody\r\n", lintOptions{}, nil},
+		{"bad encoded-word", lintHeaders + "Subject: =?utf-8?Q?caf=Z9?=\r\n\r\nbody\r\n", lintOptions{}, []string{"encoded-word"}},
+		{"unknown charset", lintHeaders + "Subject: =?x-nonesuch?Q?cafe?=\r\n\r\nbody\r\n", lintOptions{}, []string{"encoded-word"}},
+		{"quoted encoded-word", "From: \"=?utf-8?Q?Andr=C3=A9?=\" <a@example.com>\r\nDate: Mon, 2 Jan 2006 15:04:05 -0700\r\nMessage-ID: <1@example.com>\r\n\r\nbody\r\n", lintOptions{}, []string{"encoded-word"}},
+		{"mime version", lintHeaders + "MIME-Version: 1.0 (produced by test)\r\nContent-Type: text/plain\r\n\r\nbody\r\n", lintOptions{}, nil},
+		{"missing mime version", lintHeaders + "Content-Type: text/plain\r\n\r\nbody\r\n", lintOptions{}, []string{"missing-mime-version"}},
+		{"qp", mime + "Content-Transfer-Encoding: quoted-printable\r\n\r\ncaf=C3=A9 =\r\nsoft break =3d\r\n", lintOptions{}, nil},
+		{"bad qp", mime + "Content-Transfer-Encoding: quoted-printable\r\n\r\n100% =off\r\n", lintOptions{}, []string{"cte-mismatch"}},
+		{"raw qp", mime + "Content-Transfer-Encoding: quoted-printable\r\n\r\ncafé\r\n", lintOptions{EightBitMIME: true}, []string{"cte-mismatch"}},
+		{"base64", mime + "Content-Transfer-Encoding: base64\r\n\r\nY2Fmw6k=\r\n", lintOptions{}, nil},
+		{"bad base64", mime + "Content-Transfer-Encoding: base64\r\n\r\nY2Fmw6k\r\n", lintOptions{}, []string{"cte-mismatch"}},
+		{"unknown cte", mime + "Content-Transfer-Encoding: x-uuencode\r\n\r\nbody\r\n", lintOptions{}, []string{"cte-mismatch"}},
+		{"multipart", mime + "Content-Type: multipart/mixed; boundary=b\r\n\r\npreamble\r\n--b\r\n\r\none\r\n--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: base64\r\n\r\nY2Fmw6k=\r\n--b--\r\n", lintOptions{}, nil},
+		{"multipart part", mime + "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\ncafé\r\n--b--\r\n", lintOptions{}, []string{"cte-mismatch"}},
+		{"multipart encoded", mime + "Content-Type: multipart/mixed; boundary=b\r\nContent-Transfer-Encoding: base64\r\n\r\n--b\r\n\r\none\r\n--b--\r\n", lintOptions{}, []string{"cte-mismatch"}},
+		{"unclosed multipart", mime + "Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\n\r\none\r\n", lintOptions{}, []string{"multipart"}},
+		{"no boundary", mime + "Content-Type: multipart/mixed\r\n\r\nbody\r\n", lintOptions{}, []string{"multipart"}},
+		{"enclosed message", mime + "Content-Type: message/rfc822\r\n\r\nSubject: =?utf-8?Q?bad=Z?=\r\n\r\nbody\r\n", lintOptions{}, []string{"encoded-word"}},
+	}
+	for _, tt := range tests {
+		var codes []string
+		for _, f := range lintMessage([]byte(tt.message), tt.opts) {
+			if f.Severity != lintInfo {
+				codes = append(codes, f.Code)
+			}
+		}
+		if strings.Join(codes, " ") != strings.Join(tt.codes, " ") {
+			t.Errorf("%s: want %v, got %v", tt.name, tt.codes, codes)
+		}
+	}
+}
+
+func TestLintMessage_Offsets(t *testing.T) {
+	message := lintHeaders + "\r\nfine\r\nbare\rcr\r\n"
+	findings := lintMessage([]byte(message), lintOptions{})
+	if len(findings) != 1 || message[findings[0].Offset] != '\r' || findings[0].Offset != strings.Index(message, "bare")+4 {
+		t.Errorf("want bare CR at offset %d, got %+v", strings.Index(message, "bare")+4, findings)
+	}
+
+	// Repeated problems are summarized
+	message = lintHeaders + "\r\n" + strings.Repeat("x\ry\r\n", 15)
+	findings = lintMessage([]byte(message), lintOptions{})
+	last := findings[len(findings)-1]
+	if len(findings) != lintMaxPerCode+1 || last.Severity != lintInfo || !strings.Contains(last.Message, "5 more") {
+		t.Errorf("want %d findings and a summary, got %+v", lintMaxPerCode, findings)
+	}
+}
+
+func TestFixMessage(t *testing.T) {
+	long := "Subject:" + strings.Repeat(" word", 250) + "\n"
+	message := "From: Someone <someone@example.com>\nContent-Type: text/plain\n" + long + "\nbody\rwith\r\nmixed\nendings\n"
+	fixed := fixMessage([]byte(message))
+	for _, f := range lintMessage(fixed, lintOptions{}) {
+		t.Errorf("fixed message still has %s: %s", f.Code, f.Message)
+	}
+	for _, want := range []string{"\r\nDate: ", "\r\nMIME-Version: 1.0\r\n", "@example.com>\r\n", "\r\n\r\nbody\r\nwith\r\nmixed\r\nendings\r\n"} {
+		if !strings.Contains(string(fixed), want) {
+			t.Errorf("want %q in fixed message, got %q", want, fixed)
+		}
+	}
+	for _, line := range strings.Split(string(fixed), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("line of %d characters: %q", len(line), line)
+		}
+	}
+	if unfolded := strings.ReplaceAll(string(fixed), "\r\n ", " "); !strings.Contains(unfolded, strings.TrimSuffix(long, "\n")+"\r\n") {
+		t.Error("want Subject unchanged by folding")
+	}
+}
@@ -0,0 +1,541 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// Severities of lint findings
+const (
+	lintError   = "error"
+	lintWarning = "warning"
+	lintInfo    = "info"
+)
+
+// lintMaxLine is the longest line RFC 5322 allows, without its CRLF
+const lintMaxLine = 998
+
+// lintMaxPerCode is how many findings of one kind are reported individually
+const lintMaxPerCode = 10
+
+// lintFinding is one problem found in a message
+type lintFinding struct {
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	// Offset is the byte offset in the message the problem is at
+	Offset  int    `json:"offset"`
+	Message string `json:"message"`
+	// Fixable is set if --fix corrects it
+	Fixable bool `json:"fixable,omitempty"`
+}
+
+// lintOptions describes how the message will be sent
+type lintOptions struct {
+	// Smtputf8 is set if the message can use UTF-8 header fields
+	Smtputf8 bool
+	// EightBitMIME is set if the message can have 8-bit body content
+	EightBitMIME bool
+}
+
+// lintField is a header field found while linting
+type lintField struct {
+	Name string
+	// Value is the field body, unfolded
+	Value []byte
+	// Offset and End are where the field starts and ends in the message
+	Offset int
+	End    int
+}
+
+// linter collects the findings for a message
+type linter struct {
+	data     []byte
+	opts     lintOptions
+	findings []lintFinding
+	counts   map[string]int
+}
+
+// lintMessage runs every check on a message and returns the findings in
+// the order they were found
+func lintMessage(data []byte, opts lintOptions) []lintFinding {
+	l := &linter{data: data, opts: opts, counts: map[string]int{}}
+	l.checkLines()
+	l.checkPart(0, len(data), "text/plain", true)
+	sort.SliceStable(l.findings, func(i, j int) bool { return l.findings[i].Offset < l.findings[j].Offset })
+	var codes []string
+	for code, n := range l.counts {
+		if n > lintMaxPerCode {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		l.findings = append(l.findings, lintFinding{Severity: lintInfo, Code: code, Message: fmt.Sprintf("%d more %s findings not shown", l.counts[code]-lintMaxPerCode, code)})
+	}
+	return l.findings
+}
+
+func (l *linter) add(severity, code string, offset int, fixable bool, format string, args ...any) {
+	l.counts[code]++
+	if l.counts[code] > lintMaxPerCode {
+		return
+	}
+	l.findings = append(l.findings, lintFinding{Severity: severity, Code: code, Offset: offset, Message: fmt.Sprintf(format, args...), Fixable: fixable})
+}
+
+// lintLine is one line of the message: its content runs from Start to
+// ContentEnd, and its line ending, if any, up to End
+type lintLine struct {
+	Start, ContentEnd, End int
+}
+
+// lintLines splits data[start:end] into lines ending in LF, with a CR before
+// the LF treated as part of the line ending
+func lintLines(data []byte, start, end int) []lintLine {
+	var lines []lintLine
+	for start < end {
+		line := lintLine{Start: start, ContentEnd: end, End: end}
+		if i := bytes.IndexByte(data[start:end], '\n'); i >= 0 {
+			line.End = start + i + 1
+			line.ContentEnd = start + i
+			if i > 0 && data[start+i-1] == '\r' {
+				line.ContentEnd--
+			}
+		}
+		lines = append(lines, line)
+		start = line.End
+	}
+	return lines
+}
+
+// checkLines checks line lengths and line endings across the whole message
+func (l *linter) checkLines() {
+	lines := lintLines(l.data, 0, len(l.data))
+	_, headerEnd := l.headers(0, len(l.data))
+	crlf, lf := 0, 0
+	for _, line := range lines {
+		content := l.data[line.Start:line.ContentEnd]
+		switch {
+		case line.End == line.ContentEnd:
+		case line.End-line.ContentEnd == 2:
+			crlf++
+		default:
+			lf++
+		}
+		if length := len(content); length > lintMaxLine {
+			// Header fields can be folded to fix this, body lines can't
+			// without re-encoding
+			fixable := line.Start < headerEnd && bytes.ContainsAny(content, " \t")
+			l.add(lintError, "long-line", line.Start, fixable, "line is %d octets, more than the %d allowed", length, lintMaxLine)
+		}
+		for i := bytes.IndexByte(content, '\r'); i >= 0; i = bytes.IndexByte(content, '\r') {
+			offset := line.ContentEnd - len(content) + i
+			l.add(lintError, "bare-cr", offset, true, "CR without LF")
+			content = content[i+1:]
+		}
+	}
+	switch {
+	case lf > 0 && crlf > 0:
+		for _, line := range lines {
+			if line.End-line.ContentEnd == 1 {
+				l.add(lintError, "bare-lf", line.ContentEnd, true, "LF without CR, in a message that otherwise uses CRLF")
+			}
+		}
+	case lf > 0:
+		l.add(lintInfo, "line-endings", 0, true, "message uses LF line endings, which are converted to CRLF when it's sent")
+	}
+}
+
+// headers parses the header fields of the part data[start:end], returning
+// the fields and where the body starts
+func (l *linter) headers(start, end int) ([]lintField, int) {
+	var fields []lintField
+	for _, line := range lintLines(l.data, start, end) {
+		content := l.data[line.Start:line.ContentEnd]
+		switch {
+		case len(content) == 0:
+			return fields, line.End
+		case (content[0] == ' ' || content[0] == '\t') && len(fields) > 0:
+			f := &fields[len(fields)-1]
+			f.Value = append(f.Value, content...)
+			f.End = line.End
+		default:
+			name, value, found := bytes.Cut(content, []byte(":"))
+			if !found || !validFieldName(name) {
+				// Not a header field, so it's the start of the body
+				return fields, line.Start
+			}
+			fields = append(fields, lintField{Name: string(name), Value: append([]byte{}, value...), Offset: line.Start, End: line.End})
+		}
+	}
+	return fields, end
+}
+
+func validFieldName(name []byte) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for _, c := range name {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldsNamed returns the fields named name
+func fieldsNamed(fields []lintField, name string) []lintField {
+	var found []lintField
+	for _, f := range fields {
+		if strings.EqualFold(f.Name, name) {
+			found = append(found, f)
+		}
+	}
+	return found
+}
+
+// lintSingletons are the fields a message may have at most one of (RFC
+// 5322 section 3.6 and RFC 2045)
+var lintSingletons = []string{
+	"Date", "From", "Sender", "Reply-To", "To", "Cc", "Bcc", "Message-ID",
+	"In-Reply-To", "References", "Subject", "MIME-Version", "Content-Type",
+	"Content-Transfer-Encoding",
+}
+
+// lintEncodedWordFields are the fields that can contain encoded-words
+var lintEncodedWordFields = []string{
+	"Subject", "Comments", "Keywords", "From", "Sender", "Reply-To", "To",
+	"Cc", "Bcc", "Content-Description",
+}
+
+// lintAddressFields are the fields where encoded-words mustn't be quoted
+var lintAddressFields = []string{"From", "Sender", "Reply-To", "To", "Cc", "Bcc"}
+
+// needsMIMEVersion reports whether a message must declare MIME-Version:
+// if it has MIME fields or 8-bit body content
+func needsMIMEVersion(fields []lintField, body []byte) bool {
+	return len(fieldsNamed(fields, "Content-Type")) > 0 ||
+		len(fieldsNamed(fields, "Content-Transfer-Encoding")) > 0 ||
+		has8bit(body)
+}
+
+// checkTopHeaders checks the fields a message must or may only have once
+func (l *linter) checkTopHeaders(fields []lintField, body int) {
+	if len(fields) == 0 {
+		l.add(lintError, "no-headers", 0, false, "message has no header fields")
+		return
+	}
+	for _, required := range []struct {
+		name     string
+		severity string
+		fixable  bool
+	}{
+		{"From", lintError, false},
+		{"Date", lintError, true},
+		{"Message-ID", lintWarning, true},
+	} {
+		if len(fieldsNamed(fields, required.name)) == 0 {
+			l.add(required.severity, "missing-header", 0, required.fixable, "no %s field", required.name)
+		}
+	}
+	for _, name := range lintSingletons {
+		if found := fieldsNamed(fields, name); len(found) > 1 {
+			l.add(lintError, "duplicate-header", found[1].Offset, false, "%d %s fields, only one is allowed", len(found), name)
+		}
+	}
+	versions := fieldsNamed(fields, "MIME-Version")
+	switch {
+	case len(versions) == 0 && needsMIMEVersion(fields, l.data[body:]):
+		l.add(lintError, "missing-mime-version", 0, true, "no MIME-Version field, but the message uses MIME or 8-bit content")
+	case len(versions) > 0 && stripComments(string(versions[0].Value)) != "1.0":
+		l.add(lintWarning, "mime-version", versions[0].Offset, false, "MIME-Version is %q, not 1.0", strings.TrimSpace(string(versions[0].Value)))
+	}
+}
+
+// checkFieldContent checks for 8-bit data and malformed encoded-words in
+// header fields
+func (l *linter) checkFieldContent(fields []lintField) {
+	for _, f := range fields {
+		if has8bit(f.Value) {
+			switch {
+			case !utf8.Valid(f.Value):
+				l.add(lintError, "8bit-header", f.Offset, false, "%s field has 8-bit data that isn't UTF-8", f.Name)
+			case !l.opts.Smtputf8:
+				l.add(lintError, "8bit-header", f.Offset, false, "%s field has UTF-8, which needs SMTPUTF8", f.Name)
+			}
+		}
+		if containsFold(lintEncodedWordFields, f.Name) {
+			l.checkEncodedWords(f)
+		}
+	}
+}
+
+// encodedWord matches an RFC 2047 encoded-word
+var encodedWord = regexp.MustCompile(`=\?([^?\s]+)\?([^?\s]+)\?([^?\s]*)\?=`)
+
+// wordDecoder decodes encoded-words in any charset we know of
+var wordDecoder = mime.WordDecoder{CharsetReader: func(charset string, input io.Reader) (io.Reader, error) {
+	enc, err := htmlindex.Get(charset)
+	if err != nil {
+		return nil, err
+	}
+	return enc.NewDecoder().Reader(input), nil
+}}
+
+// checkEncodedWords checks the encoded-words in a field
+func (l *linter) checkEncodedWords(f lintField) {
+	value := string(f.Value)
+	matches := encodedWord.FindAllStringSubmatchIndex(value, -1)
+	covered := 0
+	for _, m := range matches {
+		word := value[m[0]:m[1]]
+		if strings.Contains(value[covered:m[0]], "=?") {
+			l.add(lintWarning, "encoded-word", f.Offset, false, "%s field has an incomplete encoded-word", f.Name)
+		}
+		covered = m[1]
+		charset, _, _ := strings.Cut(value[m[2]:m[3]], "*")
+		encoding := strings.ToUpper(value[m[4]:m[5]])
+		switch {
+		case encoding != "B" && encoding != "Q":
+			l.add(lintError, "encoded-word", f.Offset, false, "%s field has encoded-word %s with unknown encoding %q", f.Name, word, encoding)
+			continue
+		case len(word) > 75:
+			l.add(lintWarning, "encoded-word", f.Offset, false, "%s field has an encoded-word of %d characters, more than 75", f.Name, len(word))
+		}
+		if _, err := htmlindex.Get(charset); err != nil && !strings.EqualFold(charset, "us-ascii") {
+			l.add(lintError, "encoded-word", f.Offset, false, "%s field has encoded-word %s in unknown charset %q", f.Name, word, charset)
+			continue
+		}
+		if _, err := wordDecoder.Decode(word); err != nil {
+			l.add(lintError, "encoded-word", f.Offset, false, "%s field has malformed encoded-word %s: %s", f.Name, word, err)
+		}
+		if containsFold(lintAddressFields, f.Name) && insideQuotes(value, m[0]) {
+			l.add(lintWarning, "encoded-word", f.Offset, false, "%s field has encoded-word %s in a quoted string, where it isn't decoded", f.Name, word)
+		}
+	}
+	if strings.Contains(value[covered:], "=?") && strings.Contains(value[covered:], "?=") {
+		l.add(lintWarning, "encoded-word", f.Offset, false, "%s field has an incomplete encoded-word", f.Name)
+	}
+}
+
+// insideQuotes reports whether position i in an address field value is
+// inside a quoted string
+func insideQuotes(value string, i int) bool {
+	quoted := false
+	for j := 0; j < i; j++ {
+		switch value[j] {
+		case '\\':
+			j++
+		case '"':
+			quoted = !quoted
+		}
+	}
+	return quoted
+}
+
+// checkPart checks a MIME part, recursing into multiparts and enclosed
+// messages
+func (l *linter) checkPart(start, end int, defaultType string, top bool) {
+	fields, body := l.headers(start, end)
+	if top {
+		l.checkTopHeaders(fields, body)
+	}
+	l.checkFieldContent(fields)
+
+	mediaType, params := defaultType, map[string]string{}
+	if ct := fieldsNamed(fields, "Content-Type"); len(ct) > 0 {
+		var err error
+		mediaType, params, err = mime.ParseMediaType(string(ct[0].Value))
+		if err != nil {
+			l.add(lintError, "content-type", ct[0].Offset, false, "bad Content-Type: %s", err)
+			mediaType = defaultType
+		}
+	}
+	cte := "7bit"
+	cteOffset := body
+	if f := fieldsNamed(fields, "Content-Transfer-Encoding"); len(f) > 0 {
+		cte = strings.ToLower(stripComments(string(f[0].Value)))
+		cteOffset = f[0].Offset
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"), mediaType == "message/rfc822", mediaType == "message/global":
+		if cte != "7bit" && cte != "8bit" && cte != "binary" {
+			l.add(lintError, "cte-mismatch", cteOffset, false, "%s parts must be 7bit, 8bit or binary, not %s", mediaType, cte)
+		}
+	}
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/"):
+		childType := "text/plain"
+		if mediaType == "multipart/digest" {
+			childType = "message/rfc822"
+		}
+		l.checkMultipart(body, end, params["boundary"], childType)
+	case mediaType == "message/rfc822", mediaType == "message/global":
+		l.checkPart(body, end, "text/plain", false)
+	default:
+		l.checkContent(body, end, cte, cteOffset)
+	}
+}
+
+// checkMultipart checks each part of a multipart body
+func (l *linter) checkMultipart(start, end int, boundary, childType string) {
+	if boundary == "" {
+		l.add(lintError, "multipart", start, false, "multipart Content-Type has no boundary")
+		return
+	}
+	delimiter := []byte("--" + boundary)
+	partStart := -1
+	closed := false
+	for _, line := range lintLines(l.data, start, end) {
+		content := bytes.TrimRight(l.data[line.Start:line.ContentEnd], " \t")
+		if !bytes.HasPrefix(content, delimiter) {
+			continue
+		}
+		rest := content[len(delimiter):]
+		if len(rest) > 0 && !bytes.Equal(rest, []byte("--")) {
+			continue
+		}
+		if partStart >= 0 {
+			// The line ending before the delimiter belongs to it
+			partEnd := line.Start
+			if partEnd > partStart && l.data[partEnd-1] == '\n' {
+				partEnd--
+				if partEnd > partStart && l.data[partEnd-1] == '\r' {
+					partEnd--
+				}
+			}
+			l.checkPart(partStart, partEnd, childType, false)
+		}
+		if len(rest) > 0 {
+			closed = true
+			break
+		}
+		partStart = line.End
+	}
+	switch {
+	case partStart < 0:
+		l.add(lintError, "multipart", start, false, "multipart boundary %q never appears", boundary)
+	case !closed:
+		l.add(lintError, "multipart", end, false, "multipart boundary %q is never closed", boundary)
+	}
+}
+
+// checkContent checks that a leaf part's content matches its
+// Content-Transfer-Encoding
+func (l *linter) checkContent(start, end int, cte string, cteOffset int) {
+	content := l.data[start:end]
+	first8bit := bytes.IndexFunc(content, func(r rune) bool { return r >= 0x80 || r == utf8.RuneError })
+	switch cte {
+	case "7bit":
+		switch {
+		case first8bit < 0:
+		case cteOffset == start:
+			l.add(lintError, "cte-mismatch", start+first8bit, false, "8-bit data in a part with no Content-Transfer-Encoding, so 7bit")
+		default:
+			l.add(lintError, "cte-mismatch", start+first8bit, false, "8-bit data in a part with Content-Transfer-Encoding 7bit")
+		}
+	case "8bit", "binary":
+		if first8bit >= 0 && !l.opts.EightBitMIME {
+			l.add(lintError, "needs-8bitmime", start+first8bit, false, "8-bit data needs 8BITMIME")
+		}
+		if cte == "binary" {
+			l.add(lintWarning, "cte-mismatch", cteOffset, false, "binary content can't be sent over SMTP without BINARYMIME")
+		}
+	case "quoted-printable":
+		if first8bit >= 0 {
+			l.add(lintError, "cte-mismatch", start+first8bit, false, "unencoded 8-bit data in a quoted-printable part")
+		}
+		for _, line := range lintLines(l.data, start, end) {
+			text := l.data[line.Start:line.ContentEnd]
+			if len(text) > 76 {
+				l.add(lintWarning, "qp-line", line.Start, false, "quoted-printable line is %d characters, more than 76", len(text))
+			}
+			for i := bytes.IndexByte(text, '='); i >= 0; i = nextByte(text, '=', i+1) {
+				rest := text[i+1:]
+				switch {
+				case len(bytes.TrimRight(rest, " \t")) == 0:
+					// A soft line break
+				case len(rest) >= 2 && isHex(rest[0]) && isHex(rest[1]):
+					i += 2
+				default:
+					l.add(lintError, "cte-mismatch", line.Start+i, false, "bad quoted-printable escape")
+				}
+			}
+		}
+	case "base64":
+		var clean []byte
+		for i, c := range content {
+			switch {
+			case c == '\r' || c == '\n' || c == ' ' || c == '\t':
+			case c == '=' || c == '+' || c == '/' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+				clean = append(clean, c)
+			default:
+				l.add(lintError, "cte-mismatch", start+i, false, "character %q isn't base64", c)
+				return
+			}
+		}
+		if _, err := base64.StdEncoding.DecodeString(string(clean)); err != nil {
+			l.add(lintError, "cte-mismatch", start, false, "bad base64: %s", err)
+		}
+		for _, line := range lintLines(l.data, start, end) {
+			if length := line.ContentEnd - line.Start; length > 76 {
+				l.add(lintWarning, "base64-line", line.Start, false, "base64 line is %d characters, more than 76", length)
+			}
+		}
+	default:
+		l.add(lintError, "cte-mismatch", cteOffset, false, "unknown Content-Transfer-Encoding %q", cte)
+	}
+}
+
+func nextByte(s []byte, c byte, from int) int {
+	if from >= len(s) {
+		return -1
+	}
+	if i := bytes.IndexByte(s[from:], c); i >= 0 {
+		return from + i
+	}
+	return -1
+}
+
+func isHex(c byte) bool {
+	return ('0' <= c && c <= '9') || ('a' <= c && c <= 'f') || ('A' <= c && c <= 'F')
+}
+
+func has8bit(data []byte) bool {
+	for _, c := range data {
+		if c >= 0x80 {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComments removes RFC 5322 comments and surrounding whitespace from
+// a simple structured field value, such as MIME-Version: 1.0 (produced by x)
+func stripComments(value string) string {
+	var b strings.Builder
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && depth > 0:
+			i++
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case depth == 0:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
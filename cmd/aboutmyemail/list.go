@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"os"
+	"time"
+)
+
+type ListCmd struct {
+	After      string `help:"Only list messages submitted since this time, as RFC 3339, a date or a duration ago (e.g. 24h)" placeholder:"time"`
+	Before     string `help:"Only list messages submitted before this time, as RFC 3339, a date or a duration ago" placeholder:"time"`
+	Token      string `help:"Only list messages submitted with this token"`
+	FromDomain string `help:"Only list messages with a MAIL FROM in this domain" placeholder:"domain"`
+	ToDomain   string `help:"Only list messages with a RCPT TO in this domain" placeholder:"domain"`
+	Staged     bool   `help:"Only list messages rendered with the staged configuration" xor:"stage"`
+	Production bool   `help:"Only list messages rendered with the production configuration" xor:"stage"`
+	Limit      int    `help:"Maximum number of messages to list, 0 for all" default:"50"`
+	Json       bool   `help:"Print the list as JSON"`
+}
+
+// errLimitReached stops ListAll once we have enough results
+var errLimitReached = errors.New("limit reached")
+
+func (l *ListCmd) Run(globals *Globals) error {
+	params := aboutmyemail.EmailListParams{}
+	var err error
+	if params.After, err = parseTimeFlag(l.After); err != nil {
+		fatal("Bad --after: %s", err)
+	}
+	if params.Before, err = parseTimeFlag(l.Before); err != nil {
+		fatal("Bad --before: %s", err)
+	}
+	if l.Token != "" {
+		params.Token = &l.Token
+	}
+	if l.FromDomain != "" {
+		params.FromDomain = &l.FromDomain
+	}
+	if l.ToDomain != "" {
+		params.ToDomain = &l.ToDomain
+	}
+	if l.Staged || l.Production {
+		params.Staged = &l.Staged
+	}
+	if l.Limit > 0 {
+		pageSize := min(l.Limit, 500)
+		params.Limit = &pageSize
+	}
+
+	client := newClient(globals)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	emails := []aboutmyemail.EmailSummary{}
+	err = client.ListAll(ctx, &params, func(email aboutmyemail.EmailSummary) error {
+		emails = append(emails, email)
+		if l.Limit > 0 && len(emails) >= l.Limit {
+			return errLimitReached
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errLimitReached) {
+		fatal("Failed to list messages: %s", err)
+	}
+
+	if l.Json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		_ = encoder.Encode(emails)
+		return nil
+	}
+	cyan := color.New(color.FgCyan).SprintFunc()
+	blue := color.New(color.FgHiBlue).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	for _, email := range emails {
+		url := yellow("processing")
+		if email.Url != nil {
+			url = *email.Url
+		}
+		staged := ""
+		if email.Staged != nil && *email.Staged {
+			staged = " (staged)"
+		}
+		_, _ = fmt.Fprintf(color.Output, "%s  %s  %s -> %s from %s%s\n    %s\n",
+			cyan(email.Id), email.Submitted.Local().Format("2006-01-02 15:04:05"),
+			blue(email.From), blue(email.To), email.Ip, staged, url)
+	}
+	if len(emails) == 0 && !globals.Quiet {
+		printWarning("No matching messages")
+	}
+	return nil
+}
+
+// parseTimeFlag parses a time given as RFC 3339, a plain date or a duration
+// before now. An empty value gives nil.
+func parseTimeFlag(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return &t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		t := time.Now().Add(-d)
+		return &t, nil
+	}
+	return nil, fmt.Errorf("%q is not an RFC 3339 time, a date or a duration", value)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+)
+
+// openLogger returns a logger for the modes that run unattended. dest is
+// "syslog", "-" for stderr, or a file to append to; empty means syslog. If
+// the log can't be opened it falls back to stderr. The returned function
+// closes the log.
+func openLogger(dest string) (*slog.Logger, func()) {
+	var w io.Writer = os.Stderr
+	closer := func() {}
+	switch dest {
+	case "-":
+	case "", "syslog":
+		sl, err := openSyslog()
+		if err == nil {
+			w = sl
+			closer = func() { _ = sl.Close() }
+		}
+	default:
+		f, err := os.OpenFile(dest, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err == nil {
+			w = f
+			closer = func() { _ = f.Close() }
+		} else {
+			_, _ = fmt.Fprintf(os.Stderr, "aboutmyemail: can't open log: %s\n", err)
+		}
+	}
+	return slog.New(slog.NewTextHandler(w, nil)), closer
+}
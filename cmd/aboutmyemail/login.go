@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"os"
+	"strings"
+	"time"
+)
+
+type LoginCmd struct {
+	NoVerify bool `help:"Store the key without checking the server accepts it"`
+}
+
+func (l *LoginCmd) Run(globals *Globals) error {
+	globals.explicitKey()
+	key := globals.ApiKey
+	if globals.keySource != keyFromFlag && globals.keySource != keyFromFile {
+		_, _ = fmt.Fprintf(os.Stderr, "API key for %s: ", globals.Server)
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			fatal("Failed to read api key: %s", err)
+		}
+		key = strings.TrimSpace(line)
+	}
+	if key == "" {
+		fatal("No api key given")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if !l.NoVerify {
+		verifyKey(ctx, globals, key)
+	}
+
+	store, err := aboutmyemail.NewCredentialStore(globals.CredentialHelper)
+	if err != nil {
+		fatal("Failed to open credential store: %s", err)
+	}
+	if err := store.Store(ctx, globals.Server, key); err != nil {
+		fatal("Failed to store api key: %s", err)
+	}
+	if !globals.Quiet {
+		green := color.New(color.FgHiGreen).SprintFunc()
+		_, _ = fmt.Fprintf(color.Output, "%s\n", green(fmt.Sprintf("Stored api key for %s", globals.Server)))
+	}
+	return nil
+}
+
+// verifyKey checks the server accepts key, by listing at most one message
+func verifyKey(ctx context.Context, globals *Globals, key string) {
+	client := newClientWithKey(globals, key)
+	limit := 1
+	_, err := client.List(ctx, &aboutmyemail.EmailListParams{Limit: &limit})
+	switch {
+	case err == nil:
+	case errors.Is(err, aboutmyemail.ErrUnauthorized), errors.Is(err, aboutmyemail.ErrForbidden):
+		fatal("The server rejected the api key: %s", err)
+	default:
+		printWarning("Couldn't check the api key: %s", err)
+	}
+}
+
+type LogoutCmd struct{}
+
+func (l *LogoutCmd) Run(globals *Globals) error {
+	store, err := aboutmyemail.NewCredentialStore(globals.CredentialHelper)
+	if err != nil {
+		fatal("Failed to open credential store: %s", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := store.Erase(ctx, globals.Server); err != nil {
+		fatal("Failed to remove api key: %s", err)
+	}
+	if !globals.Quiet {
+		green := color.New(color.FgHiGreen).SprintFunc()
+		_, _ = fmt.Fprintf(color.Output, "%s\n", green(fmt.Sprintf("Removed stored api key for %s", globals.Server)))
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Selection is the flags that choose messages from a mailbox
+type Selection struct {
+	Index     string   `help:"Messages to use from a mailbox, by position from 1, e.g. 2,5-7; negative counts from the end, as in --index=-1 for the last" placeholder:"list"`
+	MessageId []string `help:"Use the message with this Message-ID from a mailbox" placeholder:"id"`
+	Match     []string `help:"Use messages from a mailbox with a header field matching, e.g. \"Subject: ^Spring\"" placeholder:"Name: regexp"`
+}
+
+// mailboxMessage is a message read from a mailbox
+type mailboxMessage struct {
+	// Name identifies the message in results: the file, file#index for
+	// an mbox, or the file in a Maildir
+	Name string
+	// Index is the position of the message in its mailbox, from 1
+	Index int
+	Data  []byte
+}
+
+// readMailbox reads the messages in path, which can be a single message,
+// an mbox file, a Maildir or a directory of .eml files
+func readMailbox(path string) ([]mailboxMessage, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if !isMbox(data) {
+			return []mailboxMessage{{Name: path, Index: 1, Data: data}}, nil
+		}
+		var messages []mailboxMessage
+		for i, msg := range splitMbox(data) {
+			messages = append(messages, mailboxMessage{Name: fmt.Sprintf("%s#%d", path, i+1), Index: i + 1, Data: msg})
+		}
+		return messages, nil
+	}
+
+	var files []string
+	if isMaildir(path) {
+		// Maildir filenames start with the delivery time, so sorting by
+		// name puts them in the order they arrived
+		for _, sub := range []string{"cur", "new"} {
+			entries, err := os.ReadDir(filepath.Join(path, sub))
+			if err != nil {
+				return nil, err
+			}
+			for _, e := range entries {
+				if e.Type().IsRegular() && !strings.HasPrefix(e.Name(), ".") {
+					files = append(files, filepath.Join(path, sub, e.Name()))
+				}
+			}
+		}
+		sort.Slice(files, func(i, j int) bool {
+			return filepath.Base(files[i]) < filepath.Base(files[j])
+		})
+	} else {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Type().IsRegular() && strings.EqualFold(filepath.Ext(e.Name()), ".eml") {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s isn't a Maildir and has no .eml files", path)
+	}
+	var messages []mailboxMessage
+	for i, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, mailboxMessage{Name: file, Index: i + 1, Data: data})
+	}
+	return messages, nil
+}
+
+func isMaildir(path string) bool {
+	for _, sub := range []string{"cur", "new", "tmp"} {
+		if info, err := os.Stat(filepath.Join(path, sub)); err != nil || !info.IsDir() {
+			return false
+		}
+	}
+	return true
+}
+
+var mboxFromLine = []byte("From ")
+
+func isMbox(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf}), mboxFromLine)
+}
+
+// escapedFrom matches a From line in a message body that was escaped when
+// it was written to the mbox
+var escapedFrom = regexp.MustCompile(`^>+From `)
+
+// splitMbox splits an mbox file into its messages, removing the From_
+// separator lines and the blank line that ends each message, and
+// unescaping body lines that start with From (mboxrd).
+func splitMbox(data []byte) [][]byte {
+	data = bytes.TrimPrefix(data, []byte{0xef, 0xbb, 0xbf})
+	var messages [][]byte
+	var current []byte
+	inMessage := false
+	finish := func() {
+		if inMessage {
+			// The writer adds a blank line before the next From_ line
+			if bytes.HasSuffix(current, []byte("\r\n\r\n")) {
+				current = current[:len(current)-2]
+			} else if bytes.HasSuffix(current, []byte("\n\n")) {
+				current = current[:len(current)-1]
+			}
+			messages = append(messages, current)
+		}
+		current = nil
+		inMessage = true
+	}
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i+1]
+		}
+		data = data[len(line):]
+		switch {
+		case bytes.HasPrefix(line, mboxFromLine):
+			finish()
+		case escapedFrom.Match(line):
+			current = append(current, line[1:]...)
+		default:
+			current = append(current, line...)
+		}
+	}
+	finish()
+	return messages
+}
+
+// selected returns the messages chosen by the selection flags: those with
+// an index given, a Message-ID given or a matching header field. With no
+// flags every message is selected.
+func (s Selection) selected(messages []mailboxMessage) ([]mailboxMessage, error) {
+	indexes, err := parseIndexes(s.Index, len(messages))
+	if err != nil {
+		return nil, err
+	}
+	matches, err := parseHeaderMatches("--match", s.Match)
+	if err != nil {
+		return nil, err
+	}
+	if indexes == nil && len(s.MessageId) == 0 && len(matches) == 0 {
+		return messages, nil
+	}
+	var selected []mailboxMessage
+	for _, msg := range messages {
+		if indexes[msg.Index] || s.matches(msg.Data, matches) {
+			selected = append(selected, msg)
+		}
+	}
+	return selected, nil
+}
+
+// matches reports whether a message has a Message-ID or header field
+// that's been selected
+func (s Selection) matches(data []byte, matches []headerMatch) bool {
+	if len(s.MessageId) == 0 && len(matches) == 0 {
+		return false
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return false
+	}
+	messageID := strings.Trim(strings.TrimSpace(msg.Header.Get("Message-ID")), "<>")
+	for _, id := range s.MessageId {
+		if messageID != "" && messageID == strings.Trim(strings.TrimSpace(id), "<>") {
+			return true
+		}
+	}
+	for _, m := range matches {
+		for name, values := range msg.Header {
+			for _, v := range values {
+				if m.match(name, v) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// parseIndexes parses an --index list, such as 1,3-5,-1, into the set of
+// positions selected
+func parseIndexes(list string, count int) (map[int]bool, error) {
+	if list == "" {
+		return nil, nil
+	}
+	position := func(s string) (int, error) {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("bad --index %q", list)
+		}
+		if n < 0 {
+			n += count + 1
+		}
+		if n < 1 || n > count {
+			return 0, fmt.Errorf("--index %s is out of range, there are %d messages", s, count)
+		}
+		return n, nil
+	}
+	indexes := map[int]bool{}
+	for _, item := range strings.Split(list, ",") {
+		first, last, isRange := strings.Cut(strings.TrimSpace(item), "-")
+		if !isRange || first == "" {
+			// A single position, possibly negative
+			n, err := position(item)
+			if err != nil {
+				return nil, err
+			}
+			indexes[n] = true
+			continue
+		}
+		from, err := position(first)
+		if err != nil {
+			return nil, err
+		}
+		to, err := position(last)
+		if err != nil {
+			return nil, err
+		}
+		for n := from; n <= to; n++ {
+			indexes[n] = true
+		}
+	}
+	return indexes, nil
+}
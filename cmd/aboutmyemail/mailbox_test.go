@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testMbox = "From sender@example.com Mon Jan  1 00:00:00 2024\n" +
+	"Message-ID: <one@example.com>\nSubject: First\n\nHello\n>From the body\n>>From quoted\n\n" +
+	"From sender@example.com Tue Jan  2 00:00:00 2024\n" +
+	"Message-ID: <two@example.com>\nSubject: Spring sale\n\nSecond\n\n" +
+	"From sender@example.com Wed Jan  3 00:00:00 2024\n" +
+	"Message-ID: <three@example.com>\nSubject: Third\n\nThird\n"
+
+func TestSplitMbox(t *testing.T) {
+	got := splitMbox([]byte(testMbox))
+	want := []string{
+		"Message-ID: <one@example.com>\nSubject: First\n\nHello\nFrom the body\n>From quoted\n",
+		"Message-ID: <two@example.com>\nSubject: Spring sale\n\nSecond\n",
+		"Message-ID: <three@example.com>\nSubject: Third\n\nThird\n",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d messages, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if string(got[i]) != want[i] {
+			t.Errorf("message %d: want %q, got %q", i+1, want[i], got[i])
+		}
+	}
+}
+
+func TestReadMailbox(t *testing.T) {
+	dir := t.TempDir()
+	mbox := filepath.Join(dir, "seeds.mbox")
+	maildir := filepath.Join(dir, "Maildir")
+	emls := filepath.Join(dir, "emls")
+	for _, d := range []string{"cur", "new", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(maildir, d), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(emls, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		mbox: testMbox,
+		filepath.Join(maildir, "new", "1700000002.M2.host"):     "Subject: b\n\nb\n",
+		filepath.Join(maildir, "cur", "1700000001.M1.host:2,S"): "Subject: a\n\na\n",
+		filepath.Join(maildir, "tmp", "1700000003.M3.host"):     "Subject: partial\n",
+		filepath.Join(emls, "b.eml"):                            "Subject: b\n\nb\n",
+		filepath.Join(emls, "a.EML"):                            "Subject: a\n\na\n",
+		filepath.Join(emls, "notes.txt"):                        "not a message",
+		filepath.Join(dir, "single.eml"):                        "Subject: single\n\nbody\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path  string
+		names []string
+	}{
+		{mbox, []string{mbox + "#1", mbox + "#2", mbox + "#3"}},
+		{maildir, []string{filepath.Join(maildir, "cur", "1700000001.M1.host:2,S"), filepath.Join(maildir, "new", "1700000002.M2.host")}},
+		{emls, []string{filepath.Join(emls, "a.EML"), filepath.Join(emls, "b.eml")}},
+		{filepath.Join(dir, "single.eml"), []string{filepath.Join(dir, "single.eml")}},
+	}
+	for _, tt := range tests {
+		messages, err := readMailbox(tt.path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.path, err)
+			continue
+		}
+		var names []string
+		for _, m := range messages {
+			names = append(names, m.Name)
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: want %v, got %v", tt.path, tt.names, names)
+		}
+	}
+}
+
+func TestSelection(t *testing.T) {
+	var messages []mailboxMessage
+	for i, data := range splitMbox([]byte(testMbox)) {
+		messages = append(messages, mailboxMessage{Name: "m", Index: i + 1, Data: data})
+	}
+	tests := []struct {
+		selection Selection
+		want      []int
+	}{
+		{Selection{}, []int{1, 2, 3}},
+		{Selection{Index: "-1"}, []int{3}},
+		{Selection{Index: "1,2-3"}, []int{1, 2, 3}},
+		{Selection{MessageId: []string{"two@example.com"}}, []int{2}},
+		{Selection{MessageId: []string{"<three@example.com>"}, Index: "1"}, []int{1, 3}},
+		{Selection{Match: []string{"subject: ^spring"}}, nil},
+		{Selection{Match: []string{"Subject: (?i)^spring"}}, []int{2}},
+	}
+	for _, tt := range tests {
+		selected, err := tt.selection.selected(messages)
+		if err != nil {
+			t.Errorf("%+v: %v", tt.selection, err)
+			continue
+		}
+		var got []int
+		for _, m := range selected {
+			got = append(got, m.Index)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: want %v, got %v", tt.selection, tt.want, got)
+		}
+	}
+	for _, bad := range []string{"0", "4", "x", "2-9"} {
+		if _, err := (Selection{Index: bad}).selected(messages); err == nil {
+			t.Errorf("want error for --index %s", bad)
+		}
+	}
+}
@@ -1,6 +1,15 @@
 package main
 
-import "testing"
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
 
 // A non-ASCII Return-Path must be used in preference to From, and survive
 // intact.
@@ -40,3 +49,78 @@ func TestLocalpartNeedsUTF8(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTimeFlag(t *testing.T) {
+	if got, err := parseTimeFlag(""); got != nil || err != nil {
+		t.Errorf("empty want nil, nil, got %v, %v", got, err)
+	}
+	got, err := parseTimeFlag("2024-03-01T12:00:00Z")
+	if err != nil || !got.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("rfc 3339 got %v, %v", got, err)
+	}
+	got, err = parseTimeFlag("2024-03-01")
+	if err != nil || !got.Equal(time.Date(2024, 3, 1, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("date got %v, %v", got, err)
+	}
+	got, err = parseTimeFlag("24h")
+	if err != nil || time.Since(*got) < 24*time.Hour || time.Since(*got) > 25*time.Hour {
+		t.Errorf("duration got %v, %v", got, err)
+	}
+	if _, err := parseTimeFlag("last tuesday"); err == nil {
+		t.Errorf("want error for unparseable time")
+	}
+}
+
+// Messages that aren't valid UTF-8, or are large, are sent as a multipart
+// form and arrive byte for byte; others still use JSON
+func TestSubmitEmail(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	client := newClient(&Globals{Server: server.Endpoint, Quiet: true})
+	envelope := Envelope{From: "a@example.com", To: "b@example.org", Ip: "192.0.2.1", Helo: "mail.example.com"}
+
+	latin1 := []byte("Subject: Caf\xe9\r\n\r\nCr\xe8me br\xfbl\xe9e\r\n")
+	large := bytes.Repeat([]byte("Subject: big\r\n"), formThreshold/10)
+	for _, email := range [][]byte{[]byte("Subject: plain\r\n\r\nbody\r\n"), latin1, large} {
+		if _, err := submitEmail(context.Background(), client, envelope.request(email), email); err != nil {
+			t.Fatalf("submit failed: %v", err)
+		}
+	}
+	subs := server.Submissions()
+	for i, want := range []string{"application/json", "multipart/form-data", "multipart/form-data"} {
+		if subs[i].ContentType != want {
+			t.Errorf("submission %d: want %s, got %s", i, want, subs[i].ContentType)
+		}
+	}
+	if !bytes.Equal(subs[1].Payload, latin1) || !bytes.Equal(subs[2].Payload, large) {
+		t.Errorf("payload changed in transit")
+	}
+	if subs[1].From != envelope.From || subs[1].Helo != envelope.Helo {
+		t.Errorf("want envelope in form, got %+v", subs[1])
+	}
+}
+
+// The credential helper is only run when a command needs the api key
+func TestApiKey_Lazy(t *testing.T) {
+	dir := t.TempDir()
+	marker := filepath.Join(dir, "called")
+	globals := &Globals{Config: filepath.Join(dir, "config.json"), CredentialHelper: "!touch " + marker + " #"}
+	if err := globals.loadProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("credential helper run before the key was needed")
+	}
+	globals.apiKey()
+	if _, err := os.Stat(marker); err != nil {
+		t.Error("credential helper not run when the key was needed")
+	}
+
+	globals = &Globals{Config: filepath.Join(dir, "config.json"), CredentialHelper: "!exit 1", ApiKey: "flagkey"}
+	if err := globals.loadProfile(); err != nil {
+		t.Fatal(err)
+	}
+	if key := globals.apiKey(); key != "flagkey" || globals.keySource != keyFromFlag {
+		t.Errorf("want key from flag, got %q from %s", key, globals.keySource)
+	}
+}
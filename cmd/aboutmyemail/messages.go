@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"github.com/fatih/color"
+	"os"
+)
+
+func printError(format string, args ...any) {
+	red := color.New(color.FgHiRed).SprintFunc()
+	_, _ = fmt.Fprintf(color.Output, "%s: %s\n", red("ERROR"), fmt.Sprintf(format, args...))
+}
+
+func printWarning(format string, args ...any) {
+	yellow := color.New(color.FgHiYellow).SprintFunc()
+	_, _ = fmt.Fprintf(color.Output, "%s: %s\n", yellow("WARN"), fmt.Sprintf(format, args...))
+}
+
+func fatal(format string, args ...any) {
+	printError(format, args...)
+	os.Exit(1)
+}
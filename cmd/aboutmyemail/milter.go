@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+type MilterCmd struct {
+	Listen       string   `help:"Address to listen on, as inet:host:port, unix:path or host:port" default:"127.0.0.1:8890" placeholder:"address"`
+	Percent      float64  `help:"Percentage of eligible messages to submit" default:"1"`
+	SenderDomain []string `help:"Only sample mail from these envelope sender domains" placeholder:"domain"`
+	Header       []string `help:"Only sample mail with a header field matching, e.g. \"X-Campaign: ^spring\"" placeholder:"Name: regexp"`
+	Ip           string   `help:"Submit with this IP address rather than the MTA's client, e.g. for mail submitted locally" placeholder:"dotted-quad"`
+	Staged       bool     `help:"Display results using staged whitelabel configuration"`
+	MaxSize      int      `help:"Largest message to sample, in bytes" default:"26214400"`
+	Connections  int      `help:"Most MTA connections to sample from at once; mail on more isn't sampled" default:"100"`
+	Submissions  int      `help:"Most sampled messages to submit and follow at once; more are skipped" default:"16"`
+	Log          string   `help:"Log results to syslog, a file, or - for stderr" default:"-" placeholder:"dest"`
+}
+
+func (m *MilterCmd) Run(globals *Globals) error {
+	headers, err := parseHeaderMatches("--header", m.Header)
+	if err != nil {
+		fatal("%s", err)
+	}
+	logger, closeLog := openLogger(m.Log)
+	defer closeLog()
+
+	client := newClient(globals)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server := &milterServer{
+		MaxSize:        m.MaxSize,
+		MaxConnections: m.Connections,
+		MaxDeliveries:  m.Submissions,
+		Sample: func(msg *milterMessage) bool {
+			return m.sample(msg, headers)
+		},
+		Deliver: func(msg *milterMessage) {
+			m.deliver(ctx, client, logger, msg)
+		},
+		Skipped: func(msg *milterMessage) {
+			logger.Warn("sample skipped, too many in progress", "queue_id", msg.QueueID, "from", msg.From)
+		},
+	}
+
+	network, address := milterAddress(m.Listen)
+	if network == "unix" {
+		_ = os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		fatal("Failed to listen on %s: %s", m.Listen, err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	if !globals.Quiet {
+		blue := color.New(color.FgHiBlue).SprintFunc()
+		_, _ = fmt.Fprintf(color.Output, "Listening for milter connections on %s, sampling %g%%\n", blue(listener.Addr()), m.Percent)
+	}
+	if err := server.Serve(listener); err != nil {
+		fatal("Milter failed: %s", err)
+	}
+	return nil
+}
+
+// sample decides whether to submit a message, given its envelope and
+// headers. A message must match a sender domain and a header, if any are
+// given, and is then sampled at random.
+func (m *MilterCmd) sample(msg *milterMessage, headers []headerMatch) bool {
+	if len(m.SenderDomain) > 0 {
+		_, domain, _ := strings.Cut(msg.From, "@")
+		found := false
+		for _, d := range m.SenderDomain {
+			if strings.EqualFold(domain, d) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(headers) > 0 {
+		found := false
+	headerLoop:
+		for _, match := range headers {
+			for _, h := range msg.Headers {
+				if match.match(h.Name, h.Value) {
+					found = true
+					break headerLoop
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return rand.Float64()*100 < m.Percent
+}
+
+// deliver submits a sampled message and logs its result
+func (m *MilterCmd) deliver(ctx context.Context, client *aboutmyemail.ClientWithResponses, logger *slog.Logger, msg *milterMessage) {
+	if len(msg.To) == 0 {
+		return
+	}
+	envelope := Envelope{From: msg.From, To: msg.To[0], Ip: msg.ClientIP, Helo: msg.Helo, Ascii: !needsUTF8(msg.From + strings.Join(msg.To, "")), Staged: m.Staged}
+	if m.Ip != "" {
+		envelope.Ip = m.Ip
+	}
+	if envelope.From == "" {
+		// Bounces have an empty return path
+		envelope.From = "MAILER-DAEMON@" + envelope.Helo
+	}
+	log := logger.With("queue_id", msg.QueueID, "from", msg.From, "to", envelope.To)
+
+	submitCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+	response, err := submitEmail(submitCtx, client, envelope.request(msg.Data), msg.Data)
+	cancel()
+	if err == nil {
+		err = aboutmyemail.CheckResponse(response.HTTPResponse, response.Body)
+	}
+	if err == nil && response.JSON200 == nil {
+		err = fmt.Errorf("unexpected nil result in response")
+	}
+	if err != nil {
+		log.Error("submission failed", "error", err)
+		return
+	}
+	id := response.JSON200.Id
+	log.Info("submitted", "id", id, "ip", envelope.Ip, "helo", envelope.Helo, "size", len(msg.Data))
+
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	result, err := client.Wait(waitCtx, id)
+	if err != nil {
+		log.Error("waiting for result failed", "id", id, "error", err)
+		return
+	}
+	log.Info("processed", "id", id, "url", *result.Url)
+}
+
+// milterAddress splits a sendmail style socket address into a network and
+// address for net.Listen
+func milterAddress(s string) (string, string) {
+	switch {
+	case strings.HasPrefix(s, "unix:"):
+		return "unix", strings.TrimPrefix(s, "unix:")
+	case strings.HasPrefix(s, "local:"):
+		return "unix", strings.TrimPrefix(s, "local:")
+	case strings.HasPrefix(s, "inet:"), strings.HasPrefix(s, "inet6:"):
+		_, addr, _ := strings.Cut(s, ":")
+		// sendmail writes inet:port@host
+		if port, host, ok := strings.Cut(addr, "@"); ok {
+			return "tcp", net.JoinHostPort(host, port)
+		}
+		return "tcp", addr
+	}
+	return "tcp", s
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+// milterClient plays the part of the MTA
+type milterClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// send sends a command and returns the milter's reply, if it's expected to give one
+func (c *milterClient) send(cmd byte, reply bool, fields ...string) (byte, []byte) {
+	c.t.Helper()
+	var data []byte
+	for _, f := range fields {
+		data = append(data, f...)
+	}
+	if err := writeMilterPacket(c.conn, cmd, data); err != nil {
+		c.t.Fatalf("write failed: %v", err)
+	}
+	if !reply {
+		return 0, nil
+	}
+	code, rdata, err := readMilterPacket(c.r)
+	if err != nil {
+		c.t.Fatalf("read reply to %c failed: %v", cmd, err)
+	}
+	return code, rdata
+}
+
+// expect sends a command and checks the reply code
+func (c *milterClient) expect(want byte, cmd byte, fields ...string) {
+	c.t.Helper()
+	if code, _ := c.send(cmd, true, fields...); code != want {
+		c.t.Fatalf("want %c in reply to %c, got %c", want, cmd, code)
+	}
+}
+
+// startMilter runs a milterServer on a local port until the test ends, and
+// returns a connected client that has negotiated options
+func startMilter(t *testing.T, sample func(*milterMessage) bool, deliver func(*milterMessage)) *milterClient {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &milterServer{Sample: sample, Deliver: deliver, MaxSize: 1000}
+	done := make(chan struct{})
+	go func() {
+		_ = server.Serve(listener)
+		close(done)
+	}()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = listener.Close()
+		<-done
+	})
+	c := &milterClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+
+	// Offer version 6, every action, and the ability to skip
+	opt := binary.BigEndian.AppendUint32(nil, 6)
+	opt = binary.BigEndian.AppendUint32(opt, 0x1ff)
+	opt = binary.BigEndian.AppendUint32(opt, 0x1fffff)
+	code, reply := c.send(milterOptNeg, true, string(opt))
+	if code != milterOptNeg || len(reply) < 12 {
+		t.Fatalf("bad option negotiation reply %c %q", code, reply)
+	}
+	if actions := binary.BigEndian.Uint32(reply[4:8]); actions != 0 {
+		t.Errorf("want no actions requested, got %#x", actions)
+	}
+	if protocol := binary.BigEndian.Uint32(reply[8:12]); protocol != milterProtoSkip {
+		t.Errorf("want only skip requested, got %#x", protocol)
+	}
+	return c
+}
+
+// transaction runs a message through the milter, returning the reply to the body
+func (c *milterClient) transaction(queueID, from, subject string) byte {
+	c.t.Helper()
+	c.expect(milterContinue, milterMail, "<"+from+">\x00")
+	c.expect(milterContinue, milterRcpt, "<one@example.org>\x00")
+	c.expect(milterContinue, milterData)
+	c.expect(milterContinue, milterHeader, "Subject\x00", subject+"\x00")
+	c.expect(milterContinue, milterHeader, "Received\x00", "from a\n\tby b\x00")
+	c.expect(milterContinue, milterEOH)
+	code, _ := c.send(milterBody, true, "line one\r\nline two\r\n")
+	c.send(milterMacro, false, string([]byte{milterEOB}), "i\x00", queueID+"\x00")
+	c.expect(milterContinue, milterEOB)
+	return code
+}
+
+func TestMilterServer(t *testing.T) {
+	var mtx sync.Mutex
+	var wg sync.WaitGroup
+	var received []*milterMessage
+	c := startMilter(t, func(msg *milterMessage) bool {
+		return msg.Headers[0].Value == "sampled"
+	}, func(msg *milterMessage) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		received = append(received, msg)
+		wg.Done()
+	})
+
+	c.send(milterMacro, false, string([]byte{milterConnect}), "j\x00mx.example.com\x00")
+	c.expect(milterContinue, milterConnect, "client.example.com\x00", "4", "\x00\x19", "192.0.2.7\x00")
+	c.expect(milterContinue, milterHelo, "client.example.com\x00")
+
+	wg.Add(1)
+	if code := c.transaction("ABC123", "sender@example.com", "sampled"); code != milterContinue {
+		t.Errorf("want continue for sampled body, got %c", code)
+	}
+	if code := c.transaction("DEF456", "sender@example.com", "not sampled"); code != milterSkip {
+		t.Errorf("want skip for unsampled body, got %c", code)
+	}
+	c.send(milterQuit, false)
+	wg.Wait()
+
+	if len(received) != 1 {
+		t.Fatalf("want one message delivered, got %d", len(received))
+	}
+	msg := received[0]
+	if msg.QueueID != "ABC123" || msg.ClientIP != "192.0.2.7" || msg.Helo != "client.example.com" ||
+		msg.From != "sender@example.com" || len(msg.To) != 1 || msg.To[0] != "one@example.org" {
+		t.Errorf("wrong envelope %+v", msg)
+	}
+	want := "Subject: sampled\r\nReceived: from a\r\n\tby b\r\n\r\nline one\r\nline two\r\n"
+	if string(msg.Data) != want {
+		t.Errorf("want message %q, got %q", want, msg.Data)
+	}
+}
+
+// Once MaxDeliveries are in progress the next is skipped at once, rather
+// than hold up the MTA
+func TestMilterServer_MaxDeliveries(t *testing.T) {
+	release := make(chan struct{})
+	var delivered, skipped []string
+	var mu sync.Mutex
+	server := &milterServer{
+		MaxDeliveries: 1,
+		Deliver: func(msg *milterMessage) {
+			<-release
+			mu.Lock()
+			delivered = append(delivered, msg.QueueID)
+			mu.Unlock()
+		},
+		Skipped: func(msg *milterMessage) {
+			skipped = append(skipped, msg.QueueID)
+		},
+	}
+	server.deliver(&milterMessage{QueueID: "first"})
+	done := make(chan struct{})
+	go func() {
+		server.deliver(&milterMessage{QueueID: "second"})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("second delivery waited for the first")
+	}
+	release <- struct{}{}
+	server.wg.Wait()
+	server.deliver(&milterMessage{QueueID: "third"})
+	release <- struct{}{}
+	server.wg.Wait()
+	if strings.Join(delivered, " ") != "first third" || strings.Join(skipped, " ") != "second" {
+		t.Errorf("want first and third delivered and second skipped, got %q and %q", delivered, skipped)
+	}
+
+	// Nothing is sampled on a connection beyond MaxConnections
+	server.Sample = func(*milterMessage) bool { return true }
+	sess := &milterSession{server: server, macros: map[string]string{"i": "limited"}, limited: true}
+	sess.command(milterMail, []byte("<a@example.com>\x00"))
+	sess.command(milterEOH, nil)
+	if sess.sampled || skipped[len(skipped)-1] != "limited" {
+		t.Errorf("want sample on a limited connection skipped, got %q", skipped)
+	}
+}
+
+func TestMilterCmd_Sample(t *testing.T) {
+	cmd := &MilterCmd{Percent: 100, SenderDomain: []string{"Example.com"}, Header: []string{"X-Campaign: ^spring"}}
+	headers, err := parseHeaderMatches("--header", cmd.Header)
+	if err != nil {
+		t.Fatalf("parseHeaderMatches failed: %v", err)
+	}
+	tests := []struct {
+		from   string
+		header milterHeaderField
+		want   bool
+	}{
+		{"a@example.com", milterHeaderField{"x-campaign", " spring sale"}, true},
+		{"a@example.com", milterHeaderField{"X-Campaign", "autumn"}, false},
+		{"a@example.net", milterHeaderField{"X-Campaign", "spring"}, false},
+		{"a@example.com", milterHeaderField{"Subject", "spring"}, false},
+	}
+	for _, tt := range tests {
+		msg := &milterMessage{From: tt.from, Headers: []milterHeaderField{tt.header}}
+		if got := cmd.sample(msg, headers); got != tt.want {
+			t.Errorf("sample(%s, %v) = %v, want %v", tt.from, tt.header, got, tt.want)
+		}
+	}
+	cmd.Percent = 0
+	if cmd.sample(&milterMessage{From: "a@example.com", Headers: []milterHeaderField{{"X-Campaign", "spring"}}}, headers) {
+		t.Errorf("want nothing sampled at 0%%")
+	}
+	if _, err := parseHeaderMatches("--header", []string{"no colon"}); err == nil {
+		t.Errorf("want error for malformed --header")
+	}
+}
+
+func TestMilterCmd_Deliver(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	client := newClient(&Globals{Server: server.Endpoint, Quiet: true})
+	cmd := &MilterCmd{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	cmd.deliver(context.Background(), client, logger, &milterMessage{
+		QueueID: "ABC123", ClientIP: "192.0.2.7", Helo: "client.example.com",
+		From: "sender@example.com", To: []string{"one@example.org"}, Data: []byte("Subject: x\r\n\r\nbody\r\n"),
+	})
+	subs := server.Submissions()
+	if len(subs) != 1 {
+		t.Fatalf("want one submission, got %d", len(subs))
+	}
+	if sub := subs[0]; sub.From != "sender@example.com" || sub.To != "one@example.org" || sub.Ip != "192.0.2.7" || sub.Helo != "client.example.com" {
+		t.Errorf("want MTA envelope, got %+v", sub)
+	}
+}
+
+func TestMilterAddress(t *testing.T) {
+	tests := []struct{ in, network, address string }{
+		{"127.0.0.1:8890", "tcp", "127.0.0.1:8890"},
+		{"inet:8890@localhost", "tcp", "localhost:8890"},
+		{"inet:127.0.0.1:8890", "tcp", "127.0.0.1:8890"},
+		{"unix:/run/ame.sock", "unix", "/run/ame.sock"},
+		{"local:/run/ame.sock", "unix", "/run/ame.sock"},
+	}
+	for _, tt := range tests {
+		network, address := milterAddress(tt.in)
+		if network != tt.network || address != tt.address {
+			t.Errorf("milterAddress(%q) = %s %s, want %s %s", tt.in, network, address, tt.network, tt.address)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Milter protocol commands, from the MTA
+const (
+	milterAbort   = 'A'
+	milterBody    = 'B'
+	milterConnect = 'C'
+	milterMacro   = 'D'
+	milterEOB     = 'E'
+	milterHelo    = 'H'
+	milterQuitNC  = 'K'
+	milterHeader  = 'L'
+	milterMail    = 'M'
+	milterEOH     = 'N'
+	milterOptNeg  = 'O'
+	milterQuit    = 'Q'
+	milterRcpt    = 'R'
+	milterData    = 'T'
+	milterUnknown = 'U'
+)
+
+// Milter protocol responses, to the MTA
+const (
+	milterContinue = 'c'
+	milterSkip     = 's'
+)
+
+const (
+	// milterVersion is the protocol version we speak
+	milterVersion = 6
+	// milterProtoSkip is the protocol flag saying the MTA understands
+	// milterSkip in response to body chunks
+	milterProtoSkip = 0x400
+	// milterStageEOM is the protocol stage for requesting macros at end of message
+	milterStageEOM = 5
+	// milterMaxPacket is the largest packet we'll accept
+	milterMaxPacket = 1 << 20
+	// milterTimeout is how long we wait for the MTA to send each command
+	milterTimeout = 10 * time.Minute
+)
+
+// milterMessage is a message seen by the milter, with its envelope
+type milterMessage struct {
+	// QueueID is the MTA's queue id, from the "i" macro
+	QueueID string
+	// ClientIP is the address of the client that connected to the MTA
+	ClientIP string
+	// ClientName is the client's hostname as the MTA resolved it
+	ClientName string
+	Helo       string
+	From       string
+	To         []string
+	// Headers are the message header fields, in order
+	Headers []milterHeaderField
+	// Data is the message content, reassembled from its headers and body.
+	// It's only collected if Sample returns true.
+	Data []byte
+}
+
+type milterHeaderField struct {
+	Name  string
+	Value string
+}
+
+// milterServer is a milter that only watches. It never modifies, delays or
+// rejects mail: every command gets an immediate continue.
+type milterServer struct {
+	// Sample is called at the end of the headers, and decides whether the
+	// message should be collected and passed to Deliver
+	Sample func(msg *milterMessage) bool
+	// Deliver is called, in its own goroutine, with each sampled message.
+	Deliver func(msg *milterMessage)
+	// MaxSize is the largest message collected, larger ones aren't delivered
+	MaxSize int
+	// Skipped is called, if set, with each message that was sampled but
+	// isn't delivered because one of the limits below was reached
+	Skipped func(msg *milterMessage)
+	// MaxConnections and MaxDeliveries limit how many MTA connections are
+	// sampled from, and sampled messages delivered, at once. The MTA is
+	// never made to wait: connections beyond the limit are answered but
+	// nothing on them is sampled, and samples beyond it are skipped. Zero
+	// means no limit.
+	MaxConnections int
+	MaxDeliveries  int
+
+	wg         sync.WaitGroup
+	once       sync.Once
+	deliveries chan struct{}
+}
+
+// Serve accepts MTA connections on l until it's closed, then waits for the
+// sessions and deliveries in progress to finish
+func (m *milterServer) Serve(l net.Listener) error {
+	defer m.wg.Wait()
+	var connections chan struct{}
+	if m.MaxConnections > 0 {
+		connections = make(chan struct{}, m.MaxConnections)
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		limited := false
+		if connections != nil {
+			select {
+			case connections <- struct{}{}:
+			default:
+				limited = true
+			}
+		}
+		m.wg.Add(1)
+		go func() {
+			defer m.wg.Done()
+			if connections != nil && !limited {
+				defer func() {
+					<-connections
+				}()
+			}
+			defer func() {
+				_ = conn.Close()
+			}()
+			_ = m.handle(conn, limited)
+		}()
+	}
+}
+
+// deliver passes a message to Deliver in its own goroutine, or to Skipped
+// if MaxDeliveries are already in progress
+func (m *milterServer) deliver(msg *milterMessage) {
+	m.once.Do(func() {
+		if m.MaxDeliveries > 0 {
+			m.deliveries = make(chan struct{}, m.MaxDeliveries)
+		}
+	})
+	if m.deliveries != nil {
+		select {
+		case m.deliveries <- struct{}{}:
+		default:
+			m.skipped(msg)
+			return
+		}
+	}
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if m.deliveries != nil {
+			defer func() {
+				<-m.deliveries
+			}()
+		}
+		m.Deliver(msg)
+	}()
+}
+
+func (m *milterServer) skipped(msg *milterMessage) {
+	if m.Skipped != nil {
+		m.Skipped(msg)
+	}
+}
+
+// milterSession is the state of one MTA connection
+type milterSession struct {
+	server  *milterServer
+	r       *bufio.Reader
+	w       io.Writer
+	skip    bool
+	macros  map[string]string
+	conn    milterMessage
+	msg     *milterMessage
+	sampled bool
+	tooBig  bool
+	// limited is set if the connection is beyond MaxConnections, so
+	// nothing on it is sampled
+	limited bool
+	body    bytes.Buffer
+}
+
+func (m *milterServer) handle(conn net.Conn, limited bool) error {
+	sess := &milterSession{server: m, r: bufio.NewReader(conn), w: conn, macros: map[string]string{}, limited: limited}
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(milterTimeout))
+		cmd, data, err := readMilterPacket(sess.r)
+		if err != nil {
+			return err
+		}
+		reply, ok := sess.command(cmd, data)
+		if !ok {
+			return nil
+		}
+		if reply != nil {
+			if err := writeMilterPacket(sess.w, reply[0], reply[1:]); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// command handles one command, returning the reply to send, if any, and
+// false if the session is over
+func (sess *milterSession) command(cmd byte, data []byte) ([]byte, bool) {
+	cont := []byte{milterContinue}
+	switch cmd {
+	case milterOptNeg:
+		return sess.negotiate(data), true
+	case milterMacro:
+		if len(data) > 0 {
+			fields := splitNul(data[1:])
+			for i := 0; i+1 < len(fields); i += 2 {
+				sess.macros[strings.Trim(fields[i], "{}")] = fields[i+1]
+			}
+		}
+		return nil, true
+	case milterConnect:
+		sess.conn = milterMessage{}
+		sess.macros = map[string]string{}
+		name, rest, _ := bytes.Cut(data, []byte{0})
+		sess.conn.ClientName = string(name)
+		// family, port, address
+		if len(rest) >= 3 && (rest[0] == '4' || rest[0] == '6') {
+			addr, _, _ := bytes.Cut(rest[3:], []byte{0})
+			sess.conn.ClientIP = strings.TrimPrefix(string(addr), "IPv6:")
+		}
+		return cont, true
+	case milterHelo:
+		sess.conn.Helo = firstNul(data)
+		return cont, true
+	case milterMail:
+		msg := sess.conn
+		msg.From = strings.Trim(firstNul(data), "<>")
+		sess.msg = &msg
+		sess.sampled = false
+		sess.tooBig = false
+		sess.body.Reset()
+		return cont, true
+	case milterRcpt:
+		if sess.msg != nil {
+			sess.msg.To = append(sess.msg.To, strings.Trim(firstNul(data), "<>"))
+		}
+		return cont, true
+	case milterHeader:
+		if sess.msg != nil {
+			fields := splitNul(data)
+			if len(fields) >= 2 {
+				sess.msg.Headers = append(sess.msg.Headers, milterHeaderField{Name: fields[0], Value: fields[1]})
+			}
+		}
+		return cont, true
+	case milterEOH:
+		if sess.msg != nil {
+			sess.msg.QueueID = sess.macros["i"]
+			sess.sampled = sess.server.Sample(sess.msg)
+			if sess.sampled && sess.limited {
+				sess.server.skipped(sess.msg)
+				sess.sampled = false
+			}
+		}
+		return cont, true
+	case milterBody:
+		if !sess.sampled {
+			if sess.skip {
+				return []byte{milterSkip}, true
+			}
+			return cont, true
+		}
+		if sess.body.Len()+len(data) > sess.server.MaxSize {
+			sess.tooBig = true
+			sess.sampled = false
+			sess.body.Reset()
+			return cont, true
+		}
+		sess.body.Write(data)
+		return cont, true
+	case milterEOB:
+		if sess.msg != nil && sess.sampled && !sess.tooBig {
+			msg := sess.msg
+			if id := sess.macros["i"]; id != "" {
+				msg.QueueID = id
+			}
+			msg.Data = assembleMessage(msg.Headers, sess.body.Bytes())
+			sess.server.deliver(msg)
+		}
+		sess.msg = nil
+		sess.sampled = false
+		sess.body = bytes.Buffer{}
+		return cont, true
+	case milterAbort:
+		sess.msg = nil
+		sess.sampled = false
+		sess.body.Reset()
+		return nil, true
+	case milterQuit:
+		return nil, false
+	case milterQuitNC:
+		sess.conn = milterMessage{}
+		sess.msg = nil
+		sess.macros = map[string]string{}
+		return nil, true
+	case milterData, milterUnknown:
+		return cont, true
+	}
+	// Anything we don't know about gets a continue too, rather than risk
+	// holding up mail
+	return cont, true
+}
+
+// negotiate replies to the MTA's options: we want no actions, all the
+// protocol steps, and to skip the body of messages we're not sampling
+func (sess *milterSession) negotiate(data []byte) []byte {
+	version, protocol := uint32(milterVersion), uint32(0)
+	if len(data) >= 12 {
+		version = min(binary.BigEndian.Uint32(data[0:4]), milterVersion)
+		if offered := binary.BigEndian.Uint32(data[8:12]); offered&milterProtoSkip != 0 {
+			protocol |= milterProtoSkip
+			sess.skip = true
+		}
+	}
+	reply := []byte{milterOptNeg}
+	reply = binary.BigEndian.AppendUint32(reply, version)
+	reply = binary.BigEndian.AppendUint32(reply, 0)
+	reply = binary.BigEndian.AppendUint32(reply, protocol)
+	if version >= 6 {
+		// Ask for the queue id at end of message
+		reply = binary.BigEndian.AppendUint32(reply, milterStageEOM)
+		reply = append(reply, "i"...)
+		reply = append(reply, 0)
+	}
+	return reply
+}
+
+// assembleMessage rebuilds the message from the headers and body the MTA
+// sent. Header values come without the space after the colon, and with
+// bare line feeds in folded values.
+func assembleMessage(headers []milterHeaderField, body []byte) []byte {
+	var buf bytes.Buffer
+	for _, h := range headers {
+		value := strings.ReplaceAll(strings.ReplaceAll(h.Value, "\r\n", "\n"), "\n", "\r\n")
+		sep := ": "
+		if strings.HasPrefix(value, " ") || strings.HasPrefix(value, "\t") {
+			sep = ":"
+		}
+		buf.WriteString(h.Name + sep + value + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(body)
+	return buf.Bytes()
+}
+
+func readMilterPacket(r io.Reader) (byte, []byte, error) {
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return 0, nil, err
+	}
+	if length == 0 || length > milterMaxPacket {
+		return 0, nil, fmt.Errorf("bad milter packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, err
+	}
+	return packet[0], packet[1:], nil
+}
+
+func writeMilterPacket(w io.Writer, cmd byte, data []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(len(data)+1))
+	packet = append(packet, cmd)
+	packet = append(packet, data...)
+	_, err := w.Write(packet)
+	return err
+}
+
+// splitNul splits NUL-terminated strings
+func splitNul(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\x00")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\x00")
+}
+
+func firstNul(data []byte) string {
+	s, _, _ := bytes.Cut(data, []byte{0})
+	return string(s)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Names of the storages and streams in an Outlook .msg file (MS-OXMSG)
+const (
+	msgPropertyPrefix  = "__substg1.0_"
+	msgPropertyStream  = "__properties_version1.0"
+	msgRecipientPrefix = "__recip_version1.0_"
+	msgAttachPrefix    = "__attach_version1.0_"
+)
+
+// Sizes of the header of the property stream, which depend on what it's for
+const (
+	msgTopHeader      = 32
+	msgEmbeddedHeader = 24
+	msgChildHeader    = 8
+)
+
+// convertMsg converts an Outlook .msg file to a MIME message, returning it
+// and warnings about anything that couldn't be converted
+func convertMsg(data []byte) ([]byte, []string, error) {
+	f, err := openOLE(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	var warnings []string
+	msg, err := readMsgStorage(f, 0, msgTopHeader, map[int]bool{}, &warnings)
+	if err != nil {
+		return nil, warnings, err
+	}
+	return msg.build(), warnings, nil
+}
+
+// readMsgStorage reads the message in a storage, either the whole file or
+// an embedded message. enclosing holds the storages of the messages it's
+// embedded in.
+func readMsgStorage(f *oleFile, storage int, headerSize int, enclosing map[int]bool, warnings *[]string) (*outlookMessage, error) {
+	enclosing[storage] = true
+	defer delete(enclosing, storage)
+	props, children, err := readMsgProps(f, storage, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	codepage := props.codepage(0)
+	msg := &outlookMessage{}
+	msg.setFromProps(props, codepage)
+	if msg.headers == "" && msg.from == nil {
+		*warnings = append(*warnings, "message has no internet headers or sender address; From is missing")
+	}
+
+	for _, name := range sortedWithPrefix(children, msgRecipientPrefix) {
+		rp, _, err := readMsgProps(f, children[name], msgChildHeader)
+		if err != nil {
+			return nil, err
+		}
+		if w := msg.addRecipient(rp, codepage); w != "" {
+			*warnings = append(*warnings, w)
+		}
+	}
+
+	for _, name := range sortedWithPrefix(children, msgAttachPrefix) {
+		ap, attachChildren, err := readMsgProps(f, children[name], msgChildHeader)
+		if err != nil {
+			return nil, err
+		}
+		a, w := attachmentFromProps(ap, codepage)
+		if w != "" {
+			*warnings = append(*warnings, w)
+			continue
+		}
+		if method, _ := ap.num(prAttachMethod); method == attachEmbeddedMessage {
+			id, ok := attachChildren[msgPropName(prAttachData, ptObject)]
+			if !ok {
+				*warnings = append(*warnings, fmt.Sprintf("embedded message %q is missing, left out", a.name))
+				continue
+			}
+			if enclosing[id] {
+				*warnings = append(*warnings, fmt.Sprintf("embedded message %q contains itself, left out", a.name))
+				continue
+			}
+			a.message, err = readMsgStorage(f, id, msgEmbeddedHeader, enclosing, warnings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		msg.attachments = append(msg.attachments, a)
+	}
+
+	if _, w := msg.bodyParts(); w != "" {
+		*warnings = append(*warnings, w)
+	}
+	return msg, nil
+}
+
+func msgPropName(id, typ uint16) string {
+	return fmt.Sprintf("%s%04X%04X", msgPropertyPrefix, id, typ)
+}
+
+// readMsgProps reads the properties stored in a storage: variable length
+// ones each have a stream, fixed length ones are in the property stream
+func readMsgProps(f *oleFile, storage int, headerSize int) (mapiProps, map[string]int, error) {
+	children := f.children(storage)
+	props := mapiProps{}
+	for name, id := range children {
+		if !strings.HasPrefix(name, msgPropertyPrefix) || len(name) != len(msgPropertyPrefix)+8 {
+			continue
+		}
+		tag, err := strconv.ParseUint(name[len(msgPropertyPrefix):], 16, 32)
+		if err != nil {
+			continue
+		}
+		propID, typ := uint16(tag>>16), uint16(tag)
+		if typ&ptMulti != 0 || f.dir[id].typ != oleStream {
+			continue
+		}
+		data, err := f.read(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		if data == nil {
+			data = []byte{}
+		}
+		props[propID] = mapiValue{typ: typ, data: data}
+	}
+	if id, ok := children[msgPropertyStream]; ok {
+		stream, err := f.read(id)
+		if err != nil {
+			return nil, nil, err
+		}
+		for off := headerSize; off+16 <= len(stream); off += 16 {
+			typ := binary.LittleEndian.Uint16(stream[off:])
+			propID := binary.LittleEndian.Uint16(stream[off+2:])
+			if _, ok := props[propID]; ok || !fixedMAPIType(typ) {
+				continue
+			}
+			props[propID] = mapiValue{typ: typ, num: binary.LittleEndian.Uint64(stream[off+8:])}
+		}
+	}
+	return props, children, nil
+}
+
+func fixedMAPIType(typ uint16) bool {
+	switch typ {
+	case ptI2, ptLong, ptFloat, ptDouble, ptCurr, ptAppTime, ptError, ptBoolean, ptI8, ptSysTime:
+		return true
+	}
+	return false
+}
+
+// sortedWithPrefix returns the names starting with prefix, in order
+func sortedWithPrefix(children map[string]int, prefix string) []string {
+	var names []string
+	for name := range children {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
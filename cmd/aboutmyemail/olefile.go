@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+)
+
+// oleSignature starts every OLE compound file, such as an Outlook .msg
+var oleSignature = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+// Special sector numbers
+const (
+	oleMaxSector  = 0xfffffffa
+	oleEndOfChain = 0xfffffffe
+	oleNoStream   = 0xffffffff
+)
+
+// Directory entry types
+const (
+	oleStorage = 1
+	oleStream  = 2
+	oleRoot    = 5
+)
+
+// oleFile is a read-only OLE compound file (MS-CFB), a filesystem of
+// storages and streams in a single file
+type oleFile struct {
+	data           []byte
+	sectorSize     int
+	miniSectorSize int
+	miniCutoff     uint64
+	fat            []uint32
+	miniFAT        []uint32
+	miniStream     []byte
+	dir            []oleEntry
+}
+
+type oleEntry struct {
+	name  string
+	typ   byte
+	left  uint32
+	right uint32
+	child uint32
+	start uint32
+	size  uint64
+}
+
+func isOLE(data []byte) bool {
+	return bytes.HasPrefix(data, oleSignature)
+}
+
+func openOLE(data []byte) (*oleFile, error) {
+	if len(data) < 512 || !isOLE(data) {
+		return nil, errors.New("not an OLE compound file")
+	}
+	le := binary.LittleEndian
+	f := &oleFile{
+		data:           data,
+		sectorSize:     1 << le.Uint16(data[0x1e:]),
+		miniSectorSize: 1 << le.Uint16(data[0x20:]),
+		miniCutoff:     uint64(le.Uint32(data[0x38:])),
+	}
+	if f.sectorSize != 512 && f.sectorSize != 4096 || f.miniSectorSize != 64 {
+		return nil, fmt.Errorf("unsupported OLE sector sizes %d, %d", f.sectorSize, f.miniSectorSize)
+	}
+
+	// The FAT sectors are listed in the header, then in a chain of DIFAT sectors
+	fatSectors := make([]uint32, 0, 109)
+	for i := 0; i < 109; i++ {
+		fatSectors = append(fatSectors, le.Uint32(data[0x4c+4*i:]))
+	}
+	perSector := f.sectorSize/4 - 1
+	difat := le.Uint32(data[0x44:])
+	seen := map[uint32]bool{}
+	for n := le.Uint32(data[0x48:]); n > 0 && difat <= oleMaxSector; n-- {
+		if seen[difat] {
+			return nil, fmt.Errorf("OLE DIFAT chain loops at sector %d", difat)
+		}
+		seen[difat] = true
+		sector, err := f.sector(difat)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < perSector; i++ {
+			fatSectors = append(fatSectors, le.Uint32(sector[4*i:]))
+		}
+		difat = le.Uint32(sector[4*perSector:])
+	}
+	numFAT := int(le.Uint32(data[0x2c:]))
+	for _, s := range fatSectors {
+		if len(f.fat)/(f.sectorSize/4) >= numFAT || s > oleMaxSector {
+			break
+		}
+		sector, err := f.sector(s)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < f.sectorSize/4; i++ {
+			f.fat = append(f.fat, le.Uint32(sector[4*i:]))
+		}
+	}
+
+	dir, err := f.chain(le.Uint32(data[0x30:]), f.fat, f.sectorSize, f.sectorData)
+	if err != nil {
+		return nil, fmt.Errorf("can't read OLE directory: %w", err)
+	}
+	for off := 0; off+128 <= len(dir); off += 128 {
+		e := dir[off : off+128]
+		nameLen := min(int(le.Uint16(e[0x40:])), 64)
+		units := make([]uint16, 0, 32)
+		for i := 0; i+1 < nameLen; i += 2 {
+			if u := le.Uint16(e[i:]); u != 0 {
+				units = append(units, u)
+			}
+		}
+		f.dir = append(f.dir, oleEntry{
+			name:  string(utf16.Decode(units)),
+			typ:   e[0x42],
+			left:  le.Uint32(e[0x44:]),
+			right: le.Uint32(e[0x48:]),
+			child: le.Uint32(e[0x4c:]),
+			start: le.Uint32(e[0x74:]),
+			size:  le.Uint64(e[0x78:]),
+		})
+	}
+	if len(f.dir) == 0 || f.dir[0].typ != oleRoot {
+		return nil, errors.New("OLE file has no root storage")
+	}
+	if f.sectorSize == 512 {
+		// Version 3 files may have junk in the high half of the size
+		for i := range f.dir {
+			f.dir[i].size &= 0xffffffff
+		}
+	}
+
+	miniFAT, err := f.chain(le.Uint32(data[0x3c:]), f.fat, f.sectorSize, f.sectorData)
+	if err != nil {
+		return nil, fmt.Errorf("can't read OLE mini FAT: %w", err)
+	}
+	for i := 0; i+4 <= len(miniFAT); i += 4 {
+		f.miniFAT = append(f.miniFAT, le.Uint32(miniFAT[i:]))
+	}
+	root := f.dir[0]
+	f.miniStream, err = f.chain(root.start, f.fat, f.sectorSize, f.sectorData)
+	if err != nil {
+		return nil, fmt.Errorf("can't read OLE mini stream: %w", err)
+	}
+	return f, nil
+}
+
+func (f *oleFile) sector(n uint32) ([]byte, error) {
+	off := (int(n) + 1) * f.sectorSize
+	if n > oleMaxSector || off+f.sectorSize > len(f.data) {
+		// The last sector is often truncated
+		if off < len(f.data) && n <= oleMaxSector {
+			sector := make([]byte, f.sectorSize)
+			copy(sector, f.data[off:])
+			return sector, nil
+		}
+		return nil, fmt.Errorf("OLE sector %d out of range", n)
+	}
+	return f.data[off : off+f.sectorSize], nil
+}
+
+func (f *oleFile) sectorData(n uint32) ([]byte, error) {
+	return f.sector(n)
+}
+
+func (f *oleFile) miniSectorData(n uint32) ([]byte, error) {
+	off := int(n) * f.miniSectorSize
+	if off+f.miniSectorSize > len(f.miniStream) {
+		return nil, fmt.Errorf("OLE mini sector %d out of range", n)
+	}
+	return f.miniStream[off : off+f.miniSectorSize], nil
+}
+
+// chain reads a chain of sectors, following the allocation table
+func (f *oleFile) chain(start uint32, table []uint32, size int, read func(uint32) ([]byte, error)) ([]byte, error) {
+	var buf []byte
+	for n, steps := start, 0; n != oleEndOfChain && n != oleNoStream; steps++ {
+		if int(n) >= len(table) || steps > len(table) {
+			return nil, fmt.Errorf("broken OLE sector chain at %d", n)
+		}
+		sector, err := read(n)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, sector[:size]...)
+		n = table[n]
+	}
+	return buf, nil
+}
+
+// children returns the directory entries in a storage, by name
+func (f *oleFile) children(storage int) map[string]int {
+	found := map[string]int{}
+	// A damaged or crafted file can link entries in a loop, so each is
+	// visited at most once
+	seen := map[uint32]bool{}
+	pending := []uint32{f.dir[storage].child}
+	for len(pending) > 0 {
+		id := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if id == oleNoStream || int(id) >= len(f.dir) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		found[f.dir[id].name] = int(id)
+		pending = append(pending, f.dir[id].left, f.dir[id].right)
+	}
+	return found
+}
+
+// read returns the contents of a stream
+func (f *oleFile) read(id int) ([]byte, error) {
+	e := f.dir[id]
+	if e.typ != oleStream {
+		return nil, fmt.Errorf("OLE entry %q isn't a stream", e.name)
+	}
+	var buf []byte
+	var err error
+	if e.size < f.miniCutoff {
+		buf, err = f.chain(e.start, f.miniFAT, f.miniSectorSize, f.miniSectorData)
+	} else {
+		buf, err = f.chain(e.start, f.fat, f.sectorSize, f.sectorData)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(buf)) < e.size {
+		return nil, fmt.Errorf("OLE stream %q is truncated", e.name)
+	}
+	return buf[:e.size], nil
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"github.com/wttw/aboutmyemail"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// MAPI property types
+const (
+	ptI2      = 0x0002
+	ptLong    = 0x0003
+	ptFloat   = 0x0004
+	ptDouble  = 0x0005
+	ptCurr    = 0x0006
+	ptAppTime = 0x0007
+	ptError   = 0x000a
+	ptBoolean = 0x000b
+	ptObject  = 0x000d
+	ptI8      = 0x0014
+	ptString8 = 0x001e
+	ptUnicode = 0x001f
+	ptSysTime = 0x0040
+	ptCLSID   = 0x0048
+	ptBinary  = 0x0102
+	ptMulti   = 0x1000
+)
+
+// MAPI property ids used to build a MIME message
+const (
+	prSubject                = 0x0037
+	prClientSubmitTime       = 0x0039
+	prSentRepresentingName   = 0x0042
+	prSentRepresentingEmail  = 0x0065
+	prTransportHeaders       = 0x007d
+	prRecipientType          = 0x0c15
+	prSenderName             = 0x0c1a
+	prSenderEmail            = 0x0c1f
+	prMessageDeliveryTime    = 0x0e06
+	prBody                   = 0x1000
+	prRTFCompressed          = 0x1009
+	prHTML                   = 0x1013
+	prInternetMessageID      = 0x1035
+	prDisplayName            = 0x3001
+	prAddrType               = 0x3002
+	prEmailAddress           = 0x3003
+	prAttachData             = 0x3701
+	prAttachFilename         = 0x3704
+	prAttachMethod           = 0x3705
+	prAttachLongFilename     = 0x3707
+	prAttachMimeTag          = 0x370e
+	prAttachContentID        = 0x3712
+	prSMTPAddress            = 0x39fe
+	prInternetCPID           = 0x3fde
+	prMessageCodepage        = 0x3ffd
+	prSenderSMTPAddress      = 0x5d01
+	prSentRepresentingSMTP   = 0x5d02
+	attachByValue            = 1
+	attachEmbeddedMessage    = 5
+	attachOLE                = 6
+	recipientTo, recipientCc = 1, 2
+)
+
+// mapiValue is one MAPI property value, either variable length data or a
+// fixed size number
+type mapiValue struct {
+	typ  uint16
+	data []byte
+	num  uint64
+}
+
+// mapiProps are the MAPI properties of a message, recipient or attachment
+type mapiProps map[uint16]mapiValue
+
+func (p mapiProps) num(id uint16) (uint64, bool) {
+	v, ok := p[id]
+	return v.num, ok && v.data == nil
+}
+
+func (p mapiProps) bin(id uint16) []byte {
+	return p[id].data
+}
+
+// str returns a string property, decoding 8 bit strings with codepage
+func (p mapiProps) str(id uint16, codepage uint64) string {
+	v, ok := p[id]
+	if !ok {
+		return ""
+	}
+	switch v.typ {
+	case ptUnicode:
+		return decodeUTF16LE(v.data)
+	case ptString8:
+		return decodeCodepage(bytes.TrimRight(v.data, "\x00"), codepage)
+	}
+	return string(v.data)
+}
+
+// time returns a PT_SYSTIME property, a count of 100ns intervals since 1601
+func (p mapiProps) time(id uint16) time.Time {
+	v, ok := p.num(id)
+	if !ok || v == 0 {
+		return time.Time{}
+	}
+	const epochDelta = 116444736000000000
+	return time.Unix(0, 0).Add(time.Duration(int64(v)-epochDelta) * 100).UTC()
+}
+
+// codepage returns the codepage 8 bit strings are in
+func (p mapiProps) codepage(fallback uint64) uint64 {
+	for _, id := range []uint16{prInternetCPID, prMessageCodepage} {
+		if cp, ok := p.num(id); ok && cp != 0 {
+			return cp
+		}
+	}
+	return fallback
+}
+
+func decodeUTF16LE(data []byte) string {
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		units = append(units, binary.LittleEndian.Uint16(data[i:]))
+	}
+	return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+}
+
+// codepageNames are the character sets for common Windows codepages
+var codepageNames = map[uint64]string{
+	874: "windows-874", 932: "shift_jis", 936: "gbk", 949: "euc-kr", 950: "big5",
+	1250: "windows-1250", 1251: "windows-1251", 1252: "windows-1252", 1253: "windows-1253",
+	1254: "windows-1254", 1255: "windows-1255", 1256: "windows-1256", 1257: "windows-1257",
+	1258: "windows-1258", 20127: "us-ascii", 20866: "koi8-r", 21866: "koi8-u", 28591: "iso-8859-1",
+	28592: "iso-8859-2", 28605: "iso-8859-15", 50220: "iso-2022-jp", 51932: "euc-jp", 65001: "utf-8",
+}
+
+func charsetName(codepage uint64) string {
+	if name, ok := codepageNames[codepage]; ok {
+		return name
+	}
+	return "windows-1252"
+}
+
+func decodeCodepage(data []byte, codepage uint64) string {
+	if codepage == 0 && utf8.Valid(data) {
+		return string(data)
+	}
+	enc, err := htmlindex.Get(charsetName(codepage))
+	if err != nil {
+		return string(data)
+	}
+	decoded, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return string(data)
+	}
+	return string(decoded)
+}
+
+// outlookMessage is a message read from an Outlook .msg file or TNEF
+type outlookMessage struct {
+	// headers are the internet headers of a message that was received
+	headers     string
+	from        *mail.Address
+	to, cc      []*mail.Address
+	subject     string
+	date        time.Time
+	messageID   string
+	text        string
+	html        []byte
+	htmlCharset string
+	rtf         []byte
+	attachments []outlookAttachment
+}
+
+type outlookAttachment struct {
+	name        string
+	contentType string
+	contentID   string
+	data        []byte
+	// message is set instead of data for an embedded message
+	message *outlookMessage
+}
+
+// setFromProps fills in the message fields from its MAPI properties
+func (m *outlookMessage) setFromProps(p mapiProps, codepage uint64) {
+	m.headers = p.str(prTransportHeaders, codepage)
+	m.subject = p.str(prSubject, codepage)
+	m.messageID = p.str(prInternetMessageID, codepage)
+	m.date = p.time(prClientSubmitTime)
+	if m.date.IsZero() {
+		m.date = p.time(prMessageDeliveryTime)
+	}
+	name, addr := p.str(prSenderName, codepage), p.str(prSenderSMTPAddress, codepage)
+	if addr == "" {
+		addr = p.str(prSenderEmail, codepage)
+	}
+	if addr == "" {
+		name, addr = p.str(prSentRepresentingName, codepage), p.str(prSentRepresentingSMTP, codepage)
+		if addr == "" {
+			addr = p.str(prSentRepresentingEmail, codepage)
+		}
+	}
+	if addr != "" && strings.Contains(addr, "@") {
+		m.from = &mail.Address{Name: name, Address: addr}
+	}
+	m.text = p.str(prBody, codepage)
+	if v, ok := p[prHTML]; ok {
+		if v.typ == ptUnicode {
+			m.html, m.htmlCharset = []byte(decodeUTF16LE(v.data)), "utf-8"
+		} else {
+			m.html, m.htmlCharset = bytes.TrimRight(v.data, "\x00"), charsetName(codepage)
+		}
+	}
+	m.rtf = p.bin(prRTFCompressed)
+}
+
+// addRecipient adds a recipient from its MAPI properties, returning a
+// warning if it can't be given an internet address
+func (m *outlookMessage) addRecipient(p mapiProps, codepage uint64) string {
+	name, addr := p.str(prDisplayName, codepage), p.str(prSMTPAddress, codepage)
+	if addr == "" && !strings.EqualFold(p.str(prAddrType, codepage), "EX") {
+		addr = p.str(prEmailAddress, codepage)
+	}
+	if addr == "" {
+		return fmt.Sprintf("recipient %q has no internet address, left out", name)
+	}
+	rcpt := &mail.Address{Name: name, Address: addr}
+	switch kind, _ := p.num(prRecipientType); kind {
+	case recipientCc:
+		m.cc = append(m.cc, rcpt)
+	case recipientTo:
+		m.to = append(m.to, rcpt)
+	default:
+		// Bcc recipients aren't in the headers of a sent message
+	}
+	return ""
+}
+
+// attachmentFromProps makes an attachment from its MAPI properties, returning a
+// warning if it can't be converted
+func attachmentFromProps(p mapiProps, codepage uint64) (outlookAttachment, string) {
+	a := outlookAttachment{
+		name:        p.str(prAttachLongFilename, codepage),
+		contentType: p.str(prAttachMimeTag, codepage),
+		contentID:   p.str(prAttachContentID, codepage),
+	}
+	if a.name == "" {
+		a.name = p.str(prAttachFilename, codepage)
+	}
+	if a.name == "" {
+		a.name = p.str(prDisplayName, codepage)
+	}
+	if method, _ := p.num(prAttachMethod); method == attachOLE {
+		return a, fmt.Sprintf("attachment %q is an embedded OLE object, which can't be converted, left out", a.name)
+	}
+	a.data = p.bin(prAttachData)
+	return a, ""
+}
+
+// mimePart is a MIME body part, with its content already encoded
+type mimePart struct {
+	header [][2]string
+	body   []byte
+}
+
+func (p mimePart) bytes() []byte {
+	var buf bytes.Buffer
+	for _, h := range p.header {
+		buf.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	buf.WriteString("\r\n")
+	buf.Write(p.body)
+	return buf.Bytes()
+}
+
+func textPart(contentType string, text []byte) mimePart {
+	var buf bytes.Buffer
+	w := quotedprintable.NewWriter(&buf)
+	text, _ = aboutmyemail.NormalizeLineEndings(text)
+	_, _ = w.Write(text)
+	_ = w.Close()
+	return mimePart{
+		header: [][2]string{{"Content-Type", contentType}, {"Content-Transfer-Encoding", "quoted-printable"}},
+		body:   buf.Bytes(),
+	}
+}
+
+// base64Lines encodes data as base64 in 76 character lines
+func base64Lines(data []byte) []byte {
+	enc := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(enc) > 76 {
+		buf.WriteString(enc[:76] + "\r\n")
+		enc = enc[76:]
+	}
+	buf.WriteString(enc + "\r\n")
+	return buf.Bytes()
+}
+
+func (a outlookAttachment) part() mimePart {
+	if a.message != nil {
+		return mimePart{
+			header: [][2]string{{"Content-Type", "message/rfc822"}, {"Content-Disposition", "attachment"}},
+			body:   a.message.build(),
+		}
+	}
+	contentType := a.contentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(strings.ToLower(filepath.Ext(a.name)))
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, params = "application/octet-stream", map[string]string{}
+	}
+	disposition := "attachment"
+	if a.name != "" {
+		params["name"] = a.name
+		disposition = mime.FormatMediaType(disposition, map[string]string{"filename": a.name})
+	}
+	contentType = mime.FormatMediaType(mediaType, params)
+	var header [][2]string
+	header = append(header, [2]string{"Content-Type", contentType}, [2]string{"Content-Transfer-Encoding", "base64"},
+		[2]string{"Content-Disposition", disposition})
+	if a.contentID != "" {
+		header = append(header, [2]string{"Content-ID", "<" + strings.Trim(a.contentID, "<>") + ">"})
+	}
+	return mimePart{header: header, body: base64Lines(a.data)}
+}
+
+// multipartOf wraps parts in a multipart part
+func multipartOf(subtype string, parts []mimePart) mimePart {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, p := range parts {
+		// Writing the separators ourselves keeps the parts' header order
+		_, _ = fmt.Fprintf(&buf, "\r\n--%s\r\n", w.Boundary())
+		buf.Write(p.bytes())
+	}
+	_, _ = fmt.Fprintf(&buf, "\r\n--%s--\r\n", w.Boundary())
+	return mimePart{
+		header: [][2]string{{"Content-Type", fmt.Sprintf("multipart/%s; boundary=%q", subtype, w.Boundary())}},
+		body:   bytes.TrimPrefix(buf.Bytes(), []byte("\r\n")),
+	}
+}
+
+// bodyParts returns the parts making up the message body, and a warning if
+// it could only be kept as RTF
+func (m *outlookMessage) bodyParts() ([]mimePart, string) {
+	var alternatives []mimePart
+	if m.text != "" {
+		alternatives = append(alternatives, textPart("text/plain; charset=utf-8", []byte(m.text)))
+	}
+	if len(m.html) > 0 {
+		alternatives = append(alternatives, textPart(fmt.Sprintf("text/html; charset=%s", m.htmlCharset), m.html))
+	}
+	if len(alternatives) > 0 || len(m.rtf) == 0 {
+		return alternatives, ""
+	}
+	rtf, err := decompressRTF(m.rtf)
+	if err != nil {
+		return nil, fmt.Sprintf("message body is RTF that can't be decompressed: %s", err)
+	}
+	return []mimePart{outlookAttachment{name: "body.rtf", contentType: "application/rtf", data: rtf}.part()},
+		"message body is only available as RTF, attached as body.rtf"
+}
+
+// build returns the message as MIME. Internet headers from a received
+// message are kept, apart from the MIME ones, so that authentication
+// results can still be checked.
+func (m *outlookMessage) build() []byte {
+	body, _ := m.bodyParts()
+	var top mimePart
+	switch {
+	case len(body) == 0:
+		top = textPart("text/plain; charset=utf-8", nil)
+	case len(body) == 1:
+		top = body[0]
+	default:
+		top = multipartOf("alternative", body)
+	}
+	if len(m.attachments) > 0 {
+		parts := []mimePart{top}
+		for _, a := range m.attachments {
+			parts = append(parts, a.part())
+		}
+		top = multipartOf("mixed", parts)
+	}
+
+	var buf bytes.Buffer
+	if m.headers != "" {
+		buf.Write(withoutMIMEHeaders(m.headers))
+	} else {
+		if m.from != nil {
+			buf.WriteString("From: " + m.from.String() + "\r\n")
+		}
+		for _, h := range []struct {
+			name  string
+			addrs []*mail.Address
+		}{{"To", m.to}, {"Cc", m.cc}} {
+			if len(h.addrs) == 0 {
+				continue
+			}
+			var list []string
+			for _, a := range h.addrs {
+				list = append(list, a.String())
+			}
+			buf.WriteString(h.name + ": " + strings.Join(list, ",\r\n ") + "\r\n")
+		}
+		if m.subject != "" {
+			buf.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", m.subject) + "\r\n")
+		}
+		if !m.date.IsZero() {
+			buf.WriteString("Date: " + m.date.Format(time.RFC1123Z) + "\r\n")
+		}
+		if m.messageID != "" {
+			buf.WriteString("Message-ID: " + m.messageID + "\r\n")
+		}
+	}
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.Write(top.bytes())
+	return buf.Bytes()
+}
+
+// withoutMIMEHeaders returns header fields without the ones describing
+// the original MIME structure, which is being replaced
+func withoutMIMEHeaders(headers string) []byte {
+	var buf bytes.Buffer
+	skipping := false
+	crlf, _ := aboutmyemail.NormalizeLineEndings([]byte(headers))
+	for _, line := range strings.Split(string(crlf), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			name, _, _ := strings.Cut(line, ":")
+			name = strings.ToLower(strings.TrimSpace(name))
+			skipping = name == "mime-version" || strings.HasPrefix(name, "content-")
+		}
+		if !skipping {
+			buf.WriteString(line + "\r\n")
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+// oleNode is a storage or stream to write into a test OLE file
+type oleNode struct {
+	name     string
+	data     []byte
+	children []*oleNode
+}
+
+// buildOLE writes a minimal OLE compound file. Every stream goes in the
+// regular sectors, as the mini stream cutoff is set to zero.
+func buildOLE(root *oleNode) []byte {
+	le := binary.LittleEndian
+	type entry struct {
+		node        *oleNode
+		typ         byte
+		child, next uint32
+		start       uint32
+	}
+	var entries []*entry
+	var add func(n *oleNode, typ byte) int
+	add = func(n *oleNode, typ byte) int {
+		id := len(entries)
+		e := &entry{node: n, typ: typ, child: oleNoStream, next: oleNoStream, start: oleEndOfChain}
+		entries = append(entries, e)
+		prev := -1
+		for _, c := range n.children {
+			t := byte(oleStream)
+			if c.children != nil {
+				t = oleStorage
+			}
+			cid := add(c, t)
+			if prev < 0 {
+				e.child = uint32(cid)
+			} else {
+				entries[prev].next = uint32(cid)
+			}
+			prev = cid
+		}
+		return id
+	}
+	add(root, oleRoot)
+
+	var sectors []byte
+	var fat []uint32
+	chain := func(data []byte) uint32 {
+		if len(data) == 0 {
+			return oleEndOfChain
+		}
+		start := uint32(len(fat))
+		for off := 0; off < len(data); off += 512 {
+			sector := make([]byte, 512)
+			copy(sector, data[off:])
+			sectors = append(sectors, sector...)
+			fat = append(fat, uint32(len(fat)+1))
+		}
+		fat[len(fat)-1] = oleEndOfChain
+		return start
+	}
+	for _, e := range entries {
+		if e.typ == oleStream {
+			e.start = chain(e.node.data)
+		}
+	}
+	dir := make([]byte, 128*len(entries))
+	for i, e := range entries {
+		d := dir[128*i:]
+		units := utf16.Encode([]rune(e.node.name))
+		for j, u := range units {
+			le.PutUint16(d[2*j:], u)
+		}
+		le.PutUint16(d[0x40:], uint16(2*len(units)+2))
+		d[0x42] = e.typ
+		le.PutUint32(d[0x44:], oleNoStream)
+		le.PutUint32(d[0x48:], e.next)
+		le.PutUint32(d[0x4c:], e.child)
+		le.PutUint32(d[0x74:], e.start)
+		le.PutUint64(d[0x78:], uint64(len(e.node.data)))
+	}
+	dirStart := chain(dir)
+
+	// One FAT sector is plenty for a test
+	fatSector := uint32(len(fat))
+	fat = append(fat, 0xfffffffd)
+	fatBytes := make([]byte, 512)
+	for i := range fatBytes {
+		fatBytes[i] = 0xff
+	}
+	for i, v := range fat {
+		le.PutUint32(fatBytes[4*i:], v)
+	}
+	sectors = append(sectors, fatBytes...)
+
+	header := make([]byte, 512)
+	copy(header, oleSignature)
+	le.PutUint16(header[0x18:], 0x3e)
+	le.PutUint16(header[0x1a:], 3)
+	le.PutUint16(header[0x1c:], 0xfffe)
+	le.PutUint16(header[0x1e:], 9)
+	le.PutUint16(header[0x20:], 6)
+	le.PutUint32(header[0x2c:], 1)
+	le.PutUint32(header[0x30:], dirStart)
+	le.PutUint32(header[0x3c:], oleEndOfChain)
+	le.PutUint32(header[0x44:], oleEndOfChain)
+	for i := 0; i < 109; i++ {
+		le.PutUint32(header[0x4c+4*i:], oleNoStream)
+	}
+	le.PutUint32(header[0x4c:], fatSector)
+	return append(header, sectors...)
+}
+
+func utf16Bytes(s string) []byte {
+	var b []byte
+	for _, u := range utf16.Encode([]rune(s)) {
+		b = binary.LittleEndian.AppendUint16(b, u)
+	}
+	return b
+}
+
+func stringProp(id uint16, value string) *oleNode {
+	return &oleNode{name: msgPropName(id, ptUnicode), data: utf16Bytes(value)}
+}
+
+// fixedProps builds a property stream with a header of headerSize bytes
+func fixedProps(headerSize int, props map[uint16][2]uint64) *oleNode {
+	data := make([]byte, headerSize)
+	for id, v := range props {
+		entry := make([]byte, 16)
+		binary.LittleEndian.PutUint16(entry, uint16(v[0]))
+		binary.LittleEndian.PutUint16(entry[2:], id)
+		binary.LittleEndian.PutUint64(entry[8:], v[1])
+		data = append(data, entry...)
+	}
+	return &oleNode{name: msgPropertyStream, data: data}
+}
+
+func TestConvertMsg(t *testing.T) {
+	sent := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	filetime := uint64(sent.UnixNano()/100) + 116444736000000000
+	root := &oleNode{children: []*oleNode{
+		stringProp(prSubject, "Quarterly résumé"),
+		stringProp(prBody, "Hello\r\nWorld\r\n"),
+		stringProp(prSenderName, "Sender"),
+		stringProp(prSenderSMTPAddress, "sender@example.com"),
+		stringProp(prInternetMessageID, "<abc@example.com>"),
+		{name: msgPropName(prHTML, ptBinary), data: []byte("<p>Hello</p>")},
+		fixedProps(msgTopHeader, map[uint16][2]uint64{
+			prClientSubmitTime: {ptSysTime, filetime},
+			prInternetCPID:     {ptLong, 65001},
+		}),
+		{name: msgRecipientPrefix + "#00000000", children: []*oleNode{
+			stringProp(prDisplayName, "User"),
+			stringProp(prSMTPAddress, "user@example.org"),
+			fixedProps(msgChildHeader, map[uint16][2]uint64{prRecipientType: {ptLong, recipientTo}}),
+		}},
+		{name: msgRecipientPrefix + "#00000001", children: []*oleNode{
+			stringProp(prDisplayName, "Exchange Only"),
+			stringProp(prAddrType, "EX"),
+			stringProp(prEmailAddress, "/o=Org/ou=Exchange/cn=Recipients/cn=exonly"),
+			fixedProps(msgChildHeader, map[uint16][2]uint64{prRecipientType: {ptLong, recipientCc}}),
+		}},
+		{name: msgAttachPrefix + "#00000000", children: []*oleNode{
+			stringProp(prAttachLongFilename, "report.csv"),
+			stringProp(prAttachMimeTag, "text/csv"),
+			{name: msgPropName(prAttachData, ptBinary), data: []byte("a,b\r\n1,2\r\n")},
+			fixedProps(msgChildHeader, map[uint16][2]uint64{prAttachMethod: {ptLong, attachByValue}}),
+		}},
+	}}
+
+	data := buildOLE(root)
+	if !isOLE(data) {
+		t.Fatalf("test file isn't OLE")
+	}
+	converted, warnings, err := convertMsg(data)
+	if err != nil {
+		t.Fatalf("convertMsg failed: %v", err)
+	}
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "Exchange Only") {
+		t.Errorf("want warning about the Exchange recipient, got %q", warnings)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(converted))
+	if err != nil {
+		t.Fatalf("converted message doesn't parse: %v\n%s", err, converted)
+	}
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	date, _ := msg.Header.Date()
+	for name, tt := range map[string][2]string{
+		"From":       {msg.Header.Get("From"), `"Sender" <sender@example.com>`},
+		"To":         {msg.Header.Get("To"), `"User" <user@example.org>`},
+		"Subject":    {subject, "Quarterly résumé"},
+		"Message-ID": {msg.Header.Get("Message-ID"), "<abc@example.com>"},
+		"Date":       {date.UTC().String(), sent.String()},
+	} {
+		if tt[0] != tt[1] {
+			t.Errorf("want %s %q, got %q", name, tt[1], tt[0])
+		}
+	}
+
+	parts := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	want := []string{"text/plain", "text/html", "text/csv"}
+	if len(parts) != len(want) {
+		t.Fatalf("want parts %v, got %d", want, len(parts))
+	}
+	for i, p := range parts {
+		if !strings.HasPrefix(p.contentType, want[i]) {
+			t.Errorf("part %d: want %s, got %s", i, want[i], p.contentType)
+		}
+	}
+	if parts[0].body != "Hello\r\nWorld\r\n" || parts[2].body != "a,b\r\n1,2\r\n" {
+		t.Errorf("wrong part contents %q, %q", parts[0].body, parts[2].body)
+	}
+}
+
+type testPart struct {
+	contentType string
+	body        string
+}
+
+// readParts flattens a MIME body into its leaf parts, decoded
+func readParts(t *testing.T, contentType string, body io.Reader) []testPart {
+	t.Helper()
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("bad content type %q: %v", contentType, err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		b, _ := io.ReadAll(body)
+		return []testPart{{contentType, string(b)}}
+	}
+	var parts []testPart
+	r := multipart.NewReader(body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			return parts
+		}
+		if err != nil {
+			t.Fatalf("bad multipart: %v", err)
+		}
+		var content io.Reader = p
+		if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+			content = base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: p})
+		}
+		parts = append(parts, readParts(t, p.Header.Get("Content-Type"), content)...)
+	}
+}
+
+// tnefAttribute encodes one TNEF attribute
+func tnefAttribute(level byte, id uint32, value []byte) []byte {
+	b := []byte{level}
+	b = binary.LittleEndian.AppendUint32(b, id)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(value)))
+	b = append(b, value...)
+	var sum uint16
+	for _, c := range value {
+		sum += uint16(c)
+	}
+	return binary.LittleEndian.AppendUint16(b, sum)
+}
+
+type testProp struct {
+	id, typ uint16
+	value   []byte
+}
+
+// mapiBinary encodes MAPI properties as TNEF does
+func mapiBinary(props ...testProp) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(len(props)))
+	for _, p := range props {
+		b = binary.LittleEndian.AppendUint32(b, uint32(p.id)<<16|uint32(p.typ))
+		if p.typ == ptLong {
+			b = append(b, p.value...)
+			continue
+		}
+		b = binary.LittleEndian.AppendUint32(b, 1)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(p.value)))
+		b = append(b, p.value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	return b
+}
+
+// rtfExample is the compressed RTF example from MS-OXRTFCP
+var rtfExample = []byte{0x2d, 0, 0, 0, 0x2b, 0, 0, 0, 0x4c, 0x5a, 0x46, 0x75, 0xf1, 0xc5, 0xc7, 0xa7,
+	0x03, 0x00, 0x0a, 0x00, 0x72, 0x63, 0x70, 0x67, 0x31, 0x32, 0x35, 0x42, 0x32, 0x0a, 0xf3, 0x20,
+	0x68, 0x65, 0x6c, 0x09, 0x00, 0x20, 0x62, 0x77, 0x05, 0xb0, 0x6c, 0x64, 0x7d, 0x0a, 0x80, 0x0f, 0xa0}
+
+func TestOpenOLE_Loops(t *testing.T) {
+	le := binary.LittleEndian
+	root := &oleNode{}
+	for i := 0; i < 64; i++ {
+		root.children = append(root.children, &oleNode{name: fmt.Sprintf("s%d", i), data: []byte("x")})
+	}
+	data := buildOLE(root)
+
+	// Point every entry's left sibling back at the first, so the directory
+	// tree loops
+	dir := (int(le.Uint32(data[0x30:])) + 1) * 512
+	for off := dir + 128; off < dir+128*65; off += 128 {
+		le.PutUint32(data[off+0x44:], 1)
+	}
+	f, err := openOLE(data)
+	if err != nil {
+		t.Fatalf("openOLE failed: %v", err)
+	}
+	if found := f.children(0); len(found) != 64 {
+		t.Errorf("want 64 children, got %d", len(found))
+	}
+
+	// A DIFAT sector that links to itself
+	difat := bytes.Repeat([]byte{0xff}, 512)
+	sector := uint32(len(data)/512 - 1)
+	le.PutUint32(difat[508:], sector)
+	data = append(data, difat...)
+	le.PutUint32(data[0x44:], sector)
+	le.PutUint32(data[0x48:], 0xffffffff)
+	if _, err := openOLE(data); err == nil || !strings.Contains(err.Error(), "loops") {
+		t.Errorf("want DIFAT loop error, got %v", err)
+	}
+}
+
+func TestDecompressRTF(t *testing.T) {
+	if len(rtfPrefix) != 207 {
+		t.Fatalf("want 207 byte dictionary, got %d", len(rtfPrefix))
+	}
+	got, err := decompressRTF(rtfExample)
+	if want := "{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"; err != nil || string(got) != want {
+		t.Errorf("want %q, got %q, %v", want, got, err)
+	}
+
+	// Headers whose size doesn't match the data are errors, not panics
+	for _, compSize := range []uint32{0, 11, uint32(len(rtfExample))} {
+		bad := binary.LittleEndian.AppendUint32(nil, compSize)
+		bad = append(bad, rtfExample[4:]...)
+		if _, err := decompressRTF(bad); err == nil {
+			t.Errorf("compressed size %d: want error", compSize)
+		}
+	}
+}
+
+func TestUnpackTNEF(t *testing.T) {
+	tnef := binary.LittleEndian.AppendUint32(nil, tnefSignature)
+	tnef = append(tnef, 0x01, 0x00)
+	tnef = append(tnef, tnefAttribute(tnefLevelMessage, 0x00089006, []byte{0, 0, 1, 0})...)
+	tnef = append(tnef, tnefAttribute(tnefLevelMessage, attMessageClass, []byte("IPM.Note\x00"))...)
+	tnef = append(tnef, tnefAttribute(tnefLevelMessage, 0x00040001, []byte{1, 2, 3, 4})...)
+	tnef = append(tnef, tnefAttribute(tnefLevelMessage, attMsgProps, mapiBinary(testProp{prRTFCompressed, ptBinary, rtfExample}))...)
+	tnef = append(tnef, tnefAttribute(tnefLevelAttachment, attAttachRenddata, make([]byte, 14))...)
+	tnef = append(tnef, tnefAttribute(tnefLevelAttachment, attAttachTitle, []byte("REPORT~1.TXT\x00"))...)
+	tnef = append(tnef, tnefAttribute(tnefLevelAttachment, attAttachData, []byte("report contents"))...)
+	tnef = append(tnef, tnefAttribute(tnefLevelAttachment, attAttachment, mapiBinary(
+		testProp{prAttachLongFilename, ptUnicode, utf16Bytes("report.txt\x00")},
+		testProp{prAttachMethod, ptLong, []byte{attachByValue, 0, 0, 0}},
+	))...)
+
+	plain := "Content-Type: text/plain; charset=us-ascii\r\n\r\nSee attached\r\n"
+	message := "From: sender@example.com\r\nTo: user@example.org\r\nSubject: winmail\r\n" +
+		"MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+		"preamble\r\n--b1\r\n" + plain + "\r\n--b1\r\n" +
+		"Content-Type: application/ms-tnef; name=\"winmail.dat\"\r\nContent-Transfer-Encoding: base64\r\n\r\n" +
+		string(base64Lines(tnef)) + "\r\n--b1--\r\n"
+
+	got, notes := unpackTNEF([]byte(message))
+	if len(notes) != 3 || !strings.Contains(notes[0], "1 attachments") ||
+		!strings.Contains(notes[1], "0x00040001") || !strings.Contains(notes[2], "RTF") {
+		t.Errorf("want notes about unpacking, the unknown attribute and RTF, got %q", notes)
+	}
+	if !strings.Contains(string(got), "--b1\r\n"+plain+"\r\n--b1\r\n") {
+		t.Errorf("want the text part kept as it was, got\n%s", got)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("unpacked message doesn't parse: %v", err)
+	}
+	parts := readParts(t, msg.Header.Get("Content-Type"), msg.Body)
+	var summary []string
+	for _, p := range parts {
+		summary = append(summary, fmt.Sprintf("%s=%q", strings.Fields(p.contentType)[0], p.body))
+	}
+	want := []string{
+		`text/plain;="See attached\r\n"`,
+		`application/rtf;="{\\rtf1\\ansi\\ansicpg1252\\pard hello world}\r\n"`,
+		`text/plain;="report contents"`,
+	}
+	if strings.Join(summary, " ") != strings.Join(want, " ") {
+		t.Errorf("want parts %v, got %v", want, summary)
+	}
+
+	unchanged := "Content-Type: text/plain\r\n\r\nno tnef here\r\n"
+	if got, notes := unpackTNEF([]byte(unchanged)); string(got) != unchanged || notes != nil {
+		t.Errorf("want message without TNEF unchanged")
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"github.com/wttw/aboutmyemail"
+	"os"
+	"time"
+)
+
+const defaultServer = "https://api.aboutmy.email/api/v1"
+
+// Where the api key came from, in order of precedence
+const (
+	keyFromFile    = "file"
+	keyFromFlag    = "flag"
+	keyFromProfile = "profile"
+	keyFromStore   = "store"
+)
+
+// loadProfile fills in any settings not given as flags or environment
+// variables from the selected config file profile, then the defaults. The
+// api key is left to apiKey, so commands that don't use the API never read
+// a key file or query the credential store.
+func (g *Globals) loadProfile() error {
+	profile, err := aboutmyemail.LoadProfile(g.Config, g.Profile)
+	if err != nil {
+		return err
+	}
+	g.profile = profile
+	if g.Server == "" {
+		g.Server = profile.Server
+	}
+	if g.Server == "" {
+		g.Server = defaultServer
+	}
+	if g.CredentialHelper == "" {
+		g.CredentialHelper = profile.CredentialHelper
+	}
+	return nil
+}
+
+// explicitKey reads an api key given with --api-key-file, or notes one
+// given with --api-key or $MYEMAIL_APIKEY
+func (g *Globals) explicitKey() {
+	if g.keySource != "" {
+		return
+	}
+	switch {
+	case g.ApiKeyFile != "":
+		key, err := aboutmyemail.ReadAPIKey(g.ApiKeyFile, os.Stdin)
+		if err != nil {
+			fatal("Failed to read api key: %s", err)
+		}
+		g.ApiKey = key
+		g.keySource = keyFromFile
+	case g.ApiKey != "":
+		g.keySource = keyFromFlag
+	}
+}
+
+// apiKey returns the api key, looking it up the first time it's needed:
+// from the flags, then the profile, then the credential store
+func (g *Globals) apiKey() string {
+	if g.keyResolved {
+		return g.ApiKey
+	}
+	g.keyResolved = true
+	g.explicitKey()
+	switch {
+	case g.keySource != "":
+	case g.profile.ApiKey != "":
+		g.ApiKey = g.profile.ApiKey
+		g.keySource = keyFromProfile
+	default:
+		store, err := aboutmyemail.NewCredentialStore(g.CredentialHelper)
+		if err != nil {
+			printWarning("Can't read stored api key: %s", err)
+			return ""
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		key, err := store.Get(ctx, g.Server)
+		if err != nil {
+			printWarning("Can't read stored api key: %s", err)
+			return ""
+		}
+		if key != "" {
+			g.ApiKey = key
+			g.keySource = keyFromStore
+		}
+	}
+	return g.ApiKey
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"strings"
+)
+
+// receivedHop is the sending side of one Received header field
+type receivedHop struct {
+	// Index is the position of the field, 1 being the topmost (newest)
+	Index int
+	// Helo is the name the sender gave in HELO or EHLO
+	Helo string
+	// Rdns is the sender's reverse DNS name, as the receiver looked it up
+	Rdns string
+	// IP is the sender's address
+	IP string
+	// By is the receiver's name
+	By string
+}
+
+func (h receivedHop) String() string {
+	return fmt.Sprintf("from %s (%s [%s]) by %s", h.Helo, h.Rdns, h.IP, h.By)
+}
+
+// trustList is a set of hostnames and networks belonging to the receiver.
+// A hostname starting with a dot matches any name in that domain.
+type trustList struct {
+	hosts []string
+	nets  []*net.IPNet
+}
+
+func parseTrustList(entries []string) (trustList, error) {
+	var t trustList
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if ip := net.ParseIP(e); ip != nil {
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 128
+			}
+			t.nets = append(t.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		if strings.Contains(e, "/") {
+			_, ipnet, err := net.ParseCIDR(e)
+			if err != nil {
+				return t, fmt.Errorf("bad trusted network %q: %w", e, err)
+			}
+			t.nets = append(t.nets, ipnet)
+			continue
+		}
+		t.hosts = append(t.hosts, strings.TrimSuffix(e, "."))
+	}
+	return t, nil
+}
+
+func (t trustList) empty() bool {
+	return len(t.hosts) == 0 && len(t.nets) == 0
+}
+
+// matchHost returns the entry that a hostname matches, if any
+func (t trustList) matchHost(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return "", false
+	}
+	for _, h := range t.hosts {
+		if name == h || (strings.HasPrefix(h, ".") && strings.HasSuffix(name, h)) {
+			return h, true
+		}
+	}
+	return "", false
+}
+
+// matchIP returns the network that an address is in, if any
+func (t trustList) matchIP(addr string) (string, bool) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", false
+	}
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return n.String(), true
+		}
+	}
+	return "", false
+}
+
+// matchReceiver reports why the receiver of a hop is trusted, if it is
+func (t trustList) matchReceiver(hop receivedHop) (string, bool) {
+	if m, ok := t.matchHost(hop.By); ok {
+		return fmt.Sprintf("received by %s, which matches trusted %s", hop.By, m), true
+	}
+	if m, ok := t.matchIP(strings.Trim(hop.By, "[]")); ok {
+		return fmt.Sprintf("received by %s, which is in trusted %s", hop.By, m), true
+	}
+	return "", false
+}
+
+// matchSender reports whether the sender of a hop is trusted, making the
+// hop internal. The HELO name isn't used, as the sender chooses it.
+func (t trustList) matchSender(hop receivedHop) bool {
+	if _, ok := t.matchIP(hop.IP); ok {
+		return true
+	}
+	_, ok := t.matchHost(hop.Rdns)
+	return ok
+}
+
+// receivedHops parses the Received header fields of a message, newest first.
+// Fields that don't name the sender's address are skipped.
+func receivedHops(email []byte) ([]receivedHop, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(email))
+	if err != nil {
+		return nil, fmt.Errorf("can't parse message headers: %w", err)
+	}
+	var hops []receivedHop
+	for i, value := range msg.Header["Received"] {
+		hop, ok := parseReceived(value)
+		if !ok {
+			continue
+		}
+		hop.Index = i + 1
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// parseReceived parses the common formats of Received header field, e.g.
+//
+//	from helo.example.com (rdns.example.com [192.0.2.1]) by mx.example.org ...  (Postfix, Sendmail, Gmail)
+//	from rdns.example.com ([192.0.2.1] helo=helo.example.com) by mx.example.org ...  (Exim)
+//	from helo.example.com (2001:db8::1) by mx.example.org ...  (Exchange)
+func parseReceived(value string) (receivedHop, bool) {
+	var hop receivedHop
+	value = strings.Join(strings.Fields(value), " ")
+	if !strings.HasPrefix(strings.ToLower(value), "from ") {
+		return hop, false
+	}
+	value = value[len("from "):]
+	fromPart := value
+	if by := strings.Index(strings.ToLower(value), " by "); by >= 0 {
+		fromPart = value[:by]
+		fields := strings.Fields(value[by+len(" by "):])
+		if len(fields) > 0 {
+			hop.By = strings.TrimSuffix(strings.ToLower(strings.TrimRight(fields[0], ";")), ".")
+		}
+	}
+	first, comment, _ := strings.Cut(fromPart, " ")
+	hop.Helo = first
+	exim := false
+	for _, f := range strings.FieldsFunc(comment, func(r rune) bool { return r == ' ' || r == '(' || r == ')' }) {
+		switch {
+		case strings.HasPrefix(strings.ToLower(f), "helo="):
+			hop.Helo = f[len("helo="):]
+			exim = true
+		case strings.HasPrefix(f, "["):
+			addr, _, _ := strings.Cut(f[1:], "]")
+			addr = strings.TrimPrefix(strings.TrimPrefix(addr, "IPv6:"), "ipv6:")
+			if hop.IP == "" && net.ParseIP(addr) != nil {
+				hop.IP = addr
+			}
+		case net.ParseIP(f) != nil:
+			if hop.IP == "" {
+				hop.IP = f
+			}
+		case hop.Rdns == "" && strings.Contains(f, ".") && !strings.Contains(f, "="):
+			hop.Rdns = f
+		}
+	}
+	if hop.IP == "" {
+		// Some receivers give only the address, as the sender
+		addr := strings.Trim(first, "[]")
+		if net.ParseIP(strings.TrimPrefix(addr, "IPv6:")) == nil {
+			return hop, false
+		}
+		hop.IP = strings.TrimPrefix(addr, "IPv6:")
+	}
+	if exim {
+		hop.Rdns = first
+	}
+	if strings.EqualFold(hop.Rdns, "unknown") {
+		hop.Rdns = ""
+	}
+	hop.Rdns = strings.ToLower(strings.TrimSuffix(hop.Rdns, "."))
+	return hop, true
+}
+
+// chooseReceivedHop finds the hop that handed the message to the first of
+// our trusted receivers, returning it and an explanation of why it was
+// chosen. Hops above the topmost trusted receiver were added after the
+// message left us, and hops from trusted senders are internal, so both
+// are passed over. With no trusted receivers, the newest hop from a
+// public address is used.
+func chooseReceivedHop(hops []receivedHop, trust trustList) (receivedHop, string, error) {
+	if len(hops) == 0 {
+		return receivedHop{}, "", errors.New("no usable Received header fields in message")
+	}
+	if trust.empty() {
+		for _, hop := range hops {
+			ip := net.ParseIP(hop.IP)
+			if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+				continue
+			}
+			return hop, fmt.Sprintf("Received header %d is the newest from a public address; no trusted receivers given", hop.Index), nil
+		}
+		return receivedHop{}, "", errors.New("no Received header field is from a public address")
+	}
+	for i, hop := range hops {
+		why, ok := trust.matchReceiver(hop)
+		if !ok && i > 0 && trust.matchSender(hops[i-1]) {
+			// The hop above was sent from a trusted address, so this one
+			// was added by a trusted receiver
+			why, ok = fmt.Sprintf("received by %s, which sent the message on from trusted %s", hop.By, hops[i-1].IP), true
+		}
+		if !ok {
+			continue
+		}
+		for ; i < len(hops); i++ {
+			if !trust.matchSender(hops[i]) {
+				return hops[i], fmt.Sprintf("Received header %d: %s, from untrusted %s", hops[i].Index, why, hops[i].IP), nil
+			}
+			if i+1 < len(hops) {
+				why = fmt.Sprintf("received by %s from trusted %s", hops[i+1].By, hops[i].IP)
+			}
+		}
+		return receivedHop{}, "", errors.New("every sender in the Received chain below the trusted receiver is trusted")
+	}
+	return receivedHop{}, "", errors.New("no Received header field was added by a trusted receiver")
+}
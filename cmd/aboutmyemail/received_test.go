@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wttw/aboutmyemail"
+)
+
+func TestParseReceived(t *testing.T) {
+	tests := []struct {
+		value string
+		want  receivedHop
+	}{
+		{
+			"from mail.sender.example (out1.sender.example [203.0.113.5])\r\n\tby mx1.example.org (Postfix) with ESMTPS id 4Xy; Mon, 1 Jan 2024 00:00:00 +0000",
+			receivedHop{Helo: "mail.sender.example", Rdns: "out1.sender.example", IP: "203.0.113.5", By: "mx1.example.org"},
+		},
+		{
+			"from out1.sender.example ([203.0.113.5]:51234 helo=mail.sender.example) by mx.example.org with esmtps (Exim 4.96)",
+			receivedHop{Helo: "mail.sender.example", Rdns: "out1.sender.example", IP: "203.0.113.5", By: "mx.example.org"},
+		},
+		{
+			"from mail.sender.example (mail.sender.example. [2001:db8::5]) by mx.google.com with ESMTPS id x; Mon, 1 Jan 2024",
+			receivedHop{Helo: "mail.sender.example", Rdns: "mail.sender.example", IP: "2001:db8::5", By: "mx.google.com"},
+		},
+		{
+			"from EX1.corp.example (2001:db8::7) by EX2.corp.example (2001:db8::8) with Microsoft SMTP Server",
+			receivedHop{Helo: "EX1.corp.example", IP: "2001:db8::7", By: "ex2.corp.example"},
+		},
+		{
+			"from helo.example (unknown [IPv6:2001:db8::9]) by mx.example.org",
+			receivedHop{Helo: "helo.example", IP: "2001:db8::9", By: "mx.example.org"},
+		},
+	}
+	for _, tt := range tests {
+		got, ok := parseReceived(tt.value)
+		if !ok || got != tt.want {
+			t.Errorf("parseReceived(%q) = %+v, %v, want %+v", tt.value, got, ok, tt.want)
+		}
+	}
+	if _, ok := parseReceived("by mx.example.org (Postfix, from userid 1000) id 123"); ok {
+		t.Errorf("want local submission without sender address skipped")
+	}
+}
+
+const replayedMessage = "Received: by 2002:a05:1234 with SMTP id x; Tue, 2 Jan 2024 00:00:02 +0000\r\n" +
+	"Received: from mx1.example.org (mx1.example.org [198.51.100.10]) by mx.google.com with ESMTPS; Tue, 2 Jan 2024 00:00:01 +0000\r\n" +
+	"Received: from filter.example.org (filter.example.org [10.0.0.5]) by mx1.example.org (Postfix); Tue, 2 Jan 2024 00:00:01 +0000\r\n" +
+	"Received: from mail.sender.example (out1.sender.example [203.0.113.5]) by filter.example.org (Postfix); Tue, 2 Jan 2024 00:00:00 +0000\r\n" +
+	"Received: from laptop (unknown [192.168.1.20]) by mail.sender.example (Postfix); Tue, 2 Jan 2024 00:00:00 +0000\r\n" +
+	"From: sender@sender.example\r\n" +
+	"To: user@example.org\r\n" +
+	"\r\n" +
+	"body\r\n"
+
+func TestChooseReceivedHop(t *testing.T) {
+	hops, err := receivedHops([]byte(replayedMessage))
+	if err != nil {
+		t.Fatalf("receivedHops failed: %v", err)
+	}
+	if len(hops) != 4 || hops[0].Index != 2 {
+		t.Fatalf("want 4 hops starting with header 2, got %+v", hops)
+	}
+	tests := []struct {
+		name    string
+		trusted []string
+		wantIP  string
+	}{
+		{"hostname", []string{"mx1.example.org", "10.0.0.0/8"}, "203.0.113.5"},
+		{"domain", []string{".example.org", "10.0.0.5"}, "203.0.113.5"},
+		{"cidr", []string{"198.51.100.0/24", "10.0.0.0/8"}, "203.0.113.5"},
+		{"untrusted filter", []string{"mx1.example.org"}, "10.0.0.5"},
+		{"gmail", []string{"mx.google.com"}, "198.51.100.10"},
+		{"none", nil, "198.51.100.10"},
+	}
+	for _, tt := range tests {
+		trust, err := parseTrustList(tt.trusted)
+		if err != nil {
+			t.Fatalf("%s: parseTrustList failed: %v", tt.name, err)
+		}
+		hop, why, err := chooseReceivedHop(hops, trust)
+		if err != nil || hop.IP != tt.wantIP || why == "" {
+			t.Errorf("%s: got %s (%s), %v, want %s", tt.name, hop.IP, why, err, tt.wantIP)
+		}
+	}
+
+	trust, _ := parseTrustList([]string{"mx.other.example"})
+	if _, _, err := chooseReceivedHop(hops, trust); err == nil {
+		t.Errorf("want error when no trusted receiver is in the chain")
+	}
+	if _, err := parseTrustList([]string{"10.0.0.0/33"}); err == nil {
+		t.Errorf("want error for bad CIDR")
+	}
+}
+
+func TestEnvelopeResolveReceived(t *testing.T) {
+	profile := aboutmyemail.Profile{Ip: "192.0.2.99", TrustedReceivers: []string{"mx1.example.org", "10.0.0.0/8"}}
+	e, err := Envelope{Received: true}.resolve([]byte(replayedMessage), profile)
+	if err != nil {
+		t.Fatalf("resolve failed: %v", err)
+	}
+	if e.Ip != "203.0.113.5" || e.Helo != "mail.sender.example" || !strings.Contains(e.hop, "Received header 4") {
+		t.Errorf("want hop from header 4, got %s %s %q", e.Ip, e.Helo, e.hop)
+	}
+	e, err = Envelope{Received: true, Ip: "192.0.2.1"}.resolve([]byte(replayedMessage), profile)
+	if err != nil || e.Ip != "192.0.2.1" || e.Helo != "mail.sender.example" {
+		t.Errorf("want --ip to win over Received, got %s %s, %v", e.Ip, e.Helo, err)
+	}
+	if _, err := (Envelope{Received: true, Trusted: []string{"mx.other.example"}}).resolve([]byte(replayedMessage), profile); err == nil {
+		t.Errorf("want error rather than a guessed IP when no hop is found")
+	}
+}
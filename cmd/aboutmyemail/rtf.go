@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// rtfPrefix is the dictionary that compressed RTF starts with (MS-OXRTFCP)
+const rtfPrefix = "{\\rtf1\\ansi\\mac\\deff0\\deftab720{\\fonttbl;}{\\f0\\fnil \\froman " +
+	"\\fswiss \\fmodern \\fscript \\fdecor MS Sans SerifSymbolArialTimes New RomanCourier" +
+	"{\\colortbl\\red0\\green0\\blue0\r\n\\par \\pard\\plain\\f0\\fs20\\b\\i\\u\\tab\\tx"
+
+const (
+	rtfCompressed   = 0x75465a4c // "LZFu"
+	rtfUncompressed = 0x414c454d // "MELA"
+)
+
+// decompressRTF expands the compressed RTF that Outlook stores message
+// bodies as, in PR_RTF_COMPRESSED
+func decompressRTF(data []byte) ([]byte, error) {
+	if len(data) < 16 {
+		return nil, errors.New("compressed RTF too short")
+	}
+	le := binary.LittleEndian
+	compSize := int(le.Uint32(data[0:]))
+	rawSize := int(le.Uint32(data[4:]))
+	compType := le.Uint32(data[8:])
+	// compSize counts everything after itself
+	if compSize < 12 || compSize+4 > len(data) {
+		return nil, fmt.Errorf("compressed RTF size %d doesn't match its %d bytes", compSize, len(data))
+	}
+	body := data[16 : compSize+4]
+	switch compType {
+	case rtfUncompressed:
+		return body[:min(len(body), rawSize)], nil
+	case rtfCompressed:
+	default:
+		return nil, fmt.Errorf("unknown compressed RTF type %#x", compType)
+	}
+
+	var dict [4096]byte
+	copy(dict[:], rtfPrefix)
+	write := len(rtfPrefix)
+	// rawSize comes from the file, so isn't trusted further than the most
+	// the body could expand to
+	out := make([]byte, 0, min(rawSize, 8*len(body)))
+	for i := 0; i < len(body); {
+		control := body[i]
+		i++
+		for bit := 0; bit < 8 && i < len(body); bit++ {
+			if control&(1<<bit) == 0 {
+				out = append(out, body[i])
+				dict[write] = body[i]
+				write = (write + 1) % len(dict)
+				i++
+				continue
+			}
+			if i+1 >= len(body) {
+				return nil, errors.New("compressed RTF truncated")
+			}
+			ref := int(body[i])<<8 | int(body[i+1])
+			i += 2
+			offset, length := ref>>4, ref&0xf+2
+			if offset == write {
+				return out, nil
+			}
+			for j := 0; j < length; j++ {
+				b := dict[(offset+j)%len(dict)]
+				out = append(out, b)
+				dict[write] = b
+				write = (write + 1) % len(dict)
+			}
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wttw/aboutmyemail"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+)
+
+const envSendmailLog = "MYEMAIL_SENDMAIL_LOG"
+
+// envSendmailFollow is set, to a submission id, when sendmail is run to
+// wait for a result in the background
+const envSendmailFollow = "MYEMAIL_SENDMAIL_FOLLOW"
+
+// Exit statuses from sysexits.h, which is what programs calling sendmail expect
+const (
+	exUsage    = 64
+	exDataErr  = 65
+	exSoftware = 70
+	exTempFail = 75
+)
+
+// SendmailCmd takes its arguments raw, as the sendmail options it accepts
+// don't fit the usual flag syntax. It's also run if the binary is invoked
+// as "sendmail", e.g. via a symlink.
+type SendmailCmd struct {
+	Args []string `arg:"" optional:"" help:"Sendmail arguments: [-f sender] [-t] [-i] [-oi] [--raw] [recipient ...]"`
+}
+
+// sendmailOptions are the sendmail arguments we understand
+type sendmailOptions struct {
+	sender      string
+	recipients  []string
+	readHeaders bool
+	ignoreDots  bool
+	raw         bool
+}
+
+func (s *SendmailCmd) Run(globals *Globals) error {
+	logDest := globals.profile.SendmailLog
+	if env := os.Getenv(envSendmailLog); env != "" {
+		logDest = env
+	}
+	logger, closeLog := openLogger(logDest)
+	defer closeLog()
+	exit := func(status int, msg string, args ...any) {
+		logger.Error(msg, args...)
+		closeLog()
+		os.Exit(status)
+	}
+	if id := os.Getenv(envSendmailFollow); id != "" {
+		followSendmail(globals, logger, id)
+		return nil
+	}
+
+	opts, err := parseSendmailArgs(s.Args)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "aboutmyemail sendmail: %s\n", err)
+		exit(exUsage, "bad arguments", "error", err)
+	}
+	data, err := readSendmailInput(os.Stdin, opts.ignoreDots)
+	if err != nil {
+		exit(exSoftware, "failed to read message", "error", err)
+	}
+	if !opts.raw {
+		var changes []aboutmyemail.Change
+		data, changes = aboutmyemail.Normalize(data)
+		for _, change := range changes {
+			logger.Debug("normalized message", "change", change.String())
+		}
+	}
+	recipients := opts.recipients
+	if opts.readHeaders {
+		recipients = append(recipients, headerRecipients(data)...)
+		data = removeHeader(data, "Bcc")
+	}
+	if len(recipients) == 0 {
+		_, _ = fmt.Fprintln(os.Stderr, "aboutmyemail sendmail: no recipients given")
+		exit(exDataErr, "no recipients")
+	}
+
+	envelope, err := Envelope{From: opts.sender, To: recipients[0]}.resolve(data, globals.profile)
+	if err != nil {
+		exit(exDataErr, "bad envelope", "error", err)
+	}
+	if envelope.From == "" {
+		envelope.From = localSender(envelope.Helo)
+	}
+	client := newClient(globals)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+	response, err := submitEmail(ctx, client, envelope.request(data), data)
+	if err == nil {
+		err = aboutmyemail.CheckResponse(response.HTTPResponse, response.Body)
+	}
+	if err == nil && response.JSON200 == nil {
+		err = errors.New("unexpected nil result in response")
+	}
+	if err != nil {
+		exit(exTempFail, "submission failed", "from", envelope.From, "to", strings.Join(recipients, ","), "error", err)
+	}
+	id := response.JSON200.Id
+	logger.Info("submitted", "id", id, "from", envelope.From, "to", strings.Join(recipients, ","), "ip", envelope.Ip, "helo", envelope.Helo, "size", len(data))
+	// The caller shouldn't have to wait for the analysis, so a detached
+	// copy of this program waits for it and logs the result
+	follower, err := sendmailFollower(globals, id)
+	if err == nil {
+		err = follower.Start()
+		if stdin, ok := follower.Stdin.(*os.File); ok {
+			_ = stdin.Close()
+		}
+	}
+	if err != nil {
+		logger.Error("failed to start waiting for result", "id", id, "error", err)
+		return nil
+	}
+	_ = follower.Process.Release()
+	return nil
+}
+
+// sendmailFollower returns the command that waits for a submitted
+// message's result in the background. It's this program run as sendmail,
+// with the id in the environment so it follows rather than reading a
+// message. The settings the api key was found from are passed on so it
+// finds the same key, except that a key given directly is written to its
+// stdin rather than anywhere other processes can see it.
+func sendmailFollower(globals *Globals, id string) (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	env := append(os.Environ(), envSendmailFollow+"="+id, "MYEMAIL_SERVER="+globals.Server, "MYEMAIL_CREDENTIAL_HELPER="+globals.CredentialHelper)
+	if globals.Config != "" {
+		env = append(env, "MYEMAIL_CONFIG="+globals.Config)
+	}
+	if globals.Profile != "" {
+		env = append(env, "MYEMAIL_PROFILE="+globals.Profile)
+	}
+	var args []string
+	var stdin *os.File
+	switch {
+	case globals.keySource == keyFromFile && globals.ApiKeyFile != "-":
+		args = append(args, "--api-key-file", globals.ApiKeyFile)
+	case globals.keySource == keyFromFile || globals.keySource == keyFromFlag:
+		r, w, err := os.Pipe()
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.WriteString(w, globals.ApiKey+"\n")
+		_ = w.Close()
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		args = append(args, "--api-key-file", "-")
+		stdin = r
+	}
+	cmd := exec.Command(exe, append(args, "sendmail")...)
+	cmd.Env = env
+	if stdin != nil {
+		cmd.Stdin = stdin
+	}
+	return cmd, nil
+}
+
+// followSendmail waits for the result of a message submitted by an earlier
+// run and logs it
+func followSendmail(globals *Globals, logger *slog.Logger, id string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	result, err := newClient(globals).Wait(ctx, id)
+	if err != nil {
+		// The message was accepted, so this isn't the caller's problem
+		logger.Error("waiting for result failed", "id", id, "error", err)
+		return
+	}
+	logger.Info("processed", "id", id, "url", *result.Url)
+}
+
+// parseSendmailArgs parses sendmail's command line. Options that make no
+// sense here are accepted and ignored, modes other than delivering a
+// message (e.g. -bp, -bs) are an error.
+func parseSendmailArgs(args []string) (sendmailOptions, error) {
+	var opts sendmailOptions
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			opts.recipients = append(opts.recipients, args[i+1:]...)
+			break
+		}
+		if arg == "--raw" {
+			// Not a sendmail option: submit the message exactly as read
+			opts.raw = true
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			opts.recipients = append(opts.recipients, arg)
+			continue
+		}
+		// value returns the option's argument, either the rest of this arg or the next one
+		value := func() (string, error) {
+			if len(arg) > 2 {
+				return arg[2:], nil
+			}
+			if i+1 >= len(args) {
+				return "", fmt.Errorf("option %s needs a value", arg)
+			}
+			i++
+			return args[i], nil
+		}
+		var err error
+		switch arg[1] {
+		case 'f', 'r':
+			opts.sender, err = value()
+			opts.sender = strings.TrimSuffix(strings.TrimPrefix(opts.sender, "<"), ">")
+		case 't':
+			opts.readHeaders = true
+		case 'i':
+			opts.ignoreDots = true
+		case 'o':
+			if arg == "-oi" {
+				opts.ignoreDots = true
+			}
+			// Other -o options, e.g. -oem, -odb, are ignored. We always
+			// return once the message is submitted, as -odb asks.
+		case 'b':
+			if arg != "-bm" {
+				return opts, fmt.Errorf("mode %s not supported", arg)
+			}
+		case 'F', 'B', 'N', 'R', 'V', 'X', 'L', 'C', 'p', 'h':
+			// Options with values we ignore
+			_, err = value()
+		case 'v', 'm', 'U', 'n', 'G', 'q', 'A':
+			// Flags we ignore
+		default:
+			return opts, fmt.Errorf("unknown option %s", arg)
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// localSender is the default sender, as sendmail uses, of the current user
+// at this host
+func localSender(hostname string) string {
+	name := "nobody"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		name = u.Username
+	}
+	return name + "@" + hostname
+}
+
+// readSendmailInput reads the message from r. Unless ignoreDots is set a
+// line with just a period ends it, as in traditional sendmail.
+func readSendmailInput(r io.Reader, ignoreDots bool) ([]byte, error) {
+	if ignoreDots {
+		return io.ReadAll(r)
+	}
+	var buf bytes.Buffer
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadBytes('\n')
+		if string(line) == ".\n" || string(line) == ".\r\n" || (string(line) == "." && errors.Is(err, io.EOF)) {
+			return buf.Bytes(), nil
+		}
+		buf.Write(line)
+		if errors.Is(err, io.EOF) {
+			return buf.Bytes(), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// headerRecipients returns the addresses in the To, Cc and Bcc headers
+func headerRecipients(data []byte) []string {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, header := range []string{"To", "Cc", "Bcc"} {
+		addrs, err := msg.Header.AddressList(header)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			ret = append(ret, addr.Address)
+		}
+	}
+	return ret
+}
+
+// removeHeader removes every instance of the named header, including
+// continuation lines, from the message header section
+func removeHeader(data []byte, name string) []byte {
+	var out bytes.Buffer
+	removing := false
+	rest := data
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		rest = rest[end:]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// End of the header section
+			out.Write(line)
+			out.Write(rest)
+			break
+		}
+		if line[0] != ' ' && line[0] != '\t' {
+			field, _, found := bytes.Cut(line, []byte(":"))
+			removing = found && strings.EqualFold(strings.TrimSpace(string(field)), name)
+		}
+		if !removing {
+			out.Write(line)
+		}
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/wttw/aboutmyemail"
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+func TestParseSendmailArgs(t *testing.T) {
+	cases := []struct {
+		args []string
+		want sendmailOptions
+	}{
+		{[]string{"-t", "-i"}, sendmailOptions{readHeaders: true, ignoreDots: true}},
+		{[]string{"-f", "bounce@example.com", "-oi", "a@example.org", "b@example.org"},
+			sendmailOptions{sender: "bounce@example.com", ignoreDots: true, recipients: []string{"a@example.org", "b@example.org"}}},
+		{[]string{"-f<bounce@example.com>", "-FWeb App", "-odb", "-oem", "--", "-odd@example.org"},
+			sendmailOptions{sender: "bounce@example.com", recipients: []string{"-odd@example.org"}}},
+		{[]string{"--raw", "a@example.org"}, sendmailOptions{raw: true, recipients: []string{"a@example.org"}}},
+	}
+	for _, c := range cases {
+		got, err := parseSendmailArgs(c.args)
+		if err != nil || !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%v: want %+v, got %+v, %v", c.args, c.want, got, err)
+		}
+	}
+	for _, bad := range [][]string{{"-bp"}, {"-f"}, {"-Z"}} {
+		if _, err := parseSendmailArgs(bad); err == nil {
+			t.Errorf("%v: want error", bad)
+		}
+	}
+}
+
+func TestReadSendmailInput(t *testing.T) {
+	input := "Subject: x\n\nfirst\n.\nafter\n"
+	got, _ := readSendmailInput(strings.NewReader(input), false)
+	if string(got) != "Subject: x\n\nfirst\n" {
+		t.Errorf("want input to stop at dot, got %q", got)
+	}
+	got, _ = readSendmailInput(strings.NewReader(input), true)
+	if string(got) != input {
+		t.Errorf("with -i want all input, got %q", got)
+	}
+}
+
+func TestHeaderRecipients(t *testing.T) {
+	msg := []byte("To: One <one@example.org>, two@example.org\r\n" +
+		"Bcc: hidden@example.org,\r\n  also@example.org\r\n" +
+		"Cc: three@example.org\r\n" +
+		"Subject: Bcc: not a header\r\n\r\nBcc: body@example.org\r\n")
+	want := []string{"one@example.org", "two@example.org", "three@example.org", "hidden@example.org", "also@example.org"}
+	if got := headerRecipients(msg); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	stripped := string(removeHeader(msg, "bcc"))
+	wantStripped := "To: One <one@example.org>, two@example.org\r\n" +
+		"Cc: three@example.org\r\n" +
+		"Subject: Bcc: not a header\r\n\r\nBcc: body@example.org\r\n"
+	if stripped != wantStripped {
+		t.Errorf("want Bcc removed, got %q", stripped)
+	}
+}
+
+// The result is waited for by a separate process, given what it needs in
+// its environment
+func TestSendmailFollower(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	t.Setenv("MYEMAIL_APIKEY", "")
+
+	// A key given directly is passed on stdin, not in the environment
+	globals := &Globals{Server: server.Endpoint, ApiKey: "key", Quiet: true}
+	globals.apiKey()
+	cmd, err := sendmailFollower(globals, "test000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env := strings.Join(cmd.Env, "\n")
+	for _, want := range []string{envSendmailFollow + "=test000001", "MYEMAIL_SERVER=" + server.Endpoint} {
+		if !strings.Contains(env, want) {
+			t.Errorf("want %s in follower environment", want)
+		}
+	}
+	if strings.Contains(env, "MYEMAIL_APIKEY=key") {
+		t.Error("want api key kept out of the follower environment")
+	}
+	if strings.Join(cmd.Args[1:], " ") != "--api-key-file - sendmail" {
+		t.Errorf("want follower run as sendmail reading the key from stdin, got %q", cmd.Args)
+	}
+	stdin := cmd.Stdin.(*os.File)
+	if key, err := aboutmyemail.ReadAPIKey("-", stdin); err != nil || key != "key" {
+		t.Errorf("want key on follower stdin, got %q, %v", key, err)
+	}
+	_ = stdin.Close()
+
+	// A key from the profile is found again from the same settings
+	profiled := &Globals{Server: server.Endpoint, Config: "/etc/config.json", Profile: "p", profile: aboutmyemail.Profile{ApiKey: "key"}}
+	profiled.apiKey()
+	cmd, err = sendmailFollower(profiled, "test000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env = strings.Join(cmd.Env, "\n")
+	for _, want := range []string{"MYEMAIL_CONFIG=/etc/config.json", "MYEMAIL_PROFILE=p"} {
+		if !strings.Contains(env, want) {
+			t.Errorf("want %s in follower environment", want)
+		}
+	}
+	if cmd.Stdin != nil || strings.Join(cmd.Args[1:], " ") != "sendmail" {
+		t.Errorf("want follower to find the key itself, got %q", cmd.Args)
+	}
+
+	client := newClient(globals)
+	response, err := client.EmailWithResponse(context.Background(), Envelope{From: "a@example.com", To: "b@example.org", Ip: "192.0.2.1"}.request([]byte("Subject: x\r\n\r\nbody\r\n")))
+	if err != nil || response.JSON200 == nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	var buf bytes.Buffer
+	followSendmail(globals, slog.New(slog.NewTextHandler(&buf, nil)), response.JSON200.Id)
+	if !strings.Contains(buf.String(), "msg=processed id="+response.JSON200.Id) {
+		t.Errorf("want result logged, got %q", buf.String())
+	}
+}
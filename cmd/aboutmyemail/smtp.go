@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SmtpCmd struct {
+	Listen       string `help:"Address to listen on" default:":2525" placeholder:"address:port"`
+	Hostname     string `help:"Hostname to use in the SMTP greeting, default this host's name" placeholder:"host.name"`
+	Ip           string `help:"Submit with this IP address rather than the client's, e.g. when relaying via localhost" placeholder:"dotted-quad"`
+	Staged       bool   `help:"Display results using staged whitelabel configuration"`
+	Cert         string `help:"TLS certificate for STARTTLS, rather than a self-signed one" type:"existingfile" placeholder:"file.pem" and:"cert"`
+	Key          string `help:"Private key for --cert" type:"existingfile" placeholder:"file.pem" and:"cert"`
+	NoTls        bool   `help:"Don't offer STARTTLS"`
+	MaxSize      int    `help:"Largest message to accept, in bytes" default:"26214400"`
+	PerRecipient bool   `help:"Submit a copy of each message for every recipient, rather than once for the first"`
+
+	following sync.WaitGroup `kong:"-"`
+}
+
+func (s *SmtpCmd) Run(globals *Globals) error {
+	if s.Hostname == "" {
+		s.Hostname, _ = os.Hostname()
+	}
+	server := &smtpServer{Hostname: s.Hostname, MaxSize: s.MaxSize}
+	switch {
+	case s.NoTls:
+	case s.Cert != "":
+		cert, err := tls.LoadX509KeyPair(s.Cert, s.Key)
+		if err != nil {
+			fatal("Failed to load certificate: %s", err)
+		}
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	default:
+		var err error
+		server.TLSConfig, err = selfSignedTLS(s.Hostname)
+		if err != nil {
+			fatal("Failed to create certificate: %s", err)
+		}
+	}
+
+	client := newClient(globals)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	server.Deliver = func(msg *smtpMessage) (string, error) {
+		return s.deliver(ctx, globals, client, msg)
+	}
+
+	listener, err := net.Listen("tcp", s.Listen)
+	if err != nil {
+		fatal("Failed to listen on %s: %s", s.Listen, err)
+	}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+	}()
+	if !globals.Quiet {
+		blue := color.New(color.FgHiBlue).SprintFunc()
+		_, _ = fmt.Fprintf(color.Output, "Listening for SMTP on %s\n", blue(listener.Addr()))
+	}
+	if err := server.Serve(listener); err != nil {
+		fatal("SMTP server failed: %s", err)
+	}
+	s.following.Wait()
+	return nil
+}
+
+// deliver submits a message received over SMTP, with its real envelope,
+// and follows it in the background until the result is available
+func (s *SmtpCmd) deliver(ctx context.Context, globals *Globals, client *aboutmyemail.ClientWithResponses, msg *smtpMessage) (string, error) {
+	envelope := Envelope{From: msg.From, Ip: msg.ClientIP, Helo: msg.Helo, Ascii: !msg.Smtputf8, Staged: s.Staged}
+	if s.Ip != "" {
+		envelope.Ip = s.Ip
+	}
+	if envelope.From == "" {
+		// Bounces have an empty return path
+		envelope.From = "MAILER-DAEMON@" + envelope.Helo
+	}
+	recipients := msg.To[:1]
+	if s.PerRecipient {
+		recipients = msg.To
+	}
+	var ids []string
+	for _, to := range recipients {
+		envelope.To = to
+		submitCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		response, err := submitEmail(submitCtx, client, envelope.request(msg.Data), msg.Data)
+		cancel()
+		if err == nil {
+			err = aboutmyemail.CheckResponse(response.HTTPResponse, response.Body)
+		}
+		if err == nil && response.JSON200 == nil {
+			err = fmt.Errorf("unexpected nil result in response")
+		}
+		if err != nil {
+			printError("Failed to submit message from %s to %s: %s", msg.From, to, err)
+			if len(ids) == 0 {
+				err = fmt.Errorf("submission failed: %w", err)
+				if errors.Is(err, aboutmyemail.ErrBadRequest) || errors.Is(err, aboutmyemail.ErrTooLarge) {
+					// Retrying won't help, so don't have the client queue it
+					err = permanentError{err}
+				}
+				return "", err
+			}
+			continue
+		}
+		id := response.JSON200.Id
+		ids = append(ids, id)
+		if !globals.Quiet {
+			cyan := color.New(color.FgCyan).SprintFunc()
+			_, _ = fmt.Fprintf(color.Output, "%s: %s -> %s from %s (%s) processing ...\n", cyan(id), msg.From, to, envelope.Ip, envelope.Helo)
+		}
+		s.following.Add(1)
+		go s.follow(ctx, client, id)
+	}
+	return strings.Join(ids, " "), nil
+}
+
+// follow waits for a submitted message's result and prints it
+func (s *SmtpCmd) follow(ctx context.Context, client *aboutmyemail.ClientWithResponses, id string) {
+	defer s.following.Done()
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+	result, err := client.Wait(ctx, id)
+	if err != nil {
+		printError("%s: %s", id, err)
+		return
+	}
+	green := color.New(color.FgGreen).SprintFunc()
+	_, _ = fmt.Fprintf(color.Output, "%s: %s\n", green(id), *result.Url)
+}
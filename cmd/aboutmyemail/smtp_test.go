@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+// startSMTP runs an smtpServer on a local port until the test ends
+func startSMTP(t *testing.T, deliver func(*smtpMessage) (string, error)) string {
+	t.Helper()
+	tlsConfig, err := selfSignedTLS("mx.test")
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	server := &smtpServer{Hostname: "mx.test", TLSConfig: tlsConfig, MaxSize: 1000, Deliver: deliver}
+	done := make(chan struct{})
+	go func() {
+		_ = server.Serve(listener)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+func TestSMTPServer(t *testing.T) {
+	var mtx sync.Mutex
+	var received []*smtpMessage
+	addr := startSMTP(t, func(msg *smtpMessage) (string, error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		received = append(received, msg)
+		return "queued", nil
+	})
+
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Hello("client.example.com"); err != nil {
+		t.Fatalf("EHLO failed: %v", err)
+	}
+	for _, ext := range []string{"STARTTLS", "SMTPUTF8", "8BITMIME"} {
+		if ok, _ := c.Extension(ext); !ok {
+			t.Errorf("want %s offered", ext)
+		}
+	}
+	if err := c.StartTLS(&tls.Config{InsecureSkipVerify: true, ServerName: "mx.test"}); err != nil {
+		t.Fatalf("STARTTLS failed: %v", err)
+	}
+	if err := c.Mail("grå@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	for _, rcpt := range []string{"one@example.org", "two@example.org"} {
+		if err := c.Rcpt(rcpt); err != nil {
+			t.Fatalf("RCPT failed: %v", err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		t.Fatalf("DATA failed: %v", err)
+	}
+	body := "Subject: tëst\r\n\r\n.leading dot\r\nlone\nline feed\r\n"
+	_, _ = w.Write([]byte(body))
+	if err := w.Close(); err != nil {
+		t.Fatalf("end of DATA failed: %v", err)
+	}
+
+	// Too big
+	if err := c.Mail("a@example.com"); err != nil {
+		t.Fatalf("MAIL failed: %v", err)
+	}
+	_ = c.Rcpt("b@example.com")
+	w, _ = c.Data()
+	_, _ = w.Write([]byte(strings.Repeat("x", 2000) + "\r\n"))
+	if err := w.Close(); err == nil || !strings.HasPrefix(err.Error(), "552") {
+		t.Errorf("want 552 for large message, got %v", err)
+	}
+	_ = c.Quit()
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	if len(received) != 1 {
+		t.Fatalf("want 1 message, got %d", len(received))
+	}
+	msg := received[0]
+	if msg.Helo != "client.example.com" || msg.From != "grå@example.com" || strings.Join(msg.To, ",") != "one@example.org,two@example.org" {
+		t.Errorf("unexpected envelope %+v", msg)
+	}
+	if !msg.Smtputf8 || !msg.TLS || msg.ClientIP != "127.0.0.1" {
+		t.Errorf("want SMTPUTF8 over TLS from 127.0.0.1, got %+v", msg)
+	}
+	// net/smtp turns the bare LF into CRLF, and dot-stuffs the leading dot
+	want := "Subject: tëst\r\n\r\n.leading dot\r\nlone\r\nline feed\r\n"
+	if string(msg.Data) != want {
+		t.Errorf("data want %q, got %q", want, msg.Data)
+	}
+}
+
+func TestSMTPServer_Sequence(t *testing.T) {
+	addr := startSMTP(t, func(*smtpMessage) (string, error) { return "", nil })
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 4096)
+	read := func() string {
+		n, _ := conn.Read(buf)
+		return string(buf[:n])
+	}
+	read()
+	for _, step := range []struct{ send, want string }{
+		{"MAIL FROM:<a@example.com>", "503 "},
+		{"HELO client", "250 mx.test"},
+		{"MAIL FROM:<a@example.com> SIZE=10", "555 "},
+		{"RCPT TO:<b@example.com>", "503 "},
+		{"MAIL FROM:<a@example.com>", "250 "},
+		{"DATA", "503 "},
+		{"RCPT TO:<nodomain>", "501 "},
+		{"RSET", "250 "},
+		{"BOGUS", "500 "},
+		{"QUIT", "221 "},
+	} {
+		_, _ = conn.Write([]byte(step.send + "\r\n"))
+		if got := read(); !strings.HasPrefix(got, step.want) {
+			t.Errorf("%s: want %s, got %q", step.send, step.want, got)
+		}
+	}
+}
+
+// Messages are submitted with the SMTP envelope, not guesses
+func TestSmtpCmd_Deliver(t *testing.T) {
+	// No progress messages, so each result is ready on the first poll
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	globals := &Globals{Server: server.Endpoint, Quiet: true}
+	cmd := &SmtpCmd{}
+	client := newClient(globals)
+
+	id, err := cmd.deliver(context.Background(), globals, client, &smtpMessage{
+		Helo: "relay.example.com", ClientIP: "192.0.2.7", From: "bounce@example.com",
+		To: []string{"one@example.org", "two@example.org"}, Data: []byte("Subject: x\r\n\r\nbody\r\n"),
+	})
+	if err != nil {
+		t.Fatalf("deliver failed: %v", err)
+	}
+	cmd.following.Wait()
+	subs := server.Submissions()
+	if len(subs) != 1 || subs[0].ID != id {
+		t.Fatalf("want one submission %s, got %+v", id, subs)
+	}
+	sub := subs[0]
+	if sub.From != "bounce@example.com" || sub.To != "one@example.org" || sub.Ip != "192.0.2.7" || sub.Helo != "relay.example.com" {
+		t.Errorf("want real envelope, got %+v", sub)
+	}
+
+	cmd.PerRecipient = true
+	cmd.Ip = "198.51.100.1"
+	ids, err := cmd.deliver(context.Background(), globals, client, &smtpMessage{
+		Helo: "relay.example.com", ClientIP: "127.0.0.1", From: "bounce@example.com",
+		To: []string{"one@example.org", "two@example.org"}, Data: []byte("Subject: x\r\n\r\nbody\r\n"),
+	})
+	cmd.following.Wait()
+	if err != nil || len(strings.Fields(ids)) != 2 {
+		t.Fatalf("want two submissions, got %q, %v", ids, err)
+	}
+	subs = server.Submissions()
+	if subs[2].To != "two@example.org" || subs[2].Ip != "198.51.100.1" {
+		t.Errorf("want second recipient with --ip, got %+v", subs[2])
+	}
+}
+
+// Bounces are submitted from MAILER-DAEMON, and messages the API rejects
+// are refused permanently rather than left to be retried
+func TestSmtpCmd_DeliverBounce(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	globals := &Globals{Server: server.Endpoint, Quiet: true}
+	cmd := &SmtpCmd{}
+	client := newClient(globals)
+	addr := startSMTP(t, func(msg *smtpMessage) (string, error) {
+		return cmd.deliver(context.Background(), globals, client, msg)
+	})
+	if err := sendSMTP(addr, ""); err != nil {
+		t.Fatalf("bounce refused: %v", err)
+	}
+	cmd.following.Wait()
+	if subs := server.Submissions(); len(subs) != 1 || subs[0].From != "MAILER-DAEMON@relay.example.com" {
+		t.Errorf("want bounce from MAILER-DAEMON, got %+v", subs)
+	}
+
+	// The test server rejects submissions without an IP
+	_, err := cmd.deliver(context.Background(), globals, client, &smtpMessage{
+		Helo: "relay.example.com", From: "a@example.com", To: []string{"one@example.org"}, Data: []byte("Subject: x\r\n\r\nbody\r\n"),
+	})
+	var permanent permanentError
+	if !errors.As(err, &permanent) {
+		t.Errorf("want permanent error for a rejected submission, got %v", err)
+	}
+	addr = startSMTP(t, func(msg *smtpMessage) (string, error) {
+		return "", err
+	})
+	if err := sendSMTP(addr, "a@example.com"); err == nil || !strings.HasPrefix(err.Error(), "554") {
+		t.Errorf("want 554 for a permanent failure, got %v", err)
+	}
+}
+
+// sendSMTP sends a short message from relay.example.com without TLS
+func sendSMTP(addr, from string) error {
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.Close()
+	}()
+	if err := c.Hello("relay.example.com"); err != nil {
+		return err
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt("one@example.org"); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte("Subject: x\r\n\r\nbody\r\n")); err != nil {
+		return err
+	}
+	return w.Close()
+}
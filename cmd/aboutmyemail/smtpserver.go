@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// smtpMaxLine is the longest command line we accept, RFC 5321 says 512
+// but extension parameters can push that up
+const smtpMaxLine = 4096
+
+// smtpMaxRecipients is how many RCPT TOs we accept for one message
+const smtpMaxRecipients = 100
+
+// smtpTimeout is how long we wait for the client to send each command
+const smtpTimeout = 5 * time.Minute
+
+// smtpMessage is a message received over SMTP, with its envelope
+type smtpMessage struct {
+	// Helo is the name the client gave in HELO or EHLO
+	Helo string
+	// ClientIP is the address the client connected from
+	ClientIP string
+	// From is the MAIL FROM address, empty for the null sender
+	From string
+	// To is the RCPT TO addresses, in order
+	To []string
+	// Smtputf8 is set if the client asked for SMTPUTF8 or used non-ASCII addresses
+	Smtputf8 bool
+	// TLS is set if the message was sent after STARTTLS
+	TLS bool
+	// Data is the message content, exactly as sent but without dot-stuffing
+	Data []byte
+}
+
+// smtpServer is a minimal SMTP server that hands each message it accepts
+// to Deliver. It doesn't relay or queue anything itself.
+type smtpServer struct {
+	// Hostname is used in the greeting and EHLO response
+	Hostname string
+	// TLSConfig enables STARTTLS if it's not nil
+	TLSConfig *tls.Config
+	// MaxSize is the largest message accepted, in bytes
+	MaxSize int
+	// Deliver is called for each message received. The string it returns
+	// is added to the 250 response; an error gives a temporary failure,
+	// unless it's a permanentError.
+	Deliver func(msg *smtpMessage) (string, error)
+
+	wg sync.WaitGroup
+}
+
+// Serve accepts connections on l until it's closed, then waits for the
+// sessions in progress to finish
+func (s *smtpServer) Serve(l net.Listener) error {
+	defer s.wg.Wait()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+// smtpSession is the state of one SMTP connection
+type smtpSession struct {
+	server *smtpServer
+	conn   net.Conn
+	r      *bufio.Reader
+	w      *bufio.Writer
+	tls    bool
+	helo   string
+	esmtp  bool
+	msg    *smtpMessage
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	sess := &smtpSession{server: s}
+	sess.setConn(conn)
+	sess.reply(220, "%s ESMTP aboutmyemail", s.Hostname)
+	for {
+		_ = sess.conn.SetReadDeadline(time.Now().Add(smtpTimeout))
+		line, err := sess.readLine()
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				sess.reply(500, "5.5.2 Line too long")
+				continue
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.command(strings.ToUpper(verb), strings.TrimSpace(arg)) {
+			return
+		}
+	}
+}
+
+func (sess *smtpSession) setConn(conn net.Conn) {
+	sess.conn = conn
+	sess.r = bufio.NewReaderSize(conn, smtpMaxLine)
+	sess.w = bufio.NewWriter(conn)
+}
+
+var errLineTooLong = errors.New("line too long")
+
+// readLine reads a command line, without its line ending
+func (sess *smtpSession) readLine() (string, error) {
+	line, err := sess.r.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// Discard the rest of the line
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = sess.r.ReadSlice('\n')
+		}
+		if err != nil {
+			return "", err
+		}
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(line), "\r\n"), nil
+}
+
+// reply sends a single line response
+func (sess *smtpSession) reply(code int, format string, args ...any) {
+	_, _ = fmt.Fprintf(sess.w, "%d %s\r\n", code, fmt.Sprintf(format, args...))
+	_ = sess.w.Flush()
+}
+
+// command handles one SMTP command, returning false to end the session
+func (sess *smtpSession) command(verb, arg string) bool {
+	switch verb {
+	case "HELO", "EHLO":
+		if arg == "" {
+			sess.reply(501, "5.5.4 %s requires a domain", verb)
+			return true
+		}
+		sess.helo = arg
+		sess.esmtp = verb == "EHLO"
+		sess.msg = nil
+		if !sess.esmtp {
+			sess.reply(250, "%s", sess.server.Hostname)
+			return true
+		}
+		lines := []string{sess.server.Hostname, "PIPELINING", "8BITMIME", "SMTPUTF8", "ENHANCEDSTATUSCODES"}
+		if sess.server.TLSConfig != nil && !sess.tls {
+			lines = append(lines, "STARTTLS")
+		}
+		lines = append(lines, "SIZE "+strconv.Itoa(sess.server.MaxSize))
+		for i, l := range lines {
+			sep := "-"
+			if i == len(lines)-1 {
+				sep = " "
+			}
+			_, _ = fmt.Fprintf(sess.w, "250%s%s\r\n", sep, l)
+		}
+		_ = sess.w.Flush()
+	case "STARTTLS":
+		switch {
+		case sess.server.TLSConfig == nil:
+			sess.reply(502, "5.5.1 STARTTLS not available")
+		case sess.tls:
+			sess.reply(503, "5.5.1 Already using TLS")
+		case arg != "":
+			sess.reply(501, "5.5.4 STARTTLS takes no parameters")
+		default:
+			sess.reply(220, "2.0.0 Ready to start TLS")
+			tlsConn := tls.Server(sess.conn, sess.server.TLSConfig)
+			_ = tlsConn.SetDeadline(time.Now().Add(smtpTimeout))
+			if err := tlsConn.Handshake(); err != nil {
+				return false
+			}
+			_ = tlsConn.SetDeadline(time.Time{})
+			// RFC 3207 says to forget everything learned before TLS
+			sess.setConn(tlsConn)
+			sess.tls = true
+			sess.helo = ""
+			sess.esmtp = false
+			sess.msg = nil
+		}
+	case "MAIL":
+		sess.mail(arg)
+	case "RCPT":
+		sess.rcpt(arg)
+	case "DATA":
+		return sess.data(arg)
+	case "RSET":
+		sess.msg = nil
+		sess.reply(250, "2.0.0 OK")
+	case "NOOP":
+		sess.reply(250, "2.0.0 OK")
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot VRFY user, but will accept message")
+	case "HELP":
+		sess.reply(214, "2.0.0 This server submits messages to aboutmy.email for analysis")
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(500, "5.5.2 Command not recognized")
+	}
+	return true
+}
+
+func (sess *smtpSession) mail(arg string) {
+	if sess.helo == "" {
+		sess.reply(503, "5.5.1 Send HELO or EHLO first")
+		return
+	}
+	if sess.msg != nil {
+		sess.reply(503, "5.5.1 Nested MAIL command")
+		return
+	}
+	rest, ok := cutPrefixFold(arg, "FROM:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		return
+	}
+	from, params, err := parsePath(rest)
+	if err != nil {
+		sess.reply(501, "5.1.7 Bad sender address: %s", err)
+		return
+	}
+	if !sess.esmtp && params != "" {
+		sess.reply(555, "5.5.4 Parameters need EHLO")
+		return
+	}
+	msg := &smtpMessage{Helo: sess.helo, From: from, TLS: sess.tls, Smtputf8: needsUTF8(from)}
+	if host, _, err := net.SplitHostPort(sess.conn.RemoteAddr().String()); err == nil {
+		msg.ClientIP = host
+	}
+	for _, param := range strings.Fields(params) {
+		key, value, _ := strings.Cut(param, "=")
+		switch strings.ToUpper(key) {
+		case "SMTPUTF8":
+			msg.Smtputf8 = true
+		case "BODY":
+			if v := strings.ToUpper(value); v != "7BIT" && v != "8BITMIME" {
+				sess.reply(501, "5.5.4 Unsupported BODY type")
+				return
+			}
+		case "SIZE":
+			size, err := strconv.Atoi(value)
+			if err != nil {
+				sess.reply(501, "5.5.4 Bad SIZE")
+				return
+			}
+			if size > sess.server.MaxSize {
+				sess.reply(552, "5.3.4 Message too big")
+				return
+			}
+		default:
+			sess.reply(555, "5.5.4 Unsupported parameter %s", key)
+			return
+		}
+	}
+	sess.msg = msg
+	sess.reply(250, "2.1.0 OK")
+}
+
+func (sess *smtpSession) rcpt(arg string) {
+	if sess.msg == nil {
+		sess.reply(503, "5.5.1 Send MAIL first")
+		return
+	}
+	rest, ok := cutPrefixFold(arg, "TO:")
+	if !ok {
+		sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		return
+	}
+	to, params, err := parsePath(rest)
+	if err != nil || to == "" {
+		sess.reply(501, "5.1.3 Bad recipient address")
+		return
+	}
+	if params != "" {
+		sess.reply(555, "5.5.4 RCPT parameters not supported")
+		return
+	}
+	if len(sess.msg.To) >= smtpMaxRecipients {
+		sess.reply(452, "4.5.3 Too many recipients")
+		return
+	}
+	sess.msg.To = append(sess.msg.To, to)
+	if needsUTF8(to) {
+		sess.msg.Smtputf8 = true
+	}
+	sess.reply(250, "2.1.5 OK")
+}
+
+func (sess *smtpSession) data(arg string) bool {
+	if sess.msg == nil || len(sess.msg.To) == 0 {
+		sess.reply(503, "5.5.1 Send RCPT first")
+		return true
+	}
+	if arg != "" {
+		sess.reply(501, "5.5.4 DATA takes no parameters")
+		return true
+	}
+	sess.reply(354, "Go ahead, end with <CRLF>.<CRLF>")
+	msg := sess.msg
+	sess.msg = nil
+	var err error
+	msg.Data, err = readDotted(sess.r, sess.server.MaxSize)
+	if errors.Is(err, errTooBig) {
+		sess.reply(552, "5.3.4 Message too big")
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	result, err := sess.server.Deliver(msg)
+	var permanent permanentError
+	switch {
+	case errors.As(err, &permanent):
+		sess.reply(554, "5.6.0 %s", oneLine(err.Error()))
+		return true
+	case err != nil:
+		sess.reply(451, "4.3.0 %s", oneLine(err.Error()))
+		return true
+	}
+	sess.reply(250, "2.0.0 OK %s", result)
+	return true
+}
+
+var errTooBig = errors.New("message too big")
+
+// permanentError is returned by Deliver for a message that will never be
+// accepted, so it's rejected rather than left for the client to retry
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// readDotted reads dot-stuffed message data up to the terminating line,
+// removing the stuffing but otherwise leaving it untouched. If the message
+// is bigger than max the rest is read and discarded and errTooBig returned.
+func readDotted(r *bufio.Reader, max int) ([]byte, error) {
+	var buf bytes.Buffer
+	tooBig := false
+	atLineStart := true
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if atLineStart && chunk[0] == '.' {
+			if string(chunk) == ".\r\n" || string(chunk) == ".\n" {
+				if tooBig {
+					return nil, errTooBig
+				}
+				return buf.Bytes(), nil
+			}
+			chunk = chunk[1:]
+		}
+		atLineStart = chunk[len(chunk)-1] == '\n'
+		if buf.Len()+len(chunk) > max {
+			tooBig = true
+			buf.Reset()
+		}
+		if !tooBig {
+			buf.Write(chunk)
+		}
+	}
+}
+
+// parsePath parses "<address> params" from a MAIL or RCPT command
+func parsePath(s string) (string, string, error) {
+	s = strings.TrimLeft(s, " ")
+	if !strings.HasPrefix(s, "<") {
+		return "", "", errors.New("address must be in angle brackets")
+	}
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return "", "", errors.New("missing >")
+	}
+	addr := s[1:end]
+	// Strip any obsolete source route, <@a,@b:user@c>
+	if strings.HasPrefix(addr, "@") {
+		if colon := strings.IndexByte(addr, ':'); colon >= 0 {
+			addr = addr[colon+1:]
+		}
+	}
+	if addr != "" && !strings.Contains(addr, "@") {
+		return "", "", errors.New("address has no domain")
+	}
+	return addr, strings.TrimSpace(s[end+1:]), nil
+}
+
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return "", false
+	}
+	return s[len(prefix):], true
+}
+
+// needsUTF8 reports whether an address needs SMTPUTF8 to be sent
+func needsUTF8(addr string) bool {
+	for _, r := range addr {
+		if r > unicode.MaxASCII {
+			return true
+		}
+	}
+	return false
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// selfSignedTLS returns a TLS config with a freshly generated self-signed
+// certificate for hostname
+func selfSignedTLS(hostname string) (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert := tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
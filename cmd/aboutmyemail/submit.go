@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fatih/color"
+	"github.com/toqueteos/webbrowser"
+	"github.com/wttw/aboutmyemail"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+type SubmitCmd struct {
+	Envelope
+	Selection
+	Dkim
+	Email     string `arg:"" help:"File containing raw email, or an mbox file, Maildir or directory of .eml files to select one from" type:"path"`
+	Open      bool   `help:"Open result in browser"`
+	Callbacks string `help:"Start local webserver for callbacks" placeholder:"address:port"`
+	Report    bool   `help:"Print the machine-readable analysis report as JSON once processing completes"`
+}
+
+func (s *SubmitCmd) Run(globals *Globals) error {
+	messages, err := readMailbox(s.Email)
+	if err == nil {
+		messages, err = s.Selection.selected(messages)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	if len(messages) == 0 {
+		fatal("No messages in %s match the selection", s.Email)
+	}
+	if len(messages) > 1 {
+		fatal("%s has %d messages selected; choose one with --index, --message-id or --match, or submit them all with batch", s.Email, len(messages))
+	}
+	email := messages[0].Data
+	if !s.Raw {
+		email, err = importEmail("", email)
+		if err != nil {
+			fatal("%s", err)
+		}
+	}
+	email, signatures, err := s.Dkim.sign(email)
+	if err != nil {
+		fatal("%s", err)
+	}
+	envelope, err := s.Envelope.resolve(email, globals.profile)
+	if err != nil {
+		fatal("%s", err)
+	}
+	if !globals.Quiet {
+		envelope.print(len(email))
+		printSignatures(signatures)
+	}
+
+	client := newClient(globals)
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	request := envelope.request(email)
+	if s.Callbacks != "" {
+		s.callbackForResults(ctx, request, email, globals, client)
+		return nil
+	}
+	s.pollForResults(ctx, request, email, globals, client)
+	return nil
+}
+
+// callbackForResults starts a local webserver, submits the request with
+// callbacks to it and prints the status updates it receives
+func (s *SubmitCmd) callbackForResults(ctx context.Context, request aboutmyemail.Submit, email []byte, globals *Globals, client *aboutmyemail.ClientWithResponses) {
+	cyan := color.New(color.FgCyan).SprintFunc()
+	green := color.New(color.FgGreen).SprintFunc()
+	listener, err := net.Listen("tcp", s.Callbacks)
+	if err != nil {
+		fatal("Failed to start webserver on %s: %s", s.Callbacks, err)
+	}
+	receiver := aboutmyemail.NewCallbackReceiver()
+	mux := http.NewServeMux()
+	mux.Handle("/callback", receiver)
+	srv := http.Server{Handler: mux}
+	go func() {
+		_ = srv.Serve(listener)
+	}()
+	defer func() {
+		_ = srv.Shutdown(context.Background())
+	}()
+	defer receiver.Close()
+
+	sub, err := receiver.Subscribe("")
+	if err != nil {
+		fatal("Failed to subscribe to callbacks: %s", err)
+	}
+	url := fmt.Sprintf("http://%s/callback", s.Callbacks)
+	request.ProgressUrl = &url
+	request.FinishedUrl = &url
+	sub.Prepare(&request)
+
+	response, err := submitEmail(ctx, client, request, email)
+	if err != nil {
+		fatal("Failed to submit email: %s", err)
+	}
+	if err := aboutmyemail.CheckResponse(response.HTTPResponse, response.Body); err != nil {
+		fatal("%s", err)
+	}
+	if response.JSON200 == nil {
+		fatal("Unexpected nil result in response")
+	}
+	if !globals.Quiet {
+		_, _ = fmt.Fprintf(color.Output, "Processing %s ...\n", cyan(response.JSON200.Id))
+	}
+
+	counter := 0
+	for {
+		select {
+		case <-ctx.Done():
+			fatal("Timed out waiting for result")
+		case result, ok := <-sub.C:
+			if !ok {
+				fatal("Callback receiver closed before result arrived")
+			}
+			counter++
+			finished := result.Url != nil && *result.Url != ""
+			if result.Messages != nil && !globals.Quiet {
+				col := cyan
+				if finished {
+					col = green
+				}
+				for _, msg := range *result.Messages {
+					_, _ = fmt.Fprintf(color.Output, "%d:  %s\n", counter, col(msg))
+				}
+			}
+			if finished {
+				s.showResult(globals, *result.Url)
+				if s.Report {
+					printReport(ctx, client, result.Id)
+				}
+				return
+			}
+		}
+	}
+}
+
+// pollForResults submits the request and polls until the result is available
+func (s *SubmitCmd) pollForResults(ctx context.Context, request aboutmyemail.Submit, email []byte, globals *Globals, client *aboutmyemail.ClientWithResponses) {
+	cyan := color.New(color.FgCyan).SprintFunc()
+	yellow := color.New(color.FgYellow).SprintFunc()
+	var opts []aboutmyemail.WaitOption
+	if !globals.Quiet {
+		opts = append(opts,
+			aboutmyemail.WithSubmitted(func(id string) {
+				_, _ = fmt.Fprintf(color.Output, "Processing %s ...\n", cyan(id))
+			}),
+			aboutmyemail.WithProgress(func(msg string) {
+				_, _ = fmt.Fprintf(color.Output, "  %s\n", cyan(msg))
+			}))
+	}
+	opts = append(opts, aboutmyemail.WithThrottled(func(time.Duration) {
+		_, _ = fmt.Fprintf(color.Output, "%s\n", yellow("throttled, sleeping"))
+	}))
+	var result *aboutmyemail.StatusResult
+	var err error
+	if useForm(email) {
+		result, err = client.SubmitFormAndWait(ctx, request, bytes.NewReader(email), opts...)
+	} else {
+		result, err = client.SubmitAndWait(ctx, request, opts...)
+	}
+	if err != nil {
+		fatal("%s", err)
+	}
+	s.showResult(globals, *result.Url)
+	if s.Report {
+		printReport(ctx, client, result.Id)
+	}
+}
+
+// showResult prints the result url, or opens it in a browser
+func (s *SubmitCmd) showResult(globals *Globals, url string) {
+	if !globals.Quiet || !s.Open {
+		fmt.Printf("%s\n", url)
+	}
+	if s.Open {
+		err := webbrowser.Open(url)
+		if err != nil {
+			fatal("Failed to open browser: %s", err)
+		}
+	}
+}
+
+// printReport fetches the analysis report for a result and prints it as JSON
+func printReport(ctx context.Context, client *aboutmyemail.ClientWithResponses, id string) {
+	report, err := client.Report(ctx, id)
+	if err != nil {
+		fatal("Failed to fetch report: %s", err)
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(report)
+}
@@ -0,0 +1,13 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+)
+
+// openSyslog opens the mail log
+func openSyslog() (io.WriteCloser, error) {
+	return syslog.New(syslog.LOG_MAIL|syslog.LOG_INFO, "aboutmyemail")
+}
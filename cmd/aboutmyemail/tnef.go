@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+)
+
+const tnefSignature = 0x223e9f78
+
+// TNEF attribute levels
+const (
+	tnefLevelMessage    = 1
+	tnefLevelAttachment = 2
+)
+
+// TNEF attributes, with their type in the high 16 bits
+const (
+	attSubject        = 0x00018004
+	attMessageID      = 0x00018009
+	attBody           = 0x0002800c
+	attMessageClass   = 0x00078008
+	attMsgProps       = 0x00069003
+	attOemCodepage    = 0x00069007
+	attAttachRenddata = 0x00069002
+	attAttachTitle    = 0x00018010
+	attAttachData     = 0x0006800f
+	attAttachment     = 0x00069005
+)
+
+// tnefIgnored are attributes that describe how Outlook displays a message,
+// and don't need to be mapped
+var tnefIgnored = map[uint32]bool{
+	0x00089006: true, // attTnefVersion
+	0x00008000: true, // attFrom, also in the MAPI properties
+	0x00038005: true, // attDateSent
+	0x00038006: true, // attDateRecd
+	0x00038020: true, // attDateModified
+	0x00068007: true, // attMsgStatus
+	0x0004800d: true, // attPriority
+	0x0001800a: true, // attParentID
+	0x0001800b: true, // attConversationID
+	0x00038012: true, // attAttachCreateDate
+	0x00038013: true, // attAttachModifyDate
+	0x00068011: true, // attAttachMetaFile
+	0x00070600: true, // attOriginalMessageClass
+}
+
+// isTNEF reports whether a MIME part is TNEF, by its type or its name
+func isTNEF(contentType string, params map[string]string, disposition map[string]string) bool {
+	if strings.EqualFold(contentType, "application/ms-tnef") || strings.EqualFold(contentType, "application/vnd.ms-tnef") {
+		return true
+	}
+	return strings.EqualFold(params["name"], "winmail.dat") || strings.EqualFold(disposition["filename"], "winmail.dat")
+}
+
+// readTNEF reads a TNEF stream, as found in winmail.dat, returning the
+// message it encapsulates and warnings about anything that couldn't be
+// mapped
+func readTNEF(data []byte) (*outlookMessage, []string, error) {
+	le := binary.LittleEndian
+	if len(data) < 6 || le.Uint32(data) != tnefSignature {
+		return nil, nil, errors.New("not a TNEF stream")
+	}
+	var warnings []string
+	msg := &outlookMessage{}
+	var props mapiProps
+	var attachments []mapiProps
+	var titles, attachData [][]byte
+	var codepage uint64
+	var messageClass string
+
+	for off := 6; off < len(data); {
+		if off+9 > len(data) {
+			return nil, warnings, errors.New("TNEF stream truncated")
+		}
+		level := data[off]
+		id := le.Uint32(data[off+1:])
+		length := int(le.Uint32(data[off+5:]))
+		if length < 0 || off+9+length+2 > len(data) {
+			return nil, warnings, errors.New("TNEF stream truncated")
+		}
+		value := data[off+9 : off+9+length]
+		off += 9 + length + 2
+
+		switch {
+		case id == attAttachRenddata:
+			attachments = append(attachments, mapiProps{})
+			titles = append(titles, nil)
+			attachData = append(attachData, nil)
+		case level == tnefLevelAttachment && len(attachments) > 0:
+			n := len(attachments) - 1
+			switch id {
+			case attAttachTitle:
+				titles[n] = bytes.TrimRight(value, "\x00")
+			case attAttachData:
+				attachData[n] = value
+			case attAttachment:
+				p, err := parseMAPIProps(value)
+				if err != nil {
+					warnings = append(warnings, fmt.Sprintf("can't read attachment properties: %s", err))
+					continue
+				}
+				for k, v := range p {
+					attachments[n][k] = v
+				}
+			default:
+				if !tnefIgnored[id] {
+					warnings = append(warnings, fmt.Sprintf("TNEF attachment attribute %#08x not mapped", id))
+				}
+			}
+		case id == attOemCodepage:
+			if len(value) >= 4 {
+				codepage = uint64(le.Uint32(value))
+			}
+		case id == attMsgProps:
+			p, err := parseMAPIProps(value)
+			if err != nil {
+				return nil, warnings, fmt.Errorf("can't read TNEF message properties: %w", err)
+			}
+			props = p
+		case id == attSubject:
+			msg.subject = decodeCodepage(bytes.TrimRight(value, "\x00"), codepage)
+		case id == attMessageID:
+			msg.messageID = string(bytes.TrimRight(value, "\x00"))
+		case id == attBody:
+			msg.text = decodeCodepage(bytes.TrimRight(value, "\x00"), codepage)
+		case id == attMessageClass:
+			messageClass = string(bytes.TrimRight(value, "\x00"))
+		default:
+			if !tnefIgnored[id] {
+				warnings = append(warnings, fmt.Sprintf("TNEF attribute %#08x not mapped", id))
+			}
+		}
+	}
+
+	if messageClass != "" && !strings.HasPrefix(messageClass, "IPM.Note") && messageClass != "IPM.Microsoft Mail.Note" {
+		warnings = append(warnings, fmt.Sprintf("%s message details aren't mapped, only its body and attachments", messageClass))
+	}
+	if props != nil {
+		codepage = props.codepage(codepage)
+		subject, text, messageID := msg.subject, msg.text, msg.messageID
+		msg.setFromProps(props, codepage)
+		if msg.subject == "" {
+			msg.subject = subject
+		}
+		if msg.text == "" {
+			msg.text = text
+		}
+		if msg.messageID == "" {
+			msg.messageID = messageID
+		}
+	}
+
+	for n, p := range attachments {
+		a, w := attachmentFromProps(p, codepage)
+		if w != "" {
+			warnings = append(warnings, w)
+			continue
+		}
+		if a.name == "" {
+			a.name = decodeCodepage(titles[n], codepage)
+		}
+		if method, _ := p.num(prAttachMethod); method == attachEmbeddedMessage {
+			// The embedded message is TNEF itself, after a 16 byte interface id
+			obj := p.bin(prAttachData)
+			if len(obj) < 16 {
+				warnings = append(warnings, fmt.Sprintf("embedded message %q is missing, left out", a.name))
+				continue
+			}
+			embedded, w, err := readTNEF(obj[16:])
+			warnings = append(warnings, w...)
+			if err != nil {
+				warnings = append(warnings, fmt.Sprintf("embedded message %q can't be read, left out: %s", a.name, err))
+				continue
+			}
+			a.message, a.data = embedded, nil
+		} else if a.data == nil {
+			a.data = attachData[n]
+		}
+		msg.attachments = append(msg.attachments, a)
+	}
+	return msg, warnings, nil
+}
+
+// parseMAPIProps parses the MAPI properties encoded in a TNEF attribute
+func parseMAPIProps(data []byte) (mapiProps, error) {
+	le := binary.LittleEndian
+	r := bytes.NewReader(data)
+	read := func(n int) ([]byte, error) {
+		if n < 0 || n > r.Len() {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		_, _ = r.Read(b)
+		return b, nil
+	}
+	readUint32 := func() (uint32, error) {
+		b, err := read(4)
+		if err != nil {
+			return 0, err
+		}
+		return le.Uint32(b), nil
+	}
+	padded := func(n int) int { return (n + 3) &^ 3 }
+
+	count, err := readUint32()
+	if err != nil {
+		return nil, err
+	}
+	props := mapiProps{}
+	for i := uint32(0); i < count; i++ {
+		tag, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		typ, id := uint16(tag), uint16(tag>>16)
+		if id >= 0x8000 {
+			// Named property: a GUID, then an id or a name
+			if _, err := read(16); err != nil {
+				return nil, err
+			}
+			kind, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+			n, err := readUint32()
+			if err != nil {
+				return nil, err
+			}
+			if kind == 1 {
+				if _, err := read(padded(int(n))); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		values := uint32(1)
+		multi := typ&ptMulti != 0
+		base := typ &^ ptMulti
+		variable := base == ptString8 || base == ptUnicode || base == ptBinary || base == ptObject
+		if multi || variable {
+			if values, err = readUint32(); err != nil {
+				return nil, err
+			}
+		}
+		for v := uint32(0); v < values; v++ {
+			var value mapiValue
+			switch {
+			case variable:
+				n, err := readUint32()
+				if err != nil {
+					return nil, err
+				}
+				b, err := read(padded(int(n)))
+				if err != nil {
+					return nil, err
+				}
+				value = mapiValue{typ: base, data: b[:n]}
+			default:
+				size := 4
+				switch base {
+				case ptDouble, ptCurr, ptAppTime, ptI8, ptSysTime:
+					size = 8
+				case ptCLSID:
+					size = 16
+				}
+				b, err := read(size)
+				if err != nil {
+					return nil, err
+				}
+				value = mapiValue{typ: base}
+				if size == 8 {
+					value.num = le.Uint64(b)
+				} else if size == 4 {
+					value.num = uint64(le.Uint32(b))
+				}
+			}
+			if v == 0 && !multi && id < 0x8000 {
+				props[id] = value
+			}
+		}
+	}
+	return props, nil
+}
+
+// unpackTNEF replaces TNEF parts (winmail.dat) in a MIME message with the
+// body and attachments they hold, returning the new message and a
+// description of each change and anything that couldn't be mapped. A
+// message without TNEF is returned unchanged.
+func unpackTNEF(data []byte) ([]byte, []string) {
+	headerEnd, nl := endOfHeader(data)
+	if headerEnd < 0 {
+		return data, nil
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return data, nil
+	}
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return data, nil
+	}
+	body, notes, changed := unpackMultipart(data[headerEnd:], params["boundary"], nl)
+	if !changed {
+		return data, notes
+	}
+	return append(append([]byte{}, data[:headerEnd]...), body...), notes
+}
+
+// endOfHeader returns where the body of a message or part starts, and the
+// line ending it uses
+func endOfHeader(data []byte) (int, string) {
+	if i := bytes.Index(data, []byte("\r\n\r\n")); i >= 0 {
+		if j := bytes.Index(data, []byte("\n\n")); j < 0 || j > i {
+			return i + 4, "\r\n"
+		}
+	}
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		return i + 2, "\n"
+	}
+	return -1, ""
+}
+
+// unpackMultipart unpacks the TNEF parts in a multipart body, recursing into
+// nested multiparts, and reports whether anything was changed
+func unpackMultipart(body []byte, boundary string, nl string) ([]byte, []string, bool) {
+	delim := []byte("--" + boundary)
+	var out bytes.Buffer
+	var notes []string
+	changed := false
+	// Find the delimiter lines, keeping the raw bytes between them
+	type line struct{ start, end int }
+	var delims []line
+	closed := -1
+	for off := 0; off < len(body); {
+		end := bytes.IndexByte(body[off:], '\n')
+		if end < 0 {
+			end = len(body)
+		} else {
+			end += off + 1
+		}
+		l := bytes.TrimRight(body[off:end], " \t\r\n")
+		if bytes.HasPrefix(l, delim) {
+			rest := l[len(delim):]
+			if bytes.Equal(rest, []byte("--")) {
+				closed = len(delims)
+				delims = append(delims, line{off, end})
+				break
+			}
+			if len(rest) == 0 {
+				delims = append(delims, line{off, end})
+			}
+		}
+		off = end
+	}
+	if closed < 0 || len(delims) < 2 {
+		return body, nil, false
+	}
+	out.Write(body[:delims[0].end])
+	for i := 0; i+1 < len(delims); i++ {
+		part := body[delims[i].end:delims[i+1].start]
+		// The line ending before the next delimiter belongs to it
+		content := bytes.TrimSuffix(bytes.TrimSuffix(part, []byte("\n")), []byte("\r"))
+		replaced, partNotes, ok := unpackPart(content, nl)
+		notes = append(notes, partNotes...)
+		if !ok {
+			out.Write(part)
+		} else {
+			changed = true
+			for j, p := range replaced {
+				if j > 0 {
+					out.WriteString(string(delim) + nl)
+				}
+				out.Write(p)
+				out.WriteString(nl)
+			}
+		}
+		out.Write(body[delims[i+1].start:delims[i+1].end])
+	}
+	out.Write(body[delims[closed].end:])
+	return out.Bytes(), notes, changed
+}
+
+// unpackPart returns the parts a TNEF part should be replaced with, and
+// false if it should be left alone
+func unpackPart(part []byte, nl string) ([][]byte, []string, bool) {
+	headerEnd, _ := endOfHeader(part)
+	if headerEnd < 0 {
+		return nil, nil, false
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(part))
+	if err != nil {
+		return nil, nil, false
+	}
+	mediaType, params, _ := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+		body, notes, changed := unpackMultipart(part[headerEnd:], params["boundary"], nl)
+		if !changed {
+			return nil, notes, false
+		}
+		return [][]byte{append(append([]byte{}, part[:headerEnd]...), body...)}, notes, true
+	}
+	_, disposition, _ := mime.ParseMediaType(msg.Header.Get("Content-Disposition"))
+	if !isTNEF(mediaType, params, disposition) {
+		return nil, nil, false
+	}
+
+	var content []byte
+	switch strings.ToLower(msg.Header.Get("Content-Transfer-Encoding")) {
+	case "base64":
+		content, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, &newlineStripper{r: msg.Body}))
+	case "quoted-printable":
+		content, err = io.ReadAll(quotedprintable.NewReader(msg.Body))
+	default:
+		content, err = io.ReadAll(msg.Body)
+	}
+	if err != nil {
+		return nil, []string{fmt.Sprintf("can't decode winmail.dat, left as it was: %s", err)}, false
+	}
+	tnef, warnings, err := readTNEF(content)
+	if err != nil {
+		return nil, append(warnings, fmt.Sprintf("can't read winmail.dat, left as it was: %s", err)), false
+	}
+	notes := []string{fmt.Sprintf("unpacked winmail.dat into %d attachments", len(tnef.attachments))}
+	parts := [][]byte{}
+	body, w := tnef.bodyParts()
+	if w != "" {
+		warnings = append(warnings, w)
+	}
+	// The plain text body is usually in the enclosing message already
+	for _, p := range body {
+		if !strings.HasPrefix(p.header[0][1], "text/plain") {
+			parts = append(parts, p.bytes())
+		}
+	}
+	for _, a := range tnef.attachments {
+		parts = append(parts, a.part().bytes())
+	}
+	if len(parts) == 0 {
+		return nil, append(notes, warnings...), false
+	}
+	if nl == "\n" {
+		for i := range parts {
+			parts[i] = bytes.ReplaceAll(parts[i], []byte("\r\n"), []byte("\n"))
+		}
+	}
+	return parts, append(notes, warnings...), true
+}
+
+// newlineStripper removes line breaks from base64 content
+type newlineStripper struct {
+	r io.Reader
+}
+
+func (n *newlineStripper) Read(p []byte) (int, error) {
+	for {
+		count, err := n.r.Read(p)
+		j := 0
+		for _, c := range p[:count] {
+			if c != '\r' && c != '\n' && c != ' ' && c != '\t' {
+				p[j] = c
+				j++
+			}
+		}
+		if j > 0 || err != nil {
+			return j, err
+		}
+	}
+}
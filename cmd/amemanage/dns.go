@@ -11,10 +11,16 @@ import (
 )
 
 type DnsCmd struct {
-	Hostname string `help:"Check DNS for this hostname" required:""`
+	Hostname string `help:"Check DNS for this hostname, default the profile's whitelabel host"`
 }
 
 func (a *DnsCmd) Run(globals *Globals) error {
+	if a.Hostname == "" {
+		a.Hostname = globals.profile.Whitelabel
+	}
+	if a.Hostname == "" {
+		fatal("No --hostname given, and no whitelabel host in the config file profile")
+	}
 	resolver := net.Resolver{
 		PreferGo:     true,
 		StrictErrors: true,
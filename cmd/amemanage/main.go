@@ -5,16 +5,27 @@ import (
 	"fmt"
 	"github.com/alecthomas/kong"
 	"github.com/carlmjohnson/versioninfo"
+	"github.com/wttw/aboutmyemail"
+	"log/slog"
+	"os"
 )
 
 //go:embed templates
 var templateFS embed.FS
 
 type Globals struct {
-	Server  string      `env:"MYEMAIL_SERVER" help:"The api endpoint to use" default:"https://whitelabel.aboutmy.email/api/v1"`
-	ApiKey  string      `env:"MYEMAIL_APIKEY" help:"The api key to use for authorization"`
-	Quiet   bool        `help:"Don't display parameters or progress"`
-	Version VersionFlag `name:"version" help:"Print version information and quit"`
+	Config           string      `env:"MYEMAIL_CONFIG" help:"Config file to read profiles from" type:"path" placeholder:"file"`
+	Profile          string      `env:"MYEMAIL_PROFILE" help:"Profile in the config file to use for settings not given as flags or environment variables" placeholder:"name"`
+	Server           string      `env:"MYEMAIL_SERVER" help:"The api endpoint to use (default ${defaultServer})"`
+	ApiKey           string      `env:"MYEMAIL_APIKEY" help:"The api key to use for authorization"`
+	ApiKeyFile       string      `help:"Read the api key from this file, or stdin if it's -" placeholder:"file"`
+	CredentialHelper string      `env:"MYEMAIL_CREDENTIAL_HELPER" help:"Credential helper that stores api keys, as for git credential.helper" placeholder:"helper"`
+	Quiet            bool        `help:"Don't display parameters or progress"`
+	Debug            bool        `help:"Log api requests and responses to stderr"`
+	Version          VersionFlag `name:"version" help:"Print version information and quit"`
+
+	profile     aboutmyemail.Profile `kong:"-"`
+	keyResolved bool                 `kong:"-"`
 }
 
 type VersionFlag string
@@ -45,8 +56,27 @@ func main() {
 		kong.UsageOnError(),
 		kong.ConfigureHelp(kong.HelpOptions{Compact: true}),
 		kong.Vars{
-			"version": versioninfo.Short(),
+			"version":       versioninfo.Short(),
+			"defaultServer": defaultServer,
 		})
+	if err := cli.Globals.loadProfile(); err != nil {
+		ctx.FatalIfErrorf(err)
+	}
 	err := ctx.Run(&cli.Globals)
 	ctx.FatalIfErrorf(err)
 }
+
+// newClient creates an API client using the global server, key and debug settings
+func newClient(globals *Globals) *aboutmyemail.ClientWithResponses {
+	opts := []aboutmyemail.ClientOption{aboutmyemail.WithApiKey(globals.apiKey())}
+	if globals.Debug {
+		logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug}))
+		opts = append(opts, aboutmyemail.WithLogger(logger))
+	}
+	opts = append(opts, aboutmyemail.WithRetry(aboutmyemail.RetryPolicy{}))
+	client, err := aboutmyemail.NewClientWithResponses(globals.Server, opts...)
+	if err != nil {
+		fatal("Failed to create client: %s", err)
+	}
+	return client
+}
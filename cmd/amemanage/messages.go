@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/fatih/color"
+	"github.com/wttw/aboutmyemail"
 	"os"
 )
 
@@ -23,6 +25,26 @@ func printSuccess(globals *Globals, msg string, args ...any) {
 	}
 }
 
+// printAPIError reports a failed API call, as a warning if it's something the user can fix
+func printAPIError(err error) {
+	var apiErr *aboutmyemail.APIError
+	if !errors.As(err, &apiErr) {
+		printError("%s", err)
+		return
+	}
+	report := printWarning
+	if errors.Is(err, aboutmyemail.ErrServerFailure) {
+		report = printError
+	}
+	printWarning("Server responded with %s", apiErr.Status)
+	if apiErr.Message != "" {
+		report("%s", apiErr.Message)
+	}
+	if apiErr.RequestID != "" {
+		report("Request ID: %s", apiErr.RequestID)
+	}
+}
+
 func fatal(format string, args ...any) {
 	printError(format, args...)
 	os.Exit(1)
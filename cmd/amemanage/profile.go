@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"github.com/wttw/aboutmyemail"
+	"os"
+	"time"
+)
+
+const defaultServer = "https://whitelabel.aboutmy.email/api/v1"
+
+// loadProfile fills in any settings not given as flags or environment
+// variables from the selected config file profile, then the defaults. The
+// api key is left to apiKey, so commands that don't use the API never read
+// a key file or query the credential store.
+func (g *Globals) loadProfile() error {
+	profile, err := aboutmyemail.LoadProfile(g.Config, g.Profile)
+	if err != nil {
+		return err
+	}
+	g.profile = profile
+	if g.Server == "" {
+		g.Server = profile.Server
+	}
+	if g.Server == "" {
+		g.Server = defaultServer
+	}
+	if g.CredentialHelper == "" {
+		g.CredentialHelper = profile.CredentialHelper
+	}
+
+	return nil
+}
+
+// apiKey returns the api key, looking it up the first time it's needed:
+// from --api-key-file, then the flags, then the profile, then the
+// credential store
+func (g *Globals) apiKey() string {
+	if g.keyResolved {
+		return g.ApiKey
+	}
+	g.keyResolved = true
+	switch {
+	case g.ApiKeyFile != "":
+		key, err := aboutmyemail.ReadAPIKey(g.ApiKeyFile, os.Stdin)
+		if err != nil {
+			fatal("Failed to read api key: %s", err)
+		}
+		g.ApiKey = key
+	case g.ApiKey != "":
+		// Given as a flag or environment variable
+	case g.profile.ApiKey != "":
+		g.ApiKey = g.profile.ApiKey
+	default:
+		store, err := aboutmyemail.NewCredentialStore(g.CredentialHelper)
+		if err != nil {
+			printWarning("Can't read stored api key: %s", err)
+			return ""
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		key, err := store.Get(ctx, g.Server)
+		if err != nil {
+			printWarning("Can't read stored api key: %s", err)
+			return ""
+		}
+		g.ApiKey = key
+	}
+	return g.ApiKey
+}
@@ -8,7 +8,6 @@ import (
 	"github.com/wttw/aboutmyemail"
 	"io"
 	"mime/multipart"
-	"net/http"
 	"os"
 	"path/filepath"
 	"time"
@@ -57,10 +56,7 @@ func (a *StageCmd) Run(globals *Globals) error {
 	if err != nil {
 		fatal("Failed to create upload: %s", err)
 	}
-	client, err := aboutmyemail.New(aboutmyemail.WithServer(globals.Server), aboutmyemail.WithApiKey(globals.ApiKey))
-	if err != nil {
-		fatal("Failed to create client: %s", err)
-	}
+	client := newClient(globals)
 	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
 	defer cancel()
 
@@ -69,17 +65,10 @@ func (a *StageCmd) Run(globals *Globals) error {
 		fatal("Upload failed: %s", err)
 	}
 
-	if response.StatusCode() == http.StatusOK {
-		printSuccess(globals, "Uploaded OK")
+	if err := aboutmyemail.CheckResponse(response.HTTPResponse, response.Body); err != nil {
+		printAPIError(err)
 	} else {
-		printWarning("Server responded with %s", response.HTTPResponse.Status)
-		if response.JSON400 != nil {
-			printWarning("%s", response.JSON400.Message)
-		} else if response.JSON500 != nil {
-			printError("%s", response.JSON500.Message)
-		} else {
-			printError("%s", response.Body)
-		}
+		printSuccess(globals, "Uploaded OK")
 	}
 	return nil
 }
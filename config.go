@@ -0,0 +1,126 @@
+package aboutmyemail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const envMyemailConfig = "MYEMAIL_CONFIG"
+const envMyemailProfile = "MYEMAIL_PROFILE"
+
+// DefaultProfileName is the profile used when none is selected and the
+// config file doesn't name a default
+const DefaultProfileName = "default"
+
+// Profile is a named set of settings from the config file. Empty fields
+// are left to the caller's defaults.
+type Profile struct {
+	// Server is the API endpoint
+	Server string `json:"server,omitempty"`
+	// ApiKey is the key used for authorization
+	ApiKey string `json:"apiKey,omitempty"`
+	// CredentialHelper is the credential helper used to store keys, see
+	// CredentialHelper for the format
+	CredentialHelper string `json:"credentialHelper,omitempty"`
+	// Helo is the default HELO for submitted messages
+	Helo string `json:"helo,omitempty"`
+	// Ip is the default sending IP address for submitted messages
+	Ip string `json:"ip,omitempty"`
+	// Whitelabel is the hostname of the whitelabel site managed with this key
+	Whitelabel string `json:"whitelabel,omitempty"`
+	// SendmailLog is where aboutmyemail sendmail logs, "syslog", "-" for
+	// stderr or a filename
+	SendmailLog string `json:"sendmailLog,omitempty"`
+	// TrustedReceivers are this organization's MX hostnames and networks,
+	// used to find where a replayed message entered from its Received headers
+	TrustedReceivers []string `json:"trustedReceivers,omitempty"`
+}
+
+// Config is the contents of the config file shared by the aboutmyemail
+// and amemanage utilities, e.g.
+//
+//	{
+//	  "defaultProfile": "production",
+//	  "profiles": {
+//	    "production": {"apiKey": "myemail_..."},
+//	    "staging": {"server": "https://staging.aboutmy.email/api/v1", "apiKey": "myemail_..."}
+//	  }
+//	}
+type Config struct {
+	// DefaultProfile is used when no profile is selected
+	DefaultProfile string             `json:"defaultProfile,omitempty"`
+	Profiles       map[string]Profile `json:"profiles,omitempty"`
+}
+
+// ConfigPath returns where the config file is: $MYEMAIL_CONFIG if it's set,
+// otherwise aboutmyemail/config.json under the user config directory.
+func ConfigPath() (string, error) {
+	if path := os.Getenv(envMyemailConfig); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "aboutmyemail", "config.json"), nil
+}
+
+// LoadConfig reads the config file at path, or ConfigPath() if path is
+// empty. A missing file isn't an error, it gives an empty Config.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		var err error
+		path, err = ConfigPath()
+		if err != nil {
+			return nil, err
+		}
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &config, nil
+}
+
+// Profile returns the named profile. If name is empty it returns the
+// default profile, or an empty Profile if there isn't one; a named
+// profile that doesn't exist is an error.
+func (c *Config) Profile(name string) (Profile, error) {
+	if name != "" {
+		profile, ok := c.Profiles[name]
+		if !ok {
+			return Profile{}, fmt.Errorf("no profile named '%s' in config file", name)
+		}
+		return profile, nil
+	}
+	name = c.DefaultProfile
+	if name == "" {
+		name = DefaultProfileName
+	}
+	return c.Profiles[name], nil
+}
+
+// LoadProfile reads the named profile from the config file at path. Empty
+// path and name are defaulted as for LoadConfig and Config.Profile, and
+// an empty name also checks $MYEMAIL_PROFILE.
+func LoadProfile(path, name string) (Profile, error) {
+	config, err := LoadConfig(path)
+	if err != nil {
+		return Profile{}, err
+	}
+	if name == "" {
+		name = os.Getenv(envMyemailProfile)
+	}
+	return config.Profile(name)
+}
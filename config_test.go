@@ -0,0 +1,105 @@
+package aboutmyemail
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config: %v", err)
+	}
+	t.Setenv(envMyemailConfig, path)
+	t.Setenv(envMyemailProfile, "")
+	t.Setenv(envMyemailServer, "")
+	t.Setenv(envMyemailApikey, "")
+	return path
+}
+
+func TestLoadConfig_Missing(t *testing.T) {
+	config, err := LoadConfig(filepath.Join(t.TempDir(), "nonesuch.json"))
+	if err != nil {
+		t.Fatalf("missing config want no error, got %v", err)
+	}
+	profile, err := config.Profile("")
+	if err != nil || !reflect.DeepEqual(profile, Profile{}) {
+		t.Errorf("want empty default profile, got %+v, %v", profile, err)
+	}
+	if _, err := config.Profile("staging"); err == nil {
+		t.Errorf("want error for missing named profile")
+	}
+}
+
+func TestLoadProfile(t *testing.T) {
+	path := writeConfig(t, `{"defaultProfile": "prod", "profiles": {
+		"prod": {"apiKey": "prodkey"},
+		"staging": {"server": "https://staging.example.com/api/v1", "apiKey": "stagekey", "helo": "mta.example.com"}}}`)
+
+	profile, err := LoadProfile(path, "")
+	if err != nil || profile.ApiKey != "prodkey" {
+		t.Errorf("want default profile prod, got %+v, %v", profile, err)
+	}
+	t.Setenv(envMyemailProfile, "staging")
+	profile, err = LoadProfile("", "")
+	if err != nil || profile.ApiKey != "stagekey" || profile.Helo != "mta.example.com" {
+		t.Errorf("want staging profile from environment, got %+v, %v", profile, err)
+	}
+	profile, err = LoadProfile("", "prod")
+	if err != nil || profile.ApiKey != "prodkey" {
+		t.Errorf("want explicit profile to override environment, got %+v, %v", profile, err)
+	}
+
+	writeConfig(t, `{"profiles": `)
+	if _, err := LoadProfile("", ""); err == nil {
+		t.Errorf("want error for malformed config")
+	}
+}
+
+// Explicit options override the environment, which overrides the profile
+func TestNew_Precedence(t *testing.T) {
+	var gotAuth string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"id": "x", "url": "https://example.com/x"}`))
+	}))
+	defer ts.Close()
+	writeConfig(t, `{"profiles": {"default": {"server": "`+ts.URL+`", "apiKey": "profilekey"}}}`)
+
+	check := func(want string, opts ...ClientOption) {
+		t.Helper()
+		client, err := New(opts...)
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+		if _, err := client.EmailStatusWithResponse(context.Background(), "x"); err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if gotAuth != "Bearer "+want {
+			t.Errorf("want key %s, got %s", want, gotAuth)
+		}
+	}
+	check("profilekey")
+	t.Setenv(envMyemailApikey, "envkey")
+	check("envkey")
+	check("flagkey", WithApiKey("flagkey"))
+}
+
+// A broken config file only matters to New if a profile was asked for
+func TestNew_BadConfig(t *testing.T) {
+	writeConfig(t, `{"profiles": `)
+	if _, err := New(WithServer("https://example.com/api/v1"), WithApiKey("flagkey")); err != nil {
+		t.Errorf("want broken config ignored without MYEMAIL_PROFILE, got %v", err)
+	}
+	t.Setenv(envMyemailProfile, "staging")
+	if _, err := New(); err == nil {
+		t.Error("want error with MYEMAIL_PROFILE set and a broken config")
+	}
+}
@@ -0,0 +1,253 @@
+package aboutmyemail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// credentialUsername is the username given to credential helpers, which
+// key their storage on it as well as the host
+const credentialUsername = "apikey"
+
+// redacted replaces secrets in output
+const redacted = "REDACTED"
+
+// CredentialStore stores API keys outside the command line and config file,
+// keyed by the API endpoint they're for.
+type CredentialStore interface {
+	// Get returns the key stored for server, or "" if there isn't one
+	Get(ctx context.Context, server string) (string, error)
+	// Store saves key for server, replacing any key already stored
+	Store(ctx context.Context, server, key string) error
+	// Erase removes any key stored for server
+	Erase(ctx context.Context, server string) error
+}
+
+// NewCredentialStore returns the credential helper named by helper if it
+// isn't empty, otherwise a CredentialFile in the user config directory.
+func NewCredentialStore(helper string) (CredentialStore, error) {
+	if helper != "" {
+		return CredentialHelper{Command: helper}, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	return CredentialFile{Path: filepath.Join(dir, "aboutmyemail", "credentials.json")}, nil
+}
+
+// CredentialHelper is an external program that stores keys, speaking the git
+// credential helper protocol. That means existing git helpers, such as
+// git-credential-libsecret or git-credential-osxkeychain, can be used to keep
+// keys in the OS keyring.
+//
+// Command is interpreted as git does: if it starts with "!" the rest is run
+// by the shell; if it's a path it's run as is; otherwise it names a program
+// "aboutmyemail-credential-<Command>" on the PATH. The operation (get, store
+// or erase) is added as the last argument.
+type CredentialHelper struct {
+	Command string
+}
+
+func (h CredentialHelper) Get(ctx context.Context, server string) (string, error) {
+	attrs, err := credentialAttributes(server)
+	if err != nil {
+		return "", err
+	}
+	out, err := h.run(ctx, "get", attrs)
+	if err != nil {
+		return "", err
+	}
+	return parseCredential(out)["password"], nil
+}
+
+func (h CredentialHelper) Store(ctx context.Context, server, key string) error {
+	attrs, err := credentialAttributes(server)
+	if err != nil {
+		return err
+	}
+	attrs = append(attrs, "password="+key)
+	_, err = h.run(ctx, "store", attrs)
+	return err
+}
+
+func (h CredentialHelper) Erase(ctx context.Context, server string) error {
+	attrs, err := credentialAttributes(server)
+	if err != nil {
+		return err
+	}
+	_, err = h.run(ctx, "erase", attrs)
+	return err
+}
+
+func (h CredentialHelper) run(ctx context.Context, operation string, attrs []string) ([]byte, error) {
+	var cmd *exec.Cmd
+	switch {
+	case strings.HasPrefix(h.Command, "!"):
+		cmd = exec.CommandContext(ctx, "sh", "-c", h.Command[1:]+` "$@"`, h.Command[1:], operation)
+	case filepath.IsAbs(h.Command) || strings.ContainsRune(h.Command, filepath.Separator):
+		cmd = exec.CommandContext(ctx, h.Command, operation)
+	default:
+		fields := strings.Fields(h.Command)
+		if len(fields) == 0 {
+			return nil, errors.New("empty credential helper")
+		}
+		fields = append(fields, operation)
+		cmd = exec.CommandContext(ctx, "aboutmyemail-credential-"+fields[0], fields[1:]...)
+	}
+	cmd.Stdin = strings.NewReader(strings.Join(attrs, "\n") + "\n\n")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg != "" {
+			return nil, fmt.Errorf("credential helper %s failed: %w: %s", operation, err, msg)
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %w", operation, err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// credentialAttributes describes server to a credential helper
+func credentialAttributes(server string) ([]string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("bad server url: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("bad server url '%s'", server)
+	}
+	return []string{"protocol=" + u.Scheme, "host=" + u.Host, "username=" + credentialUsername}, nil
+}
+
+// parseCredential parses a credential helper's key=value output
+func parseCredential(out []byte) map[string]string {
+	ret := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			break
+		}
+		if k, v, ok := strings.Cut(line, "="); ok {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// CredentialFile stores keys in a JSON file readable only by the user
+type CredentialFile struct {
+	Path string
+}
+
+func (f CredentialFile) Get(_ context.Context, server string) (string, error) {
+	keys, err := f.read()
+	if err != nil {
+		return "", err
+	}
+	return keys[server], nil
+}
+
+func (f CredentialFile) Store(_ context.Context, server, key string) error {
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+	keys[server] = key
+	return f.write(keys)
+}
+
+func (f CredentialFile) Erase(_ context.Context, server string) error {
+	keys, err := f.read()
+	if err != nil {
+		return err
+	}
+	if _, ok := keys[server]; !ok {
+		return nil
+	}
+	delete(keys, server)
+	return f.write(keys)
+}
+
+func (f CredentialFile) read() (map[string]string, error) {
+	keys := map[string]string{}
+	content, err := os.ReadFile(f.Path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return keys, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", f.Path, err)
+	}
+	return keys, nil
+}
+
+func (f CredentialFile) write(keys map[string]string) error {
+	content, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0o700); err != nil {
+		return err
+	}
+	tmp := f.Path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.Path)
+}
+
+// ReadAPIKey reads an API key from a file, or from r if path is "-". Only the
+// first line is used, without surrounding whitespace.
+func ReadAPIKey(path string, r io.Reader) (string, error) {
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", err
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		r = f
+	}
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	key := strings.TrimSpace(line)
+	if key == "" {
+		return "", errors.New("no api key found")
+	}
+	return key, nil
+}
+
+// RedactHeaders returns a copy of h with the Authorization bearer token
+// replaced, suitable for logging or error output
+func RedactHeaders(h http.Header) http.Header {
+	ret := h.Clone()
+	for i, v := range ret.Values("Authorization") {
+		scheme, _, found := strings.Cut(v, " ")
+		if found {
+			ret["Authorization"][i] = scheme + " " + redacted
+		} else {
+			ret["Authorization"][i] = redacted
+		}
+	}
+	return ret
+}
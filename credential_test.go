@@ -0,0 +1,111 @@
+package aboutmyemail
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCredentialFile(t *testing.T) {
+	ctx := context.Background()
+	store := CredentialFile{Path: filepath.Join(t.TempDir(), "sub", "credentials.json")}
+	key, err := store.Get(ctx, apiEndpoint)
+	if err != nil || key != "" {
+		t.Errorf("empty store want no key, got %q, %v", key, err)
+	}
+	if err := store.Store(ctx, apiEndpoint, "key1"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if err := store.Store(ctx, "https://staging.example.com/api/v1", "key2"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if key, _ := store.Get(ctx, apiEndpoint); key != "key1" {
+		t.Errorf("want key1, got %q", key)
+	}
+	info, err := os.Stat(store.Path)
+	if err != nil || (runtime.GOOS != "windows" && info.Mode().Perm() != 0o600) {
+		t.Errorf("want credentials file mode 0600, got %v, %v", info, err)
+	}
+	if err := store.Erase(ctx, apiEndpoint); err != nil {
+		t.Fatalf("Erase failed: %v", err)
+	}
+	if key, _ := store.Get(ctx, apiEndpoint); key != "" {
+		t.Errorf("want key erased, got %q", key)
+	}
+	if key, _ := store.Get(ctx, "https://staging.example.com/api/v1"); key != "key2" {
+		t.Errorf("want other key kept, got %q", key)
+	}
+}
+
+// A helper script that records what it's sent and stores a single key,
+// like a (very) simplified git-credential-store
+func TestCredentialHelper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a shell")
+	}
+	dir := t.TempDir()
+	helper := CredentialHelper{Command: "!f() { cat > " + dir + "/$1.in; if [ $1 = get ] && [ -f " + dir + "/key ]; then " +
+		"echo username=apikey; echo password=$(cat " + dir + "/key); fi; " +
+		"if [ $1 = store ]; then sed -n 's/^password=//p' " + dir + "/store.in > " + dir + "/key; fi; " +
+		"if [ $1 = erase ]; then rm -f " + dir + "/key; fi; }; f"}
+	ctx := context.Background()
+
+	if key, err := helper.Get(ctx, apiEndpoint); err != nil || key != "" {
+		t.Errorf("want no key, got %q, %v", key, err)
+	}
+	if err := helper.Store(ctx, apiEndpoint, "secretkey"); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	sent, _ := os.ReadFile(filepath.Join(dir, "store.in"))
+	want := "protocol=https\nhost=api.aboutmy.email\nusername=apikey\npassword=secretkey\n\n"
+	if string(sent) != want {
+		t.Errorf("store want %q, got %q", want, sent)
+	}
+	if key, err := helper.Get(ctx, apiEndpoint); err != nil || key != "secretkey" {
+		t.Errorf("want secretkey, got %q, %v", key, err)
+	}
+	if err := helper.Erase(ctx, apiEndpoint); err != nil {
+		t.Fatalf("Erase failed: %v", err)
+	}
+	if key, _ := helper.Get(ctx, apiEndpoint); key != "" {
+		t.Errorf("want key erased, got %q", key)
+	}
+
+	failing := CredentialHelper{Command: "!echo broken >&2; exit 1"}
+	if _, err := failing.Get(ctx, apiEndpoint); err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("want helper failure reported, got %v", err)
+	}
+}
+
+func TestReadAPIKey(t *testing.T) {
+	key, err := ReadAPIKey("-", strings.NewReader("  myemail_abc \nignored\n"))
+	if err != nil || key != "myemail_abc" {
+		t.Errorf("stdin want myemail_abc, got %q, %v", key, err)
+	}
+	path := filepath.Join(t.TempDir(), "key")
+	_ = os.WriteFile(path, []byte("myemail_def"), 0o600)
+	key, err = ReadAPIKey(path, nil)
+	if err != nil || key != "myemail_def" {
+		t.Errorf("file want myemail_def, got %q, %v", key, err)
+	}
+	if _, err := ReadAPIKey("-", strings.NewReader("\n")); err == nil {
+		t.Errorf("want error for empty key")
+	}
+}
+
+func TestRedactHeaders(t *testing.T) {
+	h := http.Header{}
+	h.Set("Authorization", "Bearer myemail_secret")
+	h.Set("Content-Type", "application/json")
+	redactedHeaders := RedactHeaders(h)
+	if got := redactedHeaders.Get("Authorization"); got != "Bearer REDACTED" {
+		t.Errorf("want Bearer REDACTED, got %s", got)
+	}
+	if redactedHeaders.Get("Content-Type") != "application/json" || h.Get("Authorization") != "Bearer myemail_secret" {
+		t.Errorf("want other headers and original untouched")
+	}
+}
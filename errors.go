@@ -0,0 +1,134 @@
+package aboutmyemail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sentinel errors that an *APIError matches with errors.Is
+var (
+	ErrBadRequest    = errors.New("bad request")
+	ErrUnauthorized  = errors.New("unauthorized")
+	ErrForbidden     = errors.New("forbidden")
+	ErrNotFound      = errors.New("not found")
+	ErrNotReady      = errors.New("result not ready")
+	ErrTooLarge      = errors.New("payload too large")
+	ErrRateLimited   = errors.New("rate limited")
+	ErrServerFailure = errors.New("server failure")
+)
+
+const requestIDHeader = "X-Request-Id"
+
+// APIError is returned by the high-level calls when the server responds
+// with anything other than success.
+type APIError struct {
+	// StatusCode is the HTTP status of the response
+	StatusCode int
+	// Status is the HTTP status line, e.g. "404 Not Found"
+	Status string
+	// Message is the server's description of the problem, if it gave one
+	Message string
+	// RequestID identifies the request in the server logs, if it gave one
+	RequestID string
+	// RetryAfter is how long the server asked us to wait before retrying, or zero
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode)
+	}
+	msg := "server rejected request: " + status
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+	if e.RequestID != "" {
+		msg += " (request " + e.RequestID + ")"
+	}
+	return msg
+}
+
+// Is matches the sentinel error for this status, so errors.Is(err, ErrNotFound) works
+func (e *APIError) Is(target error) bool {
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		return target == ErrBadRequest
+	case http.StatusUnauthorized:
+		return target == ErrUnauthorized
+	case http.StatusForbidden:
+		return target == ErrForbidden
+	case http.StatusNotFound:
+		return target == ErrNotFound
+	case http.StatusConflict:
+		return target == ErrNotReady
+	case http.StatusRequestEntityTooLarge:
+		return target == ErrTooLarge
+	case http.StatusTooManyRequests:
+		return target == ErrRateLimited
+	}
+	return e.StatusCode >= 500 && target == ErrServerFailure
+}
+
+// Retryable reports whether the same request might succeed if tried again later
+func (e *APIError) Retryable() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// CheckResponse returns nil if rsp is a 2xx success, otherwise an *APIError
+// describing it. body is the already read response body, as stored in the
+// Body field of the generated response types.
+func CheckResponse(rsp *http.Response, body []byte) error {
+	if rsp == nil {
+		return errors.New("no response from server")
+	}
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return nil
+	}
+	apiErr := &APIError{
+		StatusCode: rsp.StatusCode,
+		Status:     rsp.Status,
+		RequestID:  rsp.Header.Get(requestIDHeader),
+		RetryAfter: parseRetryAfter(rsp.Header.Get("Retry-After"), time.Now()),
+	}
+	var structured N400Error
+	if strings.Contains(rsp.Header.Get("Content-Type"), "json") && json.Unmarshal(body, &structured) == nil {
+		apiErr.Message = structured.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// parseRetryAfter parses a Retry-After header, in either delay-seconds or
+// HTTP-date form, returning zero if it's missing or malformed.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	when, err := http.ParseTime(value)
+	if err != nil || !when.After(now) {
+		return 0
+	}
+	return when.Sub(now)
+}
+
+// unexpectedNil is returned when a success response didn't have the body we expected
+func unexpectedNil(operation string) error {
+	return fmt.Errorf("unexpected nil result in %s response", operation)
+}
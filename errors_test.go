@@ -0,0 +1,78 @@
+package aboutmyemail
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		name        string
+		status      int
+		contentType string
+		body        string
+		header      http.Header
+		want        error
+		message     string
+		retryable   bool
+	}{
+		{"not found", http.StatusNotFound, "application/json", `{"message":"no such result"}`, nil, ErrNotFound, "no such result", false},
+		{"unauthorized", http.StatusUnauthorized, "application/json", `{"message":"bad key"}`, nil, ErrUnauthorized, "bad key", false},
+		{"rate limited", http.StatusTooManyRequests, "text/plain", "slow down\n", nil, ErrRateLimited, "slow down", true},
+		{"server failure", http.StatusInternalServerError, "application/json", `{"message":"oops"}`, nil, ErrServerFailure, "oops", false},
+		{"bad gateway", http.StatusBadGateway, "text/html", "", nil, ErrServerFailure, "", true},
+		{"too large", http.StatusRequestEntityTooLarge, "application/json", `{"message":"too big"}`, nil, ErrTooLarge, "too big", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rsp := &http.Response{
+				StatusCode: c.status,
+				Status:     http.StatusText(c.status),
+				Header:     http.Header{"Content-Type": {c.contentType}, "X-Request-Id": {"req-42"}},
+			}
+			err := CheckResponse(rsp, []byte(c.body))
+			if !errors.Is(err, c.want) {
+				t.Fatalf("want errors.Is(%v), got %v", c.want, err)
+			}
+			var apiErr *APIError
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("want *APIError, got %T", err)
+			}
+			if apiErr.Message != c.message {
+				t.Errorf("message want '%s', got '%s'", c.message, apiErr.Message)
+			}
+			if apiErr.RequestID != "req-42" {
+				t.Errorf("request id want 'req-42', got '%s'", apiErr.RequestID)
+			}
+			if apiErr.Retryable() != c.retryable {
+				t.Errorf("retryable want %v, got %v", c.retryable, apiErr.Retryable())
+			}
+		})
+	}
+
+	if err := CheckResponse(&http.Response{StatusCode: http.StatusOK}, nil); err != nil {
+		t.Errorf("want nil error for 200, got %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	cases := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"7", 7 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, c := range cases {
+		if got := parseRetryAfter(c.value, now); got != c.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", c.value, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package aboutmyemail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// EmailFormWithResponse submits a message as multipart/form-data (the
+// SubmitForm schema), streaming the payload from r rather than copying it
+// into a JSON string. The payload is sent exactly as read, so it needn't be
+// valid UTF-8. body.Payload is ignored.
+//
+// If payload is an io.Seeker the request can be retried, as the payload is
+// read again from its start.
+func (c *ClientWithResponses) EmailFormWithResponse(ctx context.Context, body Submit, payload io.Reader, reqEditors ...RequestEditorFn) (*EmailResponse, error) {
+	form := newFormBody(body, payload)
+	defer form.close()
+	first, err := form.open()
+	if err != nil {
+		return nil, err
+	}
+	editors := append([]RequestEditorFn{func(_ context.Context, req *http.Request) error {
+		if _, ok := payload.(io.Seeker); ok {
+			req.GetBody = form.open
+		}
+		return nil
+	}}, reqEditors...)
+	return c.EmailWithBodyWithResponse(ctx, form.contentType(), first, editors...)
+}
+
+// SubmitFormAndWait is SubmitAndWait for a payload streamed from r, as
+// with EmailFormWithResponse
+func (c *ClientWithResponses) SubmitFormAndWait(ctx context.Context, body Submit, payload io.Reader, opts ...WaitOption) (*StatusResult, error) {
+	return c.submitAndWait(ctx, func() (*EmailResponse, error) {
+		return c.EmailFormWithResponse(ctx, body, payload)
+	}, newWaitConfig(opts))
+}
+
+// formBody writes a submission as multipart/form-data through a pipe, so
+// the payload is never held in memory
+type formBody struct {
+	fields  [][2]string
+	payload io.Reader
+	w       *multipart.Writer
+
+	mtx    sync.Mutex
+	reader *io.PipeReader
+	done   chan struct{}
+}
+
+func newFormBody(body Submit, payload io.Reader) *formBody {
+	fields := [][2]string{{"from", body.From}, {"to", body.To}, {"ip", body.Ip}}
+	optional := func(name string, value *string) {
+		if value != nil {
+			fields = append(fields, [2]string{name, *value})
+		}
+	}
+	optional("helo", body.Helo)
+	if body.Smtputf8 != nil {
+		fields = append(fields, [2]string{"smtputf8", strconv.FormatBool(*body.Smtputf8)})
+	}
+	optional("options", body.Options)
+	optional("token", body.Token)
+	optional("progressUrl", body.ProgressUrl)
+	optional("finishedUrl", body.FinishedUrl)
+	optional("callbackSecret", body.CallbackSecret)
+	return &formBody{fields: fields, payload: payload, w: multipart.NewWriter(io.Discard)}
+}
+
+// contentType is the Content-Type header for the form, with its boundary
+func (f *formBody) contentType() string {
+	return f.w.FormDataContentType()
+}
+
+// open starts writing the form to a new pipe and returns its reading end.
+// Opening it again, for a retry, abandons the previous write and rewinds
+// the payload.
+func (f *formBody) open() (io.ReadCloser, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.done != nil {
+		f.reader.CloseWithError(errors.New("request body reopened"))
+		<-f.done
+		seeker, ok := f.payload.(io.Seeker)
+		if !ok {
+			return nil, errors.New("payload can't be rewound")
+		}
+		if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind payload: %w", err)
+		}
+	}
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	f.reader, f.done = reader, done
+	go func() {
+		defer close(done)
+		writer.CloseWithError(f.write(writer))
+	}()
+	return reader, nil
+}
+
+// write writes the form fields then the payload to w
+func (f *formBody) write(w io.Writer) error {
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(f.w.Boundary()); err != nil {
+		return err
+	}
+	for _, field := range f.fields {
+		if err := mw.WriteField(field[0], field[1]); err != nil {
+			return err
+		}
+	}
+	part, err := mw.CreateFormFile("payload", "message.eml")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, f.payload); err != nil {
+		return fmt.Errorf("failed to read payload: %w", err)
+	}
+	return mw.Close()
+}
+
+// close stops any write still in progress, once the request is done with
+func (f *formBody) close() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+	if f.reader != nil {
+		f.reader.CloseWithError(errors.New("request finished"))
+		<-f.done
+	}
+}
@@ -0,0 +1,98 @@
+package aboutmyemail_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail"
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+// binaryEmail is a message with a Latin-1 header, every byte value in its
+// body and enough padding to need several writes
+func binaryEmail() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("From: <steve@blighty.com>\r\nTo: <steve@blighty.com>\r\nSubject: Caf\xe9 cr\xe8me\r\n\r\n")
+	for i := 0; i < 256; i++ {
+		buf.WriteByte(byte(i))
+	}
+	buf.WriteString("\r\nbare\rcr and bare\nlf\r\n")
+	for buf.Len() < 3<<20 {
+		buf.WriteString("Sch\xf6ne Gr\xfc\xdfe \xff\xfe\x00\r\n")
+	}
+	return buf.Bytes()
+}
+
+func TestEmailFormWithResponse(t *testing.T) {
+	server := aboutmyemailtest.NewServer(aboutmyemailtest.WithMessages())
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	email := binaryEmail()
+	helo, token, utf8 := "mail.blighty.com", "binary", false
+	body := aboutmyemail.Submit{From: "steve@blighty.com", To: "steve@blighty.com", Ip: "10.11.12.13", Helo: &helo, Token: &token, Smtputf8: &utf8}
+	result, err := client.SubmitFormAndWait(ctx, body, io.MultiReader(bytes.NewReader(email)), aboutmyemail.WithPollInterval(time.Millisecond))
+	if err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	subs := server.Submissions()
+	if len(subs) != 1 || subs[0].ID != result.Id {
+		t.Fatalf("want one submission %s, got %d", result.Id, len(subs))
+	}
+	sub := subs[0]
+	if sub.ContentType != "multipart/form-data" {
+		t.Errorf("want multipart/form-data, got %s", sub.ContentType)
+	}
+	if !bytes.Equal(sub.Payload, email) {
+		t.Errorf("payload changed: sent %d bytes, received %d", len(email), len(sub.Payload))
+	}
+	if sub.From != body.From || sub.To != body.To || sub.Ip != body.Ip || sub.Helo != helo || sub.Token != token || sub.Smtputf8 {
+		t.Errorf("fields not sent: %+v", sub)
+	}
+}
+
+// A seekable payload is sent again when the request is retried, an
+// unseekable one can't be
+func TestEmailFormWithResponse_Retry(t *testing.T) {
+	server := aboutmyemailtest.NewServer()
+	defer server.Close()
+	client, err := server.Client(aboutmyemail.WithRetry(aboutmyemail.RetryPolicy{BaseDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	body := aboutmyemail.Submit{From: "steve@blighty.com", To: "steve@blighty.com", Ip: "10.11.12.13"}
+	email := binaryEmail()
+
+	server.InjectError(http.MethodPost, "/emails", http.StatusServiceUnavailable, "busy")
+	response, err := client.EmailFormWithResponse(ctx, body, bytes.NewReader(email))
+	if err == nil {
+		err = aboutmyemail.CheckResponse(response.HTTPResponse, response.Body)
+	}
+	if err != nil {
+		t.Fatalf("want retry to succeed, got %v", err)
+	}
+	if subs := server.Submissions(); len(subs) != 1 || !bytes.Equal(subs[0].Payload, email) {
+		t.Errorf("want payload intact after retry")
+	}
+
+	server.InjectError(http.MethodPost, "/emails", http.StatusServiceUnavailable, "busy")
+	response, err = client.EmailFormWithResponse(ctx, body, io.MultiReader(bytes.NewReader(email)))
+	if err == nil {
+		err = aboutmyemail.CheckResponse(response.HTTPResponse, response.Body)
+	}
+	if !errors.Is(err, aboutmyemail.ErrServerFailure) {
+		t.Errorf("want server failure without a retry, got %v", err)
+	}
+}
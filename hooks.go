@@ -0,0 +1,191 @@
+package aboutmyemail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// attemptKey is the context key a retrying doer uses to pass the number of
+// the current attempt, counting from 1, to the doers it wraps
+type attemptKey struct{}
+
+// attemptCountKey is the context key for an *int that a retrying doer
+// updates with the number of attempts made so far
+type attemptCountKey struct{}
+
+// maxLoggedBody is the most of a submission response read to find its id
+const maxLoggedBody = 64 << 10
+
+// RequestInfo describes a request the client is about to make
+type RequestInfo struct {
+	Method string
+	// Path is the URL path, including the endpoint's base path
+	Path string
+	// Attempt is the number of this attempt at the request, counting from 1.
+	// It's zero if the hooks are outside any retrying, see WithHooks.
+	Attempt int
+	// Header is the request header, with the API key redacted
+	Header http.Header
+	// ResultID is the result id the request is for, if any
+	ResultID string
+}
+
+// ResponseInfo describes the outcome of a request
+type ResponseInfo struct {
+	RequestInfo
+	// StatusCode is the HTTP status, or zero if the request failed without a response
+	StatusCode int
+	// Latency is how long the request took, including any retries it covers
+	Latency time.Duration
+	// Attempts is how many attempts were made, if the hooks are outside
+	// retrying, otherwise 1
+	Attempts int
+	// RequestID identifies the request in the server logs, if it gave one
+	RequestID string
+	// Err is the error if the request failed without a response
+	Err error
+}
+
+// ClientHooks are functions called around each request the client makes.
+// Either may be nil.
+type ClientHooks struct {
+	// Request is called before a request is sent
+	Request func(ctx context.Context, info RequestInfo)
+	// Response is called when a request completes, successfully or not. For
+	// a new submission ResultID is the id the server assigned.
+	Response func(ctx context.Context, info ResponseInfo)
+}
+
+// WithHooks calls hooks around each request. Like WithRetry it wraps the
+// HttpRequestDoer configured so far. If it comes before WithRetry the hooks
+// see each attempt separately, with Attempt set; if it comes after they see
+// each call once, with Attempts counting the tries it took.
+func WithHooks(hooks ClientHooks) ClientOption {
+	return func(c *Client) error {
+		next := c.Client
+		if next == nil {
+			next = &http.Client{}
+		}
+		c.Client = &hookDoer{next: next, hooks: hooks}
+		return nil
+	}
+}
+
+// WithLogger logs each request and response to logger at debug level, or
+// warning level for requests that fail without a response. It's WithHooks
+// with hooks that log, so the same ordering with WithRetry applies.
+func WithLogger(logger *slog.Logger) ClientOption {
+	return WithHooks(ClientHooks{
+		Request: func(ctx context.Context, info RequestInfo) {
+			attrs := []slog.Attr{slog.String("method", info.Method), slog.String("path", info.Path)}
+			if info.Attempt > 0 {
+				attrs = append(attrs, slog.Int("attempt", info.Attempt))
+			}
+			if info.ResultID != "" {
+				attrs = append(attrs, slog.String("result_id", info.ResultID))
+			}
+			attrs = append(attrs, slog.Any("header", info.Header))
+			logger.LogAttrs(ctx, slog.LevelDebug, "api request", attrs...)
+		},
+		Response: func(ctx context.Context, info ResponseInfo) {
+			attrs := []slog.Attr{slog.String("method", info.Method), slog.String("path", info.Path)}
+			if info.Attempt > 0 {
+				attrs = append(attrs, slog.Int("attempt", info.Attempt))
+			} else {
+				attrs = append(attrs, slog.Int("attempts", info.Attempts))
+			}
+			if info.ResultID != "" {
+				attrs = append(attrs, slog.String("result_id", info.ResultID))
+			}
+			attrs = append(attrs, slog.Duration("latency", info.Latency))
+			if info.Err != nil {
+				attrs = append(attrs, slog.Any("error", info.Err))
+				logger.LogAttrs(ctx, slog.LevelWarn, "api request failed", attrs...)
+				return
+			}
+			attrs = append(attrs, slog.Int("status", info.StatusCode))
+			if info.RequestID != "" {
+				attrs = append(attrs, slog.String("request_id", info.RequestID))
+			}
+			logger.LogAttrs(ctx, slog.LevelDebug, "api response", attrs...)
+		},
+	})
+}
+
+type hookDoer struct {
+	next  HttpRequestDoer
+	hooks ClientHooks
+}
+
+func (d *hookDoer) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	info := RequestInfo{
+		Method:   req.Method,
+		Path:     req.URL.Path,
+		Header:   RedactHeaders(req.Header),
+		ResultID: pathResultID(req.URL.Path),
+	}
+	attempts := 0
+	if attempt, ok := ctx.Value(attemptKey{}).(int); ok {
+		info.Attempt = attempt
+	} else {
+		req = req.WithContext(context.WithValue(ctx, attemptCountKey{}, &attempts))
+	}
+	if d.hooks.Request != nil {
+		d.hooks.Request(ctx, info)
+	}
+
+	start := time.Now()
+	rsp, err := d.next.Do(req)
+	result := ResponseInfo{RequestInfo: info, Latency: time.Since(start), Attempts: max(attempts, 1), Err: err}
+	if rsp != nil {
+		result.StatusCode = rsp.StatusCode
+		result.RequestID = rsp.Header.Get(requestIDHeader)
+		if result.ResultID == "" && req.Method == http.MethodPost && strings.HasSuffix(req.URL.Path, "/emails") {
+			result.ResultID = submittedID(rsp)
+		}
+	}
+	if d.hooks.Response != nil {
+		d.hooks.Response(ctx, result)
+	}
+	return rsp, err
+}
+
+// pathResultID returns the result id from a /emails/{resultID} path
+func pathResultID(path string) string {
+	_, rest, found := strings.Cut(path, "/emails/")
+	if !found {
+		return ""
+	}
+	id, _, _ := strings.Cut(rest, "/")
+	return id
+}
+
+// submittedID peeks at a submission response for the id the server
+// assigned, leaving the body to be read again
+func submittedID(rsp *http.Response) string {
+	if rsp.StatusCode != http.StatusOK || !strings.Contains(rsp.Header.Get("Content-Type"), "json") {
+		return ""
+	}
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, maxLoggedBody))
+	rsp.Body = readCloser{io.MultiReader(bytes.NewReader(body), rsp.Body), rsp.Body}
+	if err != nil {
+		return ""
+	}
+	var success SubmitSuccess
+	if json.Unmarshal(body, &success) != nil {
+		return ""
+	}
+	return success.Id
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,96 @@
+package aboutmyemail
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWithHooks(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Millisecond}
+	request := Submit{From: "a@example.com", To: "b@example.com", Ip: "10.0.0.1", Payload: "x"}
+
+	var mtx sync.Mutex
+	var responses []ResponseInfo
+	hooks := ClientHooks{Response: func(_ context.Context, info ResponseInfo) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		responses = append(responses, info)
+	}}
+
+	// Hooks before WithRetry see every attempt
+	flaky := &flakyServer{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(flaky)
+	defer ts.Close()
+	client, err := NewClientWithResponses(ts.URL, WithApiKey("secret"), WithHooks(hooks), WithRetry(policy))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.EmailWithResponse(context.Background(), request); err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	if len(responses) != 3 {
+		t.Fatalf("want 3 attempts seen, got %d", len(responses))
+	}
+	for i, info := range responses {
+		if info.Attempt != i+1 || info.Method != http.MethodPost || info.Path != "/emails" {
+			t.Errorf("response %d unexpected %+v", i, info)
+		}
+	}
+	if responses[1].StatusCode != http.StatusTooManyRequests || responses[2].StatusCode != http.StatusOK || responses[2].ResultID != "abc123" {
+		t.Errorf("want 429 then 200 with result id, got %+v", responses[1:])
+	}
+	if got := responses[0].Header.Get("Authorization"); got != "Bearer REDACTED" {
+		t.Errorf("want key redacted, got %s", got)
+	}
+
+	// Hooks after WithRetry see the call once, with the attempt count
+	responses = nil
+	flaky.failures = []int{http.StatusBadGateway}
+	client, err = NewClientWithResponses(ts.URL, WithRetry(policy), WithHooks(hooks))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	result, err := client.EmailWithResponse(context.Background(), request)
+	if err != nil || result.JSON200 == nil || result.JSON200.Id != "abc123" {
+		t.Fatalf("want body still readable after hook, got %v, %v", result, err)
+	}
+	if len(responses) != 1 || responses[0].Attempts != 2 || responses[0].Attempt != 0 || responses[0].ResultID != "abc123" {
+		t.Errorf("want one response after 2 attempts, got %+v", responses)
+	}
+
+	responses = nil
+	_, _ = client.EmailStatusWithResponse(context.Background(), "xyz789")
+	if len(responses) != 1 || responses[0].ResultID != "xyz789" {
+		t.Errorf("want result id from path, got %+v", responses)
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	ts := httptest.NewServer(&flakyServer{})
+	defer ts.Close()
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	client, err := NewClientWithResponses(ts.URL, WithApiKey("myemail_secret"), WithLogger(logger))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	if _, err := client.EmailWithResponse(context.Background(), Submit{From: "a@example.com", To: "b@example.com", Ip: "10.0.0.1", Payload: "x"}); err != nil {
+		t.Fatalf("submit failed: %v", err)
+	}
+	out := buf.String()
+	if strings.Contains(out, "myemail_secret") {
+		t.Errorf("api key leaked into log: %s", out)
+	}
+	for _, want := range []string{"method=POST", "path=/emails", "status=200", "result_id=abc123", "latency=", "attempts=1"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("want %s in log, got %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package aboutmyemail
+
+import "context"
+
+// List fetches one page of the messages submitted with this API key, newest
+// first. params may be nil to list everything. If there are more results
+// NextCursor is set, and passing it as params.Cursor fetches the next page.
+func (c *ClientWithResponses) List(ctx context.Context, params *EmailListParams) (*EmailList, error) {
+	response, err := c.EmailListWithResponse(ctx, params)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckResponse(response.HTTPResponse, response.Body); err != nil {
+		return nil, err
+	}
+	if response.JSON200 == nil {
+		return nil, unexpectedNil("email list")
+	}
+	return response.JSON200, nil
+}
+
+// ListAll calls fn for each message matching params, fetching further pages
+// as needed. If fn returns an error ListAll stops and returns it.
+func (c *ClientWithResponses) ListAll(ctx context.Context, params *EmailListParams, fn func(EmailSummary) error) error {
+	page := EmailListParams{}
+	if params != nil {
+		page = *params
+	}
+	for {
+		list, err := c.List(ctx, &page)
+		if err != nil {
+			return err
+		}
+		for _, email := range list.Emails {
+			if err := fn(email); err != nil {
+				return err
+			}
+		}
+		if list.NextCursor == nil || *list.NextCursor == "" {
+			return nil
+		}
+		page.Cursor = list.NextCursor
+	}
+}
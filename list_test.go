@@ -0,0 +1,87 @@
+package aboutmyemail_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/wttw/aboutmyemail"
+	"github.com/wttw/aboutmyemail/aboutmyemailtest"
+)
+
+func TestList(t *testing.T) {
+	server := aboutmyemailtest.NewServer()
+	defer server.Close()
+	client, err := server.Client()
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+
+	start := time.Now().Add(-time.Second)
+	stage := "stage"
+	for i, to := range []string{"a@example.com", "b@example.org", "c@example.com", "d@EXAMPLE.com", "e@example.org"} {
+		request := aboutmyemail.Submit{From: "steve@blighty.com", To: to, Ip: "10.11.12.13", Payload: testEmail}
+		if i == 2 {
+			request.Options = &stage
+		}
+		submitted, err := client.EmailWithResponse(ctx, request)
+		if err != nil || submitted.JSON200 == nil {
+			t.Fatalf("client.Email() failed: %v", err)
+		}
+		if i == 0 {
+			if _, err := client.Wait(ctx, submitted.JSON200.Id, aboutmyemail.WithPollInterval(time.Millisecond)); err != nil {
+				t.Fatalf("Wait failed: %v", err)
+			}
+		}
+	}
+
+	limit := 2
+	page, err := client.List(ctx, &aboutmyemail.EmailListParams{Limit: &limit})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(page.Emails) != 2 || page.NextCursor == nil || page.Emails[0].To != "e@example.org" {
+		t.Errorf("want first page of two, newest first, got %+v", page)
+	}
+
+	var all []aboutmyemail.EmailSummary
+	err = client.ListAll(ctx, &aboutmyemail.EmailListParams{Limit: &limit}, func(email aboutmyemail.EmailSummary) error {
+		all = append(all, email)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ListAll failed: %v", err)
+	}
+	if len(all) != 5 {
+		t.Fatalf("want 5 emails across pages, got %d", len(all))
+	}
+	oldest := all[4]
+	if oldest.Url == nil || *oldest.Url != server.ResultURL(oldest.Id) || !oldest.Submitted.After(start) {
+		t.Errorf("want finished oldest email with url, got %+v", oldest)
+	}
+	if all[0].Url != nil {
+		t.Errorf("want unfinished email without url, got %s", *all[0].Url)
+	}
+
+	count := func(params aboutmyemail.EmailListParams) int {
+		n := 0
+		if err := client.ListAll(ctx, &params, func(aboutmyemail.EmailSummary) error { n++; return nil }); err != nil {
+			t.Fatalf("ListAll failed: %v", err)
+		}
+		return n
+	}
+	domain := "example.com"
+	staged := true
+	future := time.Now().Add(time.Hour)
+	if n := count(aboutmyemail.EmailListParams{ToDomain: &domain}); n != 3 {
+		t.Errorf("to domain want 3, got %d", n)
+	}
+	if n := count(aboutmyemail.EmailListParams{Staged: &staged}); n != 1 {
+		t.Errorf("staged want 1, got %d", n)
+	}
+	if n := count(aboutmyemail.EmailListParams{After: &future}); n != 0 {
+		t.Errorf("after future want 0, got %d", n)
+	}
+}
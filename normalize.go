@@ -0,0 +1,121 @@
+package aboutmyemail
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ChangeKind identifies a change Normalize made to a message
+type ChangeKind string
+
+const (
+	// ChangeBOM is the removal of a leading UTF-8 byte order mark
+	ChangeBOM ChangeKind = "bom"
+	// ChangeFromLine is the removal of an mbox From_ line before the headers
+	ChangeFromLine ChangeKind = "from-line"
+	// ChangeLF is the conversion of bare LF line endings to CRLF
+	ChangeLF ChangeKind = "lf"
+	// ChangeCR is the conversion of bare CR line endings to CRLF
+	ChangeCR ChangeKind = "cr"
+	// ChangeCRCRLF is the conversion of CRCRLF line endings, as left by
+	// converting CRLF text a second time, to CRLF
+	ChangeCRCRLF ChangeKind = "crcrlf"
+)
+
+// Change is one kind of change Normalize made to a message
+type Change struct {
+	Kind ChangeKind `json:"kind"`
+	// Count is how many times it was made, such as the number of lines
+	// converted
+	Count int `json:"count"`
+}
+
+func (c Change) String() string {
+	switch c.Kind {
+	case ChangeBOM:
+		return "removed UTF-8 byte order mark"
+	case ChangeFromLine:
+		return "removed mbox From_ line"
+	case ChangeLF:
+		return fmt.Sprintf("converted %d LF line endings to CRLF", c.Count)
+	case ChangeCR:
+		return fmt.Sprintf("converted %d bare CR line endings to CRLF", c.Count)
+	case ChangeCRCRLF:
+		return fmt.Sprintf("converted %d CRCRLF line endings to CRLF", c.Count)
+	}
+	return fmt.Sprintf("%s (%d)", c.Kind, c.Count)
+}
+
+var (
+	utf8BOM  = []byte{0xef, 0xbb, 0xbf}
+	mboxFrom = []byte("From ")
+)
+
+// Normalize converts a message to the canonical form it's sent in over
+// SMTP, so that results don't depend on how the file happened to be saved.
+// It removes a leading byte order mark and mbox From_ line and converts
+// every line ending to CRLF. It returns the message, which is data itself if
+// nothing needed changing, and each kind of change made.
+func Normalize(data []byte) ([]byte, []Change) {
+	var changes []Change
+	if bytes.HasPrefix(data, utf8BOM) {
+		data = data[len(utf8BOM):]
+		changes = append(changes, Change{Kind: ChangeBOM, Count: 1})
+	}
+	if bytes.HasPrefix(data, mboxFrom) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		} else {
+			data = nil
+		}
+		changes = append(changes, Change{Kind: ChangeFromLine, Count: 1})
+	}
+
+	data, lineEndings := NormalizeLineEndings(data)
+	return data, append(changes, lineEndings...)
+}
+
+// NormalizeLineEndings converts every LF, CR and CRCRLF line ending in data
+// to CRLF, the part of Normalize that applies to any text rather than only
+// to whole messages. It returns data itself if nothing needed changing, and
+// each kind of change made.
+func NormalizeLineEndings(data []byte) ([]byte, []Change) {
+	var lf, cr, crcrlf int
+	for i := 0; i < len(data); i++ {
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("\r\r\n")):
+			crcrlf++
+			i += 2
+		case bytes.HasPrefix(data[i:], []byte("\r\n")):
+			i++
+		case data[i] == '\r':
+			cr++
+		case data[i] == '\n':
+			lf++
+		}
+	}
+	if lf+cr+crcrlf == 0 {
+		return data, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(data) + lf + cr)
+	for i := 0; i < len(data); i++ {
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("\r\r\n")):
+			i += 2
+		case bytes.HasPrefix(data[i:], []byte("\r\n")):
+			i++
+		case data[i] != '\r' && data[i] != '\n':
+			buf.WriteByte(data[i])
+			continue
+		}
+		buf.WriteString("\r\n")
+	}
+	var changes []Change
+	for _, c := range []Change{{ChangeLF, lf}, {ChangeCR, cr}, {ChangeCRCRLF, crcrlf}} {
+		if c.Count > 0 {
+			changes = append(changes, c)
+		}
+	}
+	return buf.Bytes(), changes
+}
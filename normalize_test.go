@@ -0,0 +1,54 @@
+package aboutmyemail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	const message = "From: a@example.com\r\nSubject: hi\r\n\r\nbody\r\n.dot\r\n"
+	lf := strings.ReplaceAll(message, "\r\n", "\n")
+	cases := []struct {
+		name    string
+		in      string
+		want    string
+		changes []Change
+	}{
+		{"canonical", message, message, nil},
+		{"lf", lf, message, []Change{{ChangeLF, 5}}},
+		{"cr", strings.ReplaceAll(message, "\r\n", "\r"), message, []Change{{ChangeCR, 5}}},
+		{"crcrlf", strings.ReplaceAll(message, "\r\n", "\r\r\n"), message, []Change{{ChangeCRCRLF, 5}}},
+		{"mixed", strings.Replace(message, "\r\n", "\n", 2), message, []Change{{ChangeLF, 2}}},
+		{"bom", "\xef\xbb\xbf" + message, message, []Change{{ChangeBOM, 1}}},
+		{"from line", "From a@example.com Mon Jan  1 00:00:00 2024\n" + lf, message, []Change{{ChangeFromLine, 1}, {ChangeLF, 5}}},
+		{"bom and from line", "\xef\xbb\xbfFrom a@example.com Mon Jan  1 00:00:00 2024\r\n" + message, message, []Change{{ChangeBOM, 1}, {ChangeFromLine, 1}}},
+		{"dot lines", message + ".\r\n", message + ".\r\n", nil},
+	}
+	for _, tc := range cases {
+		got, changes := Normalize([]byte(tc.in))
+		if string(got) != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+		if len(changes) != len(tc.changes) {
+			t.Errorf("%s: got changes %v, want %v", tc.name, changes, tc.changes)
+			continue
+		}
+		for i := range changes {
+			if changes[i] != tc.changes[i] {
+				t.Errorf("%s: got changes %v, want %v", tc.name, changes, tc.changes)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalize_Unchanged(t *testing.T) {
+	in := []byte("Subject: x\r\n\r\nbody\r\n")
+	got, changes := Normalize(in)
+	if &got[0] != &in[0] || changes != nil {
+		t.Errorf("want a canonical message returned as it is, got %q %v", got, changes)
+	}
+	if s := (Change{Kind: ChangeLF, Count: 3}).String(); s != "converted 3 LF line endings to CRLF" {
+		t.Errorf("unexpected description %q", s)
+	}
+}
@@ -0,0 +1,20 @@
+package aboutmyemail
+
+import "context"
+
+// Report fetches the machine-readable analysis of a message that has
+// finished processing. It returns an *APIError matching ErrNotReady if the
+// message is still being processed.
+func (c *ClientWithResponses) Report(ctx context.Context, id string) (*Report, error) {
+	response, err := c.EmailReportWithResponse(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckResponse(response.HTTPResponse, response.Body); err != nil {
+		return nil, err
+	}
+	if response.JSON200 == nil {
+		return nil, unexpectedNil("email report")
+	}
+	return response.JSON200, nil
+}
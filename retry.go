@@ -0,0 +1,139 @@
+package aboutmyemail
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	mathrand "math/rand"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// RetryPolicy controls how a retrying HttpRequestDoer handles transient
+// failures. Zero fields take the defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of tries, including the first, default 4
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry, default 250ms. It
+	// doubles for each retry after that, with jitter.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts, default 10s. A longer
+	// Retry-After from the server is honoured up to this too.
+	MaxDelay time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = 4
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = 250 * time.Millisecond
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = 10 * time.Second
+	}
+	return p
+}
+
+// backoff returns the jittered delay before retry number attempt, counting from 1
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	delay := p.BaseDelay << (attempt - 1)
+	if delay <= 0 || delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	half := delay / 2
+	return half + time.Duration(mathrand.Int63n(int64(half)+1))
+}
+
+type retryDoer struct {
+	next   HttpRequestDoer
+	policy RetryPolicy
+}
+
+// NewRetryDoer wraps next so that requests failing with 429, 502, 503, 504 or
+// a dropped connection are retried with jittered exponential backoff. POST
+// requests are given an Idempotency-Key header, shared by all attempts, so
+// the server can recognise a retried submission.
+func NewRetryDoer(next HttpRequestDoer, policy RetryPolicy) HttpRequestDoer {
+	if next == nil {
+		next = &http.Client{}
+	}
+	return &retryDoer{next: next, policy: policy.withDefaults()}
+}
+
+// WithRetry retries transient failures according to policy. It wraps the
+// HttpRequestDoer configured so far, so should come after any WithHTTPClient.
+func WithRetry(policy RetryPolicy) ClientOption {
+	return func(c *Client) error {
+		c.Client = NewRetryDoer(c.Client, policy)
+		return nil
+	}
+}
+
+func (d *retryDoer) Do(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && req.Header.Get(idempotencyKeyHeader) == "" {
+		key, err := newIdempotencyKey()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Header.Set(idempotencyKeyHeader, key)
+	}
+	// We can only resend a request if we can rewind its body
+	canReplay := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	for attempt := 1; ; attempt++ {
+		if attempt > 1 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		if count, ok := req.Context().Value(attemptCountKey{}).(*int); ok {
+			*count = attempt
+		}
+		rsp, err := d.next.Do(req.WithContext(context.WithValue(req.Context(), attemptKey{}, attempt)))
+		if !canReplay || attempt >= d.policy.MaxAttempts || !shouldRetry(rsp, err) {
+			return rsp, err
+		}
+		delay := d.policy.backoff(attempt)
+		if rsp != nil {
+			if retryAfter := parseRetryAfter(rsp.Header.Get("Retry-After"), time.Now()); retryAfter > delay {
+				delay = min(retryAfter, d.policy.MaxDelay)
+			}
+			_, _ = io.Copy(io.Discard, rsp.Body)
+			_ = rsp.Body.Close()
+		}
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// shouldRetry reports whether a request that got this response or error is worth trying again
+func shouldRetry(rsp *http.Response, err error) bool {
+	if err != nil {
+		return errors.Is(err, syscall.ECONNRESET) ||
+			errors.Is(err, syscall.ECONNREFUSED) ||
+			errors.Is(err, io.EOF) ||
+			errors.Is(err, io.ErrUnexpectedEOF)
+	}
+	switch rsp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+func newIdempotencyKey() (string, error) {
+	var buf [16]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
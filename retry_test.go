@@ -0,0 +1,125 @@
+package aboutmyemail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyServer fails each request according to failures before accepting it,
+// recording the Idempotency-Key and body of every attempt.
+type flakyServer struct {
+	mtx      sync.Mutex
+	failures []int // status codes to return in turn, 0 to drop the connection
+	keys     []string
+	bodies   []string
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	f.mtx.Lock()
+	f.keys = append(f.keys, r.Header.Get(idempotencyKeyHeader))
+	f.bodies = append(f.bodies, string(body))
+	var failure = -1
+	if len(f.failures) > 0 {
+		failure = f.failures[0]
+		f.failures = f.failures[1:]
+	}
+	f.mtx.Unlock()
+	switch failure {
+	case -1:
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(SubmitSuccess{Id: "abc123"})
+	case 0:
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err == nil {
+			_ = conn.Close()
+		}
+	default:
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(failure)
+	}
+}
+
+func TestRetryDoer(t *testing.T) {
+	flaky := &flakyServer{failures: []int{http.StatusServiceUnavailable, 0, http.StatusTooManyRequests}}
+	ts := httptest.NewServer(flaky)
+	defer ts.Close()
+	client, err := New(WithServer(ts.URL), WithRetry(RetryPolicy{BaseDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := client.EmailWithResponse(ctx, Submit{From: "a@example.com", To: "b@example.com", Ip: "10.0.0.1", Payload: sampleEmail})
+	if err != nil {
+		t.Fatalf("EmailWithResponse failed: %v", err)
+	}
+	if response.JSON200 == nil || response.JSON200.Id != "abc123" {
+		t.Fatalf("want success after retries, got %s %s", response.Status(), response.Body)
+	}
+	if len(flaky.keys) != 4 {
+		t.Fatalf("want 4 attempts, got %d", len(flaky.keys))
+	}
+	for i := range flaky.keys {
+		if flaky.keys[i] == "" || flaky.keys[i] != flaky.keys[0] {
+			t.Errorf("attempt %d idempotency key '%s', want '%s'", i, flaky.keys[i], flaky.keys[0])
+		}
+		if flaky.bodies[i] != flaky.bodies[0] {
+			t.Errorf("attempt %d body differs from first attempt", i)
+		}
+	}
+}
+
+func TestRetryDoer_GivesUp(t *testing.T) {
+	flaky := &flakyServer{failures: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}}
+	ts := httptest.NewServer(flaky)
+	defer ts.Close()
+	client, err := New(WithServer(ts.URL), WithRetry(RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	response, err := client.EmailStatusWithResponse(context.Background(), "abc123")
+	if err != nil {
+		t.Fatalf("EmailStatusWithResponse failed: %v", err)
+	}
+	err = CheckResponse(response.HTTPResponse, response.Body)
+	if !errors.Is(err, ErrServerFailure) {
+		t.Errorf("want ErrServerFailure, got %v", err)
+	}
+	if len(flaky.keys) != 2 {
+		t.Errorf("want 2 attempts, got %d", len(flaky.keys))
+	}
+	if flaky.keys[0] != "" {
+		t.Errorf("want no idempotency key on GET, got '%s'", flaky.keys[0])
+	}
+}
+
+func TestRetryDoer_NotRetried(t *testing.T) {
+	flaky := &flakyServer{failures: []int{http.StatusInternalServerError}}
+	ts := httptest.NewServer(flaky)
+	defer ts.Close()
+	client, err := New(WithServer(ts.URL), WithRetry(RetryPolicy{BaseDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	response, err := client.EmailStatusWithResponse(context.Background(), "abc123")
+	if err != nil {
+		t.Fatalf("EmailStatusWithResponse failed: %v", err)
+	}
+	if response.StatusCode() != http.StatusInternalServerError {
+		t.Errorf("want 500 passed through, got %s", response.Status())
+	}
+	if len(flaky.keys) != 1 {
+		t.Errorf("want 1 attempt, got %d", len(flaky.keys))
+	}
+}
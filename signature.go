@@ -0,0 +1,84 @@
+package aboutmyemail
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CallbackSignatureHeader is the header carrying the signature of a callback
+// body, for submissions made with a CallbackSecret
+const CallbackSignatureHeader = "X-Myemail-Signature"
+
+// DefaultCallbackTolerance is how far a callback's signature timestamp may be
+// from our clock before VerifyCallback rejects it as a replay
+const DefaultCallbackTolerance = 5 * time.Minute
+
+var (
+	ErrSignatureMissing = errors.New("callback signature missing")
+	ErrSignatureInvalid = errors.New("callback signature invalid")
+	ErrSignatureExpired = errors.New("callback signature timestamp out of range")
+)
+
+func callbackMAC(secret string, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// SignCallback returns the CallbackSignatureHeader value for a callback body
+// sent at time t, signed with secret.
+func SignCallback(secret string, body []byte, t time.Time) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(callbackMAC(secret, ts, body))
+}
+
+// VerifyCallback checks that header is a valid signature of body with secret,
+// made within tolerance of now. A header may carry several v1 signatures, as
+// when the server is rotating secrets; any one matching is enough.
+func VerifyCallback(secret string, header string, body []byte, now time.Time, tolerance time.Duration) error {
+	if header == "" {
+		return ErrSignatureMissing
+	}
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			continue
+		}
+		switch k {
+		case "t":
+			timestamp = v
+		case "v1":
+			sig, err := hex.DecodeString(v)
+			if err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return ErrSignatureInvalid
+	}
+	secs, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	skew := now.Sub(time.Unix(secs, 0))
+	if skew > tolerance || skew < -tolerance {
+		return ErrSignatureExpired
+	}
+	expected := callbackMAC(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrSignatureInvalid
+}
@@ -0,0 +1,40 @@
+package aboutmyemail
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyCallback(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	body := []byte(`{"id":"abc123","token":"potato"}`)
+	good := SignCallback("s3cret", body, now)
+	rotated := SignCallback("old", body, now) + ",v1=" + good[len("t=1700000000,v1="):]
+	cases := []struct {
+		name   string
+		header string
+		body   []byte
+		at     time.Time
+		want   error
+	}{
+		{"valid", good, body, now, nil},
+		{"valid, within tolerance", good, body, now.Add(4 * time.Minute), nil},
+		{"rotated secret", rotated, body, now, nil},
+		{"missing", "", body, now, ErrSignatureMissing},
+		{"tampered body", good, []byte(`{"id":"abc123","token":"banana"}`), now, ErrSignatureInvalid},
+		{"wrong secret", SignCallback("guess", body, now), body, now, ErrSignatureInvalid},
+		{"stale", good, body, now.Add(10 * time.Minute), ErrSignatureExpired},
+		{"future", good, body, now.Add(-10 * time.Minute), ErrSignatureExpired},
+		{"garbage", "sausage", body, now, ErrSignatureInvalid},
+		{"no timestamp", "v1=00ff", body, now, ErrSignatureInvalid},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := VerifyCallback("s3cret", c.header, c.body, c.at, DefaultCallbackTolerance)
+			if !errors.Is(err, c.want) {
+				t.Errorf("want %v, got %v", c.want, err)
+			}
+		})
+	}
+}
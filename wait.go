@@ -0,0 +1,179 @@
+package aboutmyemail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const defaultPollInterval = time.Second
+const defaultThrottleDelay = 200 * time.Millisecond
+
+type waitConfig struct {
+	pollInterval  time.Duration
+	maxInterval   time.Duration
+	backoff       float64
+	throttleDelay time.Duration
+	onSubmitted   func(id string)
+	onMessage     func(msg string)
+	onThrottled   func(delay time.Duration)
+}
+
+// WaitOption configures how SubmitAndWait and Wait poll for a result
+type WaitOption func(*waitConfig)
+
+// WithPollInterval sets the delay between status polls, default one second
+func WithPollInterval(d time.Duration) WaitOption {
+	return func(c *waitConfig) {
+		if d > 0 {
+			c.pollInterval = d
+		}
+	}
+}
+
+// WithBackoff multiplies the poll interval by factor after each poll that
+// doesn't complete, up to max. A factor of 1 or less polls at a fixed rate.
+func WithBackoff(factor float64, max time.Duration) WaitOption {
+	return func(c *waitConfig) {
+		c.backoff = factor
+		c.maxInterval = max
+	}
+}
+
+// WithThrottleDelay sets how long to wait after the server throttles a poll,
+// default 200ms. The delay doubles on consecutive throttles, up to the
+// backoff limit.
+func WithThrottleDelay(d time.Duration) WaitOption {
+	return func(c *waitConfig) {
+		if d > 0 {
+			c.throttleDelay = d
+		}
+	}
+}
+
+// WithSubmitted calls fn with the result id once SubmitAndWait has submitted the message
+func WithSubmitted(fn func(id string)) WaitOption {
+	return func(c *waitConfig) {
+		c.onSubmitted = fn
+	}
+}
+
+// WithProgress calls fn for each progress message the server returns
+func WithProgress(fn func(msg string)) WaitOption {
+	return func(c *waitConfig) {
+		c.onMessage = fn
+	}
+}
+
+// WithThrottled calls fn each time the server throttles a poll, with the delay before the next one
+func WithThrottled(fn func(delay time.Duration)) WaitOption {
+	return func(c *waitConfig) {
+		c.onThrottled = fn
+	}
+}
+
+func newWaitConfig(opts []WaitOption) waitConfig {
+	cfg := waitConfig{
+		pollInterval:  defaultPollInterval,
+		throttleDelay: defaultThrottleDelay,
+		backoff:       1,
+	}
+	for _, o := range opts {
+		o(&cfg)
+	}
+	if cfg.maxInterval < cfg.pollInterval {
+		cfg.maxInterval = cfg.pollInterval
+	}
+	return cfg
+}
+
+// SubmitAndWait submits a message for processing then polls until the result
+// is available, or ctx is done. The returned StatusResult has a non-empty Url.
+func (c *ClientWithResponses) SubmitAndWait(ctx context.Context, body Submit, opts ...WaitOption) (*StatusResult, error) {
+	return c.submitAndWait(ctx, func() (*EmailResponse, error) {
+		return c.EmailWithResponse(ctx, body)
+	}, newWaitConfig(opts))
+}
+
+// submitAndWait makes a submission with submit then polls for its result
+func (c *ClientWithResponses) submitAndWait(ctx context.Context, submit func() (*EmailResponse, error), cfg waitConfig) (*StatusResult, error) {
+	response, err := submit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to submit email: %w", err)
+	}
+	if err := CheckResponse(response.HTTPResponse, response.Body); err != nil {
+		return nil, err
+	}
+	if response.JSON200 == nil {
+		return nil, unexpectedNil("email")
+	}
+	if cfg.onSubmitted != nil {
+		cfg.onSubmitted(response.JSON200.Id)
+	}
+	return c.wait(ctx, response.JSON200.Id, cfg)
+}
+
+// Wait polls for the result of a previously submitted message until it is
+// available, or ctx is done.
+func (c *ClientWithResponses) Wait(ctx context.Context, id string, opts ...WaitOption) (*StatusResult, error) {
+	return c.wait(ctx, id, newWaitConfig(opts))
+}
+
+func (c *ClientWithResponses) wait(ctx context.Context, id string, cfg waitConfig) (*StatusResult, error) {
+	interval := cfg.pollInterval
+	throttle := cfg.throttleDelay
+	for {
+		response, err := c.EmailStatusWithResponse(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("while polling for result: %w", err)
+		}
+		err = CheckResponse(response.HTTPResponse, response.Body)
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+			delay := max(throttle, apiErr.RetryAfter)
+			if cfg.onThrottled != nil {
+				cfg.onThrottled(delay)
+			}
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			throttle = min(2*throttle, max(cfg.maxInterval, cfg.throttleDelay))
+			continue
+		}
+		throttle = cfg.throttleDelay
+		if err != nil {
+			return nil, err
+		}
+		if response.JSON200 == nil {
+			return nil, unexpectedNil("email status")
+		}
+		if cfg.onMessage != nil && response.JSON200.Messages != nil {
+			for _, msg := range *response.JSON200.Messages {
+				cfg.onMessage(msg)
+			}
+		}
+		if response.JSON200.Url != nil && *response.JSON200.Url != "" {
+			return response.JSON200, nil
+		}
+		if err := sleepContext(ctx, interval); err != nil {
+			return nil, err
+		}
+		if cfg.backoff > 1 {
+			interval = min(time.Duration(float64(interval)*cfg.backoff), cfg.maxInterval)
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
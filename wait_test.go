@@ -0,0 +1,108 @@
+package aboutmyemail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const sampleEmail = `From: <steve@blighty.com>
+To: <steve@blighty.com>
+Subject: test sausage
+
+body
+`
+
+// waitServer accepts one submission, throttles the first status poll, then
+// returns a progress message per poll until the result is ready.
+func waitServer(t *testing.T, polls int) *httptest.Server {
+	var mtx sync.Mutex
+	count := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/emails") {
+			_ = json.NewEncoder(w).Encode(SubmitSuccess{Id: "abc123"})
+			return
+		}
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/emails/abc123") {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		mtx.Lock()
+		count++
+		n := count
+		mtx.Unlock()
+		if n == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_ = json.NewEncoder(w).Encode(N400Error{Message: "slow down"})
+			return
+		}
+		result := StatusResult{Id: "abc123", Messages: &[]string{"step " + strconv.Itoa(n)}}
+		if n >= polls {
+			url := "https://aboutmy.email/abc123"
+			result.Url = &url
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	}))
+}
+
+func TestSubmitAndWait(t *testing.T) {
+	ts := waitServer(t, 4)
+	defer ts.Close()
+	client, err := New(WithServer(ts.URL + "/api/v1"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var submitted string
+	var messages []string
+	throttled := 0
+	result, err := client.SubmitAndWait(ctx, Submit{From: "a@example.com", To: "b@example.com", Ip: "10.0.0.1", Payload: sampleEmail},
+		WithPollInterval(time.Millisecond),
+		WithThrottleDelay(time.Millisecond),
+		WithSubmitted(func(id string) { submitted = id }),
+		WithProgress(func(msg string) { messages = append(messages, msg) }),
+		WithThrottled(func(time.Duration) { throttled++ }))
+	if err != nil {
+		t.Fatalf("SubmitAndWait failed: %v", err)
+	}
+	if submitted != "abc123" {
+		t.Errorf("submitted id want 'abc123', got '%s'", submitted)
+	}
+	if throttled != 1 {
+		t.Errorf("want 1 throttle, got %d", throttled)
+	}
+	if want := []string{"step 2", "step 3", "step 4"}; !slices.Equal(messages, want) {
+		t.Errorf("messages want %q, got %q", want, messages)
+	}
+	if result.Url == nil || *result.Url != "https://aboutmy.email/abc123" {
+		t.Errorf("unexpected result url %v", result.Url)
+	}
+}
+
+func TestWait_ContextDone(t *testing.T) {
+	ts := waitServer(t, 1000)
+	defer ts.Close()
+	client, err := New(WithServer(ts.URL + "/api/v1"))
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err = client.Wait(ctx, "abc123", WithPollInterval(time.Millisecond), WithBackoff(2, 10*time.Millisecond))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("want context.DeadlineExceeded, got %v", err)
+	}
+}